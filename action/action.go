@@ -0,0 +1,535 @@
+// Package action makes changes to the forum on behalf of a user. The actions are
+// shared by the HTML pages, the JSON API and the command line tool, so that all
+// apply the same validation, permissions and side effects (eg webhooks).
+package action
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/pdk/forum/broker"
+	"github.com/pdk/forum/hook"
+	"github.com/pdk/forum/metrics"
+	"github.com/pdk/forum/model"
+	"github.com/pdk/forum/store"
+)
+
+var (
+	// ErrForbidden indicates the current user may not make the change.
+	ErrForbidden = errors.New("not permitted")
+
+	// ErrBanned indicates the current user has been banned.
+	ErrBanned = fmt.Errorf("%w: user is banned", ErrForbidden)
+
+	// ErrThreadLocked indicates the thread is locked against new posts.
+	ErrThreadLocked = fmt.Errorf("%w: thread is locked", ErrForbidden)
+)
+
+// Operator is the user for changes made with the command line tool, by whoever
+// runs the forum. It is an admin, but cannot own anything.
+var Operator = model.User{Name: "operator", Role: model.RoleAdmin}
+
+// Actions holds what the actions need. Hooks, Events and Metrics may be nil.
+type Actions struct {
+	Store   store.Store
+	Hooks   *hook.Dispatcher
+	Events  *broker.Broker
+	Metrics *metrics.Registry
+
+	// Admins are the names of users who are admins, whatever their role.
+	Admins []string
+}
+
+// Counters of what happens in the forum, for Metrics.
+const (
+	signInsTotal        = "forum_sign_ins_total"
+	usersJoinedTotal    = "forum_users_joined_total"
+	topicsCreatedTotal  = "forum_topics_created_total"
+	threadsCreatedTotal = "forum_threads_created_total"
+	postsCreatedTotal   = "forum_posts_created_total"
+)
+
+var counterHelp = map[string]string{
+	signInsTotal:        "Successful sign ins.",
+	usersJoinedTotal:    "Users who have joined.",
+	topicsCreatedTotal:  "Topics created.",
+	threadsCreatedTotal: "Threads created.",
+	postsCreatedTotal:   "Posts created, including the first post of each thread.",
+}
+
+// RegisterCounters registers the counters, so that they show 0 before
+// anything has happened.
+func RegisterCounters(registry *metrics.Registry) {
+	for name, help := range counterHelp {
+		registry.Counter(name, help)
+	}
+}
+
+// count adds 1 to one of the counters.
+func (a Actions) count(name string) {
+	a.Metrics.Counter(name, counterHelp[name]).Inc()
+}
+
+// IsAdmin returns true if the user has the admin role, or is one of the
+// configured admins.
+func (a Actions) IsAdmin(user model.User) bool {
+
+	if user.Role == model.RoleAdmin {
+		return true
+	}
+
+	for _, admin := range a.Admins {
+		if admin == user.Name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsModerator returns true if the user may manage other users' content.
+func (a Actions) IsModerator(user model.User) bool {
+	return user.IsModerator() || a.IsAdmin(user)
+}
+
+// mayEdit returns true if the user may change something created by ownerID.
+func (a Actions) mayEdit(user model.User, ownerID int64) bool {
+	return user.ID == ownerID || a.IsModerator(user)
+}
+
+// notBanned returns ErrBanned if the user has been banned.
+func notBanned(user model.User) error {
+
+	if user.Banned {
+		return ErrBanned
+	}
+
+	return nil
+}
+
+// SignIn finds or creates the named user.
+func (a Actions) SignIn(ctx context.Context, name string) (model.User, error) {
+
+	user := model.NewUser(strings.TrimSpace(name))
+	err := user.Validate()
+	if err != nil {
+		return user, err
+	}
+
+	user, created, err := a.Store.GetOrCreateUserByName(ctx, user.Name)
+	if err != nil {
+		return user, err
+	}
+
+	a.count(signInsTotal)
+	if created {
+		a.count(usersJoinedTotal)
+		a.Hooks.Fire(hook.UserJoined, user)
+	}
+
+	return user, nil
+}
+
+// CreateUser saves a new user. Unlike SignIn, it is an error if the name is
+// already taken.
+func (a Actions) CreateUser(ctx context.Context, name string) (model.User, error) {
+
+	user := model.NewUser(strings.TrimSpace(name))
+	err := user.Validate()
+	if err != nil {
+		return user, err
+	}
+
+	user, err = a.Store.CreateUser(ctx, user)
+	if err != nil {
+		return user, err
+	}
+
+	a.count(usersJoinedTotal)
+	a.Hooks.Fire(hook.UserJoined, user)
+
+	return user, nil
+}
+
+// RenameUser changes a user's name. Users may rename themselves, and admins may
+// rename anyone.
+func (a Actions) RenameUser(ctx context.Context, user model.User, userID int64, name string) (model.User, error) {
+
+	target, err := a.Store.GetUserByID(ctx, userID)
+	if err != nil {
+		return target, fmt.Errorf("cannot get user %d: %w", userID, err)
+	}
+
+	err = notBanned(user)
+	if err != nil {
+		return target, err
+	}
+
+	if user.ID != target.ID && !a.IsAdmin(user) {
+		return target, ErrForbidden
+	}
+
+	target.Name = strings.TrimSpace(name)
+	err = target.Validate()
+	if err != nil {
+		return target, err
+	}
+
+	return target, a.Store.UpdateUser(ctx, target)
+}
+
+// BanUser bans (or lifts the ban on) a user. Banned users may still read, but
+// cannot make changes. Only admins may ban users.
+func (a Actions) BanUser(ctx context.Context, user model.User, userID int64, banned bool) (model.User, error) {
+
+	target, err := a.Store.GetUserByID(ctx, userID)
+	if err != nil {
+		return target, fmt.Errorf("cannot get user %d: %w", userID, err)
+	}
+
+	if !a.IsAdmin(user) || user.ID == target.ID {
+		return target, ErrForbidden
+	}
+
+	target.Banned = banned
+
+	return target, a.Store.UpdateUser(ctx, target)
+}
+
+// SetUserRole changes the role of a user. Only admins may change roles.
+func (a Actions) SetUserRole(ctx context.Context, user model.User, userID int64, role string) (model.User, error) {
+
+	target, err := a.Store.GetUserByID(ctx, userID)
+	if err != nil {
+		return target, fmt.Errorf("cannot get user %d: %w", userID, err)
+	}
+
+	if !a.IsAdmin(user) {
+		return target, ErrForbidden
+	}
+
+	target.Role = strings.TrimSpace(role)
+	err = target.Validate()
+	if err != nil {
+		return target, err
+	}
+
+	return target, a.Store.UpdateUser(ctx, target)
+}
+
+// CreateTopic saves a new topic.
+func (a Actions) CreateTopic(ctx context.Context, user model.User, name string) (model.Topic, error) {
+
+	topic := model.NewTopic(user.ID, strings.TrimSpace(name))
+
+	err := notBanned(user)
+	if err != nil {
+		return topic, err
+	}
+
+	err = topic.Validate()
+	if err != nil {
+		return topic, err
+	}
+
+	topic, err = a.Store.CreateTopic(ctx, topic)
+	if err != nil {
+		return topic, err
+	}
+
+	a.count(topicsCreatedTotal)
+	a.Hooks.Fire(hook.TopicCreated, topic)
+
+	return topic, nil
+}
+
+// RenameTopic changes the name of a topic.
+func (a Actions) RenameTopic(ctx context.Context, user model.User, topicID int64, name string) (model.Topic, error) {
+
+	topic, err := a.Store.GetTopicByID(ctx, topicID)
+	if err != nil {
+		return topic, err
+	}
+
+	err = notBanned(user)
+	if err != nil {
+		return topic, err
+	}
+
+	if !a.mayEdit(user, topic.CreatedByID) {
+		return topic, ErrForbidden
+	}
+
+	topic.Name = strings.TrimSpace(name)
+	err = topic.Validate()
+	if err != nil {
+		return topic, err
+	}
+
+	return topic, a.Store.UpdateTopic(ctx, topic)
+}
+
+// DeleteTopic deletes a topic, with all of its threads and posts. Only
+// moderators may delete topics.
+func (a Actions) DeleteTopic(ctx context.Context, user model.User, topicID int64) (model.Topic, error) {
+
+	topic, err := a.Store.GetTopicByID(ctx, topicID)
+	if err != nil {
+		return topic, err
+	}
+
+	if !a.IsModerator(user) {
+		return topic, ErrForbidden
+	}
+
+	return topic, a.Store.DeleteTopic(ctx, topic.ID)
+}
+
+// CreateThread saves a new thread in a topic, along with its first post.
+func (a Actions) CreateThread(ctx context.Context, user model.User, topicID int64, subject, body string) (model.Thread, model.Post, error) {
+
+	thread := model.NewThread(topicID, user.ID, strings.TrimSpace(subject))
+	post := model.NewPost(0, user.ID, strings.TrimSpace(body))
+
+	err := notBanned(user)
+	if err != nil {
+		return thread, post, err
+	}
+
+	err = thread.Validate()
+	if err != nil {
+		return thread, post, err
+	}
+
+	err = post.Validate()
+	if err != nil {
+		return thread, post, err
+	}
+
+	// the thread and its first post are saved together, or not at all.
+	err = a.Store.WithTx(ctx, func(tx store.Store) error {
+
+		topic, err := tx.GetTopicByID(ctx, topicID)
+		if err != nil {
+			return fmt.Errorf("cannot get topic to create new thread: %w", err)
+		}
+
+		thread.TopicID = topic.ID
+		thread, err = tx.CreateThread(ctx, thread)
+		if err != nil {
+			return fmt.Errorf("cannot save new thread: %w", err)
+		}
+
+		post.ThreadID = thread.ID
+		post, err = tx.CreatePost(ctx, post)
+		if err != nil {
+			return fmt.Errorf("cannot save first post of new thread: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return thread, post, err
+	}
+
+	a.count(threadsCreatedTotal)
+	a.count(postsCreatedTotal)
+	a.Events.Publish(post)
+	a.Hooks.Fire(hook.ThreadCreated, map[string]interface{}{
+		"thread": thread,
+		"post":   post,
+	})
+	a.Hooks.Fire(hook.PostCreated, post)
+
+	return thread, post, nil
+}
+
+// EditThread changes the subject of a thread.
+func (a Actions) EditThread(ctx context.Context, user model.User, threadID int64, subject string) (model.Thread, error) {
+
+	thread, err := a.Store.GetThreadByID(ctx, threadID)
+	if err != nil {
+		return thread, err
+	}
+
+	err = a.mayChangeThread(user, thread)
+	if err != nil {
+		return thread, err
+	}
+
+	if !a.mayEdit(user, thread.CreatedByID) {
+		return thread, ErrForbidden
+	}
+
+	thread.Subject = strings.TrimSpace(subject)
+	err = thread.Validate()
+	if err != nil {
+		return thread, err
+	}
+
+	return thread, a.Store.UpdateThread(ctx, thread)
+}
+
+// MoveThread moves a thread to another topic. Only moderators may move threads.
+func (a Actions) MoveThread(ctx context.Context, user model.User, threadID, topicID int64) (model.Thread, error) {
+
+	thread, err := a.Store.GetThreadByID(ctx, threadID)
+	if err != nil {
+		return thread, err
+	}
+
+	if !a.IsModerator(user) {
+		return thread, ErrForbidden
+	}
+
+	err = a.Store.WithTx(ctx, func(tx store.Store) error {
+
+		topic, err := tx.GetTopicByID(ctx, topicID)
+		if err != nil {
+			return fmt.Errorf("cannot get topic to move thread to: %w", err)
+		}
+
+		thread.TopicID = topic.ID
+
+		return tx.UpdateThread(ctx, thread)
+	})
+
+	return thread, err
+}
+
+// LockThread locks (or unlocks) a thread. Only moderators may post in, or edit,
+// a locked thread, and only moderators may lock threads.
+func (a Actions) LockThread(ctx context.Context, user model.User, threadID int64, locked bool) (model.Thread, error) {
+
+	thread, err := a.Store.GetThreadByID(ctx, threadID)
+	if err != nil {
+		return thread, err
+	}
+
+	if !a.IsModerator(user) {
+		return thread, ErrForbidden
+	}
+
+	thread.Locked = locked
+
+	return thread, a.Store.UpdateThread(ctx, thread)
+}
+
+// mayChangeThread returns an error if the user may not add or change posts in
+// the thread, because they are banned, or the thread is locked.
+func (a Actions) mayChangeThread(user model.User, thread model.Thread) error {
+
+	err := notBanned(user)
+	if err != nil {
+		return err
+	}
+
+	if thread.Locked && !a.IsModerator(user) {
+		return ErrThreadLocked
+	}
+
+	return nil
+}
+
+// CreatePost adds a post to a thread.
+func (a Actions) CreatePost(ctx context.Context, user model.User, threadID int64, body string) (model.Thread, model.Post, error) {
+
+	post := model.NewPost(threadID, user.ID, strings.TrimSpace(body))
+	err := post.Validate()
+	if err != nil {
+		return model.Thread{}, post, err
+	}
+
+	var thread model.Thread
+
+	// the thread cannot be locked between checking and posting, since the
+	// transaction holds it (see GetThreadForUpdate).
+	err = a.Store.WithTx(ctx, func(tx store.Store) error {
+
+		var err error
+		thread, err = tx.GetThreadForUpdate(ctx, threadID)
+		if err != nil {
+			return err
+		}
+
+		err = a.mayChangeThread(user, thread)
+		if err != nil {
+			return err
+		}
+
+		post, err = tx.CreatePost(ctx, post)
+		if err != nil {
+			return fmt.Errorf("cannot save new post: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return thread, post, err
+	}
+
+	a.count(postsCreatedTotal)
+	a.Events.Publish(post)
+	a.Hooks.Fire(hook.PostCreated, post)
+
+	return thread, post, nil
+}
+
+// EditPost changes the body of a post.
+func (a Actions) EditPost(ctx context.Context, user model.User, postID int64, body string) (model.Post, error) {
+
+	post, err := a.Store.GetPostByID(ctx, postID)
+	if err != nil {
+		return post, err
+	}
+
+	thread, err := a.Store.GetThreadByID(ctx, post.ThreadID)
+	if err != nil {
+		return post, err
+	}
+
+	err = a.mayChangeThread(user, thread)
+	if err != nil {
+		return post, err
+	}
+
+	if !a.mayEdit(user, post.PostedByID) {
+		return post, ErrForbidden
+	}
+
+	post.Body = strings.TrimSpace(body)
+	err = post.Validate()
+	if err != nil {
+		return post, err
+	}
+
+	return post, a.Store.UpdatePost(ctx, post)
+}
+
+// DeletePost deletes a post. Users may delete their own posts, and moderators
+// may delete any post.
+func (a Actions) DeletePost(ctx context.Context, user model.User, postID int64) (model.Post, error) {
+
+	post, err := a.Store.GetPostByID(ctx, postID)
+	if err != nil {
+		return post, err
+	}
+
+	thread, err := a.Store.GetThreadByID(ctx, post.ThreadID)
+	if err != nil {
+		return post, err
+	}
+
+	err = a.mayChangeThread(user, thread)
+	if err != nil {
+		return post, err
+	}
+
+	if !a.mayEdit(user, post.PostedByID) {
+		return post, ErrForbidden
+	}
+
+	return post, a.Store.DeletePost(ctx, post.ID)
+}
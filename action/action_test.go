@@ -0,0 +1,89 @@
+package action_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/pdk/forum/action"
+	"github.com/pdk/forum/model"
+	"github.com/pdk/forum/store"
+)
+
+func TestModeration(t *testing.T) {
+
+	ctx := context.Background()
+
+	db, _ := store.Open(":memory:")
+	defer db.Close()
+
+	_, err := db.MigrateUp(ctx)
+	if err != nil {
+		t.Fatalf("expected to migrate, but failed: %v", err)
+	}
+
+	a := action.Actions{Store: db}
+
+	alice, _ := a.CreateUser(ctx, "alice")
+	bob, _ := a.CreateUser(ctx, "bob")
+
+	topic, err := a.CreateTopic(ctx, alice, "golang")
+	if err != nil {
+		t.Fatalf("expected to create topic, but failed: %v", err)
+	}
+
+	thread, _, err := a.CreateThread(ctx, alice, topic.ID, "hello", "first post")
+	if err != nil {
+		t.Fatalf("expected to create thread, but failed: %v", err)
+	}
+
+	_, err = a.LockThread(ctx, bob, thread.ID, true)
+	if !errors.Is(err, action.ErrForbidden) {
+		t.Errorf("expected a member to be forbidden to lock, but got %v", err)
+	}
+
+	bob, err = a.SetUserRole(ctx, action.Operator, bob.ID, model.RoleModerator)
+	if err != nil {
+		t.Fatalf("expected to make bob a moderator, but failed: %v", err)
+	}
+
+	_, err = a.LockThread(ctx, bob, thread.ID, true)
+	if err != nil {
+		t.Fatalf("expected a moderator to lock, but failed: %v", err)
+	}
+
+	_, _, err = a.CreatePost(ctx, alice, thread.ID, "more")
+	if !errors.Is(err, action.ErrThreadLocked) {
+		t.Errorf("expected ErrThreadLocked, but got %v", err)
+	}
+
+	_, _, err = a.CreatePost(ctx, bob, thread.ID, "closing this")
+	if err != nil {
+		t.Errorf("expected a moderator to post in a locked thread, but failed: %v", err)
+	}
+
+	_, err = a.BanUser(ctx, bob, alice.ID, true)
+	if !errors.Is(err, action.ErrForbidden) {
+		t.Errorf("expected a moderator to be forbidden to ban, but got %v", err)
+	}
+
+	alice, err = a.BanUser(ctx, action.Operator, alice.ID, true)
+	if err != nil {
+		t.Fatalf("expected to ban alice, but failed: %v", err)
+	}
+
+	_, err = a.CreateTopic(ctx, alice, "rust")
+	if !errors.Is(err, action.ErrBanned) {
+		t.Errorf("expected ErrBanned, but got %v", err)
+	}
+
+	_, err = a.DeleteTopic(ctx, bob, topic.ID)
+	if err != nil {
+		t.Errorf("expected a moderator to delete a topic, but failed: %v", err)
+	}
+
+	threads, _ := db.ListThreadsByTopicID(ctx, topic.ID, store.AllRows)
+	if len(threads) != 0 {
+		t.Errorf("expected the topic's threads to be deleted, but got %d", len(threads))
+	}
+}
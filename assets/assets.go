@@ -0,0 +1,9 @@
+// Package assets holds the templates and static files, built into the binary.
+package assets
+
+import "embed"
+
+// FS contains the templates and static directories.
+//
+//go:embed templates static
+var FS embed.FS
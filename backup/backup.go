@@ -0,0 +1,172 @@
+// Package backup takes consistent copies of the sqlite database while the
+// server is running, using VACUUM INTO, and checks them.
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pdk/forum/logging"
+	"github.com/pdk/forum/store"
+)
+
+// ErrNotSQLite indicates the database is not sqlite. Use the database's own
+// tools (eg pg_dump) to back up others.
+var ErrNotSQLite = errors.New("backups are only for sqlite databases")
+
+// Snapshots in a directory are named with this prefix and suffix, and the time
+// they were taken, so that they sort oldest first. The time has microseconds,
+// so that snapshots taken in the same second do not share a name.
+const (
+	snapshotPrefix = "forum-"
+	snapshotSuffix = ".db"
+	snapshotTime   = "20060102-150405.000000"
+)
+
+// Snapshot writes a copy of the database to dest, which must not already exist,
+// and verifies it. The copy is written to a temporary file first, so dest only
+// appears if the copy is good.
+func Snapshot(ctx context.Context, db *store.SQLStore, dest string) error {
+
+	if db.Driver() != store.SQLite {
+		return ErrNotSQLite
+	}
+
+	_, err := os.Stat(dest)
+	if err == nil {
+		return fmt.Errorf("cannot back up to %s: file exists", dest)
+	}
+
+	tmp := filepath.Join(filepath.Dir(dest), "."+filepath.Base(dest)+".tmp")
+	os.Remove(tmp)
+
+	_, err = db.DB().ExecContext(ctx, `vacuum into ?`, tmp)
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("cannot back up to %s: %w", dest, err)
+	}
+
+	err = Verify(ctx, tmp)
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("backup to %s is bad: %w", dest, err)
+	}
+
+	err = os.Rename(tmp, dest)
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("cannot back up to %s: %w", dest, err)
+	}
+
+	return nil
+}
+
+// Verify runs sqlite's integrity check on a database file.
+func Verify(ctx context.Context, fileName string) error {
+
+	db, err := store.NewConnection("file:" + fileName + "?mode=ro")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	rows, err := db.QueryContext(ctx, `pragma integrity_check`)
+	if err != nil {
+		return fmt.Errorf("cannot check %s: %w", fileName, err)
+	}
+	defer rows.Close()
+
+	problems := []string{}
+	for rows.Next() {
+		var result string
+		err = rows.Scan(&result)
+		if err != nil {
+			return fmt.Errorf("cannot check %s: %w", fileName, err)
+		}
+		if result != "ok" {
+			problems = append(problems, result)
+		}
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return fmt.Errorf("cannot check %s: %w", fileName, err)
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("integrity check of %s failed: %s", fileName, strings.Join(problems, "; "))
+	}
+
+	return nil
+}
+
+// Rotate takes a new snapshot in dir, then removes the oldest snapshots so that
+// at most keep remain. Returns the name of the new snapshot.
+func Rotate(ctx context.Context, db *store.SQLStore, dir string, keep int) (string, error) {
+
+	dest := filepath.Join(dir, snapshotPrefix+time.Now().UTC().Format(snapshotTime)+snapshotSuffix)
+
+	err := Snapshot(ctx, db, dest)
+	if err != nil {
+		return "", err
+	}
+
+	snapshots, err := Snapshots(dir)
+	if err != nil {
+		return dest, err
+	}
+
+	removals := []error{}
+	for len(snapshots) > keep {
+		err = os.Remove(snapshots[0])
+		if err != nil {
+			removals = append(removals, fmt.Errorf("cannot remove old backup: %w", err))
+		}
+		snapshots = snapshots[1:]
+	}
+
+	return dest, errors.Join(removals...)
+}
+
+// Snapshots lists the snapshots in dir, oldest first.
+func Snapshots(dir string) ([]string, error) {
+
+	names, err := filepath.Glob(filepath.Join(dir, snapshotPrefix+"*"+snapshotSuffix))
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// Schedule calls Rotate every interval, until ctx is done. Failures are logged.
+func Schedule(ctx context.Context, db *store.SQLStore, dir string, keep int, interval time.Duration) {
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		start := time.Now()
+		dest, err := Rotate(ctx, db, dir, keep)
+		if err != nil {
+			logging.Default().Error("scheduled backup failed", "error", err)
+			continue
+		}
+
+		logging.Default().Info("backed up database", "file", dest, "duration", time.Since(start).Round(time.Millisecond))
+	}
+}
@@ -0,0 +1,104 @@
+package backup_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pdk/forum/backup"
+	"github.com/pdk/forum/model"
+	"github.com/pdk/forum/store"
+)
+
+func TestSnapshot(t *testing.T) {
+
+	ctx := context.Background()
+
+	db, _ := store.Open(filepath.Join(t.TempDir(), "forum.db"))
+	defer db.Close()
+
+	_, err := db.MigrateUp(ctx)
+	if err != nil {
+		t.Fatalf("expected to migrate, but failed: %v", err)
+	}
+
+	db.CreateUser(ctx, model.NewUser("pdk"))
+
+	dest := filepath.Join(t.TempDir(), "copy.db")
+
+	err = backup.Snapshot(ctx, db, dest)
+	if err != nil {
+		t.Fatalf("expected to back up, but failed: %v", err)
+	}
+
+	copied, _ := store.Open(dest)
+	defer copied.Close()
+
+	_, err = copied.GetUserByName(ctx, "pdk")
+	if err != nil {
+		t.Errorf("expected to find user in backup, but failed: %v", err)
+	}
+
+	err = backup.Snapshot(ctx, db, dest)
+	if err == nil {
+		t.Errorf("expected not to overwrite an existing backup")
+	}
+
+	dir := t.TempDir()
+
+	_, err = backup.Rotate(ctx, db, dir, 1)
+	if err != nil {
+		t.Fatalf("expected to rotate, but failed: %v", err)
+	}
+
+	snapshots, _ := backup.Snapshots(dir)
+	if len(snapshots) != 1 {
+		t.Errorf("expected 1 snapshot, but got %d", len(snapshots))
+	}
+}
+
+func TestRotate(t *testing.T) {
+
+	ctx := context.Background()
+
+	db, _ := store.Open(filepath.Join(t.TempDir(), "forum.db"))
+	defer db.Close()
+
+	_, err := db.MigrateUp(ctx)
+	if err != nil {
+		t.Fatalf("expected to migrate, but failed: %v", err)
+	}
+
+	dir := t.TempDir()
+
+	oldest := filepath.Join(dir, "forum-20000101-000000.000000.db")
+	other := filepath.Join(dir, "notes.txt")
+	for _, name := range []string{oldest, other} {
+		err = os.WriteFile(name, []byte("x"), 0600)
+		if err != nil {
+			t.Fatalf("expected to write %s, but failed: %v", name, err)
+		}
+	}
+
+	taken := []string{}
+	for i := 0; i < 3; i++ {
+		dest, err := backup.Rotate(ctx, db, dir, 2)
+		if err != nil {
+			t.Fatalf("expected to rotate, but failed: %v", err)
+		}
+		taken = append(taken, dest)
+	}
+
+	snapshots, _ := backup.Snapshots(dir)
+	expected := strings.Join(taken[1:], " ")
+	if strings.Join(snapshots, " ") != expected {
+		t.Errorf("expected the newest 2 snapshots %s to remain, but got %v", expected, snapshots)
+	}
+
+	_, err = os.Stat(other)
+	if err != nil {
+		t.Errorf("expected other files to remain, but got %v", err)
+	}
+}
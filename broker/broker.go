@@ -0,0 +1,164 @@
+// Package broker passes new posts to subscribers watching a thread, eg for
+// live updates in the browser.
+package broker
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/pdk/forum/model"
+)
+
+// subscriptionBuffer is the number of posts which may be waiting for a slow
+// subscriber. If it fills, the subscription is closed, and the subscriber is
+// expected to reconnect and catch up from the database.
+const subscriptionBuffer = 16
+
+var (
+	// ErrTooManySubscribers indicates the broker is at its limit of
+	// subscribers.
+	ErrTooManySubscribers = errors.New("too many subscribers")
+	// ErrClosed indicates the broker has been shut down.
+	ErrClosed = errors.New("broker closed")
+)
+
+// Subscription receives the posts published to one thread. Posts is closed
+// when the subscription ends.
+type Subscription struct {
+	ThreadID int64
+	Posts    <-chan model.Post
+
+	posts chan model.Post
+}
+
+// Broker fans out published posts to the subscribers of each thread. A nil
+// *Broker is valid: publishing does nothing, and subscribing fails.
+type Broker struct {
+	mu             sync.Mutex
+	maxSubscribers int
+	count          int
+	closed         bool
+	threads        map[int64]map[*Subscription]bool
+}
+
+// NewBroker returns a Broker which allows at most maxSubscribers subscribers
+// at one time.
+func NewBroker(maxSubscribers int) *Broker {
+	return &Broker{
+		maxSubscribers: maxSubscribers,
+		threads:        map[int64]map[*Subscription]bool{},
+	}
+}
+
+// Subscribe starts receiving the posts published to a thread. The caller must
+// call Unsubscribe when done.
+func (b *Broker) Subscribe(threadID int64) (*Subscription, error) {
+
+	if b == nil {
+		return nil, ErrClosed
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return nil, ErrClosed
+	}
+
+	if b.count >= b.maxSubscribers {
+		return nil, ErrTooManySubscribers
+	}
+
+	posts := make(chan model.Post, subscriptionBuffer)
+	sub := &Subscription{
+		ThreadID: threadID,
+		Posts:    posts,
+		posts:    posts,
+	}
+
+	if b.threads[threadID] == nil {
+		b.threads[threadID] = map[*Subscription]bool{}
+	}
+	b.threads[threadID][sub] = true
+	b.count++
+
+	return sub, nil
+}
+
+// Unsubscribe ends a subscription. It is safe to call more than once.
+func (b *Broker) Unsubscribe(sub *Subscription) {
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.remove(sub)
+}
+
+// Publish sends a post to the subscribers of its thread. It never blocks:
+// subscribers which have fallen behind are dropped.
+func (b *Broker) Publish(post model.Post) {
+
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for sub := range b.threads[post.ThreadID] {
+		select {
+		case sub.posts <- post:
+		default:
+			b.remove(sub)
+		}
+	}
+}
+
+// Count returns the number of current subscribers.
+func (b *Broker) Count() int {
+
+	if b == nil {
+		return 0
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.count
+}
+
+// Close ends all subscriptions, and refuses any new ones.
+func (b *Broker) Close() {
+
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, subs := range b.threads {
+		for sub := range subs {
+			b.remove(sub)
+		}
+	}
+
+	b.closed = true
+}
+
+// remove must be called with the lock held.
+func (b *Broker) remove(sub *Subscription) {
+
+	subs := b.threads[sub.ThreadID]
+	if !subs[sub] {
+		return
+	}
+
+	delete(subs, sub)
+	if len(subs) == 0 {
+		delete(b.threads, sub.ThreadID)
+	}
+
+	close(sub.posts)
+	b.count--
+}
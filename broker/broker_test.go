@@ -0,0 +1,79 @@
+package broker_test
+
+import (
+	"testing"
+
+	"github.com/pdk/forum/broker"
+	"github.com/pdk/forum/model"
+)
+
+func TestPublishSubscribe(t *testing.T) {
+
+	b := broker.NewBroker(2)
+
+	sub1, err := b.Subscribe(1)
+	if err != nil {
+		t.Fatalf("expected to subscribe, but failed: %v", err)
+	}
+
+	sub2, err := b.Subscribe(2)
+	if err != nil {
+		t.Fatalf("expected to subscribe, but failed: %v", err)
+	}
+
+	_, err = b.Subscribe(1)
+	if err != broker.ErrTooManySubscribers {
+		t.Errorf("expected ErrTooManySubscribers, but got %v", err)
+	}
+
+	b.Publish(model.Post{ID: 10, ThreadID: 1})
+
+	post := <-sub1.Posts
+	if post.ID != 10 {
+		t.Errorf("expected post 10, but got %d", post.ID)
+	}
+
+	if len(sub2.Posts) != 0 {
+		t.Errorf("expected no posts for thread 2, but got %d", len(sub2.Posts))
+	}
+
+	b.Unsubscribe(sub1)
+	b.Unsubscribe(sub1)
+	if b.Count() != 1 {
+		t.Errorf("expected 1 subscriber, but got %d", b.Count())
+	}
+
+	b.Close()
+	if _, ok := <-sub2.Posts; ok {
+		t.Errorf("expected subscription to be closed")
+	}
+
+	_, err = b.Subscribe(1)
+	if err != broker.ErrClosed {
+		t.Errorf("expected ErrClosed, but got %v", err)
+	}
+}
+
+func TestSlowSubscriberDropped(t *testing.T) {
+
+	b := broker.NewBroker(10)
+
+	sub, _ := b.Subscribe(1)
+
+	for i := 0; i < 100; i++ {
+		b.Publish(model.Post{ID: int64(i), ThreadID: 1})
+	}
+
+	if b.Count() != 0 {
+		t.Errorf("expected slow subscriber to be dropped, but have %d subscribers", b.Count())
+	}
+
+	count := 0
+	for range sub.Posts {
+		count++
+	}
+
+	if count == 0 || count == 100 {
+		t.Errorf("expected some buffered posts before close, but got %d", count)
+	}
+}
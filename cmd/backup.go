@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/pdk/forum/backup"
+	"github.com/pdk/forum/conf"
+)
+
+// backUp copies the database, safely while the server is running. With no
+// argument, or a directory, it adds a snapshot to BackupDir (or the directory),
+// and removes the oldest beyond BackupKeep. Otherwise it copies to the named
+// file.
+func backUp(ctx context.Context, config conf.Configuration, args []string) error {
+
+	if len(args) > 1 {
+		return fmt.Errorf("usage: forum backup [file or directory]")
+	}
+
+	dest := config.BackupDir
+	if len(args) == 1 {
+		dest = args[0]
+	}
+
+	if dest == "" {
+		return fmt.Errorf("no BackupDir configured: usage: forum backup [file or directory]")
+	}
+
+	db, err := openDatabase(ctx, config)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	info, err := os.Stat(dest)
+	if err != nil || !info.IsDir() {
+		err = backup.Snapshot(ctx, db, dest)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("backed up to %s\n", dest)
+		return nil
+	}
+
+	snapshot, err := backup.Rotate(ctx, db, dest, config.BackupKeep)
+	if snapshot != "" {
+		fmt.Printf("backed up to %s\n", snapshot)
+	}
+
+	return err
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/pdk/forum/conf"
+)
+
+// configCheck prints the effective configuration (after defaults and
+// environment overrides), with secrets redacted, and any problems with it.
+func configCheck(ctx context.Context, config conf.Configuration, args []string) error {
+
+	if len(args) != 1 || args[0] != "check" {
+		return fmt.Errorf("usage: forum config check")
+	}
+
+	out, err := json.MarshalIndent(config.Redacted(), "", "    ")
+	if err != nil {
+		return fmt.Errorf("cannot show configuration: %w", err)
+	}
+
+	fmt.Printf("%s\n", out)
+
+	err = checkConfig(config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "\nconfiguration has problems:\n%s\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Fprintf(os.Stderr, "\nconfiguration OK\n")
+
+	return nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pdk/forum/action"
+	"github.com/pdk/forum/conf"
+	"github.com/pdk/forum/model"
+)
+
+var topicCommands = map[string]subcommand{
+	"create": {args: "NAME", run: func(ctx context.Context, a action.Actions, as model.User, args []string) (interface{}, error) {
+		if as.ID == 0 {
+			return nil, fmt.Errorf("topic create needs -as, the user who creates the topic")
+		}
+		return a.CreateTopic(ctx, as, args[0])
+	}},
+	"rename": {args: "TOPIC-ID NEW-NAME", run: func(ctx context.Context, a action.Actions, as model.User, args []string) (interface{}, error) {
+		topicID, err := parseID("topic", args[0])
+		if err != nil {
+			return nil, err
+		}
+		return a.RenameTopic(ctx, as, topicID, args[1])
+	}},
+	"delete": {args: "TOPIC-ID", run: func(ctx context.Context, a action.Actions, as model.User, args []string) (interface{}, error) {
+		topicID, err := parseID("topic", args[0])
+		if err != nil {
+			return nil, err
+		}
+		return a.DeleteTopic(ctx, as, topicID)
+	}},
+}
+
+var threadCommands = map[string]subcommand{
+	"move": {args: "THREAD-ID TOPIC-ID", run: func(ctx context.Context, a action.Actions, as model.User, args []string) (interface{}, error) {
+		threadID, err := parseID("thread", args[0])
+		if err != nil {
+			return nil, err
+		}
+		topicID, err := parseID("topic", args[1])
+		if err != nil {
+			return nil, err
+		}
+		return a.MoveThread(ctx, as, threadID, topicID)
+	}},
+	"lock": {args: "THREAD-ID", run: func(ctx context.Context, a action.Actions, as model.User, args []string) (interface{}, error) {
+		return lockThread(ctx, a, as, args[0], true)
+	}},
+	"unlock": {args: "THREAD-ID", run: func(ctx context.Context, a action.Actions, as model.User, args []string) (interface{}, error) {
+		return lockThread(ctx, a, as, args[0], false)
+	}},
+}
+
+var postCommands = map[string]subcommand{
+	"delete": {args: "POST-ID", run: func(ctx context.Context, a action.Actions, as model.User, args []string) (interface{}, error) {
+		postID, err := parseID("post", args[0])
+		if err != nil {
+			return nil, err
+		}
+		return a.DeletePost(ctx, as, postID)
+	}},
+}
+
+// manageTopics creates, renames and deletes topics.
+func manageTopics(ctx context.Context, config conf.Configuration, args []string) error {
+	return manage(ctx, config, "topic", topicCommands, args)
+}
+
+// manageThreads moves, locks and unlocks threads.
+func manageThreads(ctx context.Context, config conf.Configuration, args []string) error {
+	return manage(ctx, config, "thread", threadCommands, args)
+}
+
+// managePosts deletes posts.
+func managePosts(ctx context.Context, config conf.Configuration, args []string) error {
+	return manage(ctx, config, "post", postCommands, args)
+}
+
+func lockThread(ctx context.Context, a action.Actions, as model.User, id string, locked bool) (interface{}, error) {
+
+	threadID, err := parseID("thread", id)
+	if err != nil {
+		return nil, err
+	}
+
+	return a.LockThread(ctx, as, threadID, locked)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/pdk/forum/conf"
+	"github.com/pdk/forum/dump"
+)
+
+// export writes the users, topics, threads and posts to a file, or stdout.
+func export(ctx context.Context, config conf.Configuration, args []string) error {
+
+	if len(args) > 1 {
+		return fmt.Errorf("usage: forum export [file]")
+	}
+
+	db, err := openDatabase(ctx, config)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	err = checkMigrated(ctx, db)
+	if err != nil {
+		return err
+	}
+
+	if len(args) == 0 || args[0] == "-" {
+		return dump.Export(ctx, db, os.Stdout)
+	}
+
+	f, err := os.Create(args[0])
+	if err != nil {
+		return fmt.Errorf("cannot create dump: %w", err)
+	}
+
+	err = dump.Export(ctx, db, f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// importDump reads a dump written by export, and adds it to the database.
+func importDump(ctx context.Context, config conf.Configuration, args []string) error {
+
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	keepRoles := flags.Bool("keep-roles", false, "keep the roles in the dump, eg admin, rather than making everyone a member")
+	mergeUsers := flags.Bool("merge-users", false, "merge users into existing users of the same name, rather than renaming them")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: forum import [-keep-roles] [-merge-users] file")
+	}
+
+	var r io.Reader = os.Stdin
+	if flags.Arg(0) != "-" {
+		f, err := os.Open(flags.Arg(0))
+		if err != nil {
+			return fmt.Errorf("cannot open dump: %w", err)
+		}
+		defer f.Close()
+		r = f
+	}
+
+	d, err := dump.Read(r)
+	if err != nil {
+		return err
+	}
+
+	db, err := openDatabase(ctx, config)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	err = checkMigrated(ctx, db)
+	if err != nil {
+		return err
+	}
+
+	stats, err := dump.Import(ctx, db, d, dump.Options{KeepRoles: *keepRoles, MergeUsers: *mergeUsers})
+	fmt.Printf("imported %d users, %d topics, %d threads, %d posts; merged %d, skipped %d already imported\n",
+		stats.Users, stats.Topics, stats.Threads, stats.Posts, stats.Merged, stats.Skipped)
+	for _, name := range stats.MergedUsers {
+		fmt.Printf("merged into existing user %s\n", name)
+	}
+	for _, renamed := range stats.RenamedUsers {
+		fmt.Printf("name taken, imported %s\n", renamed)
+	}
+	if err != nil {
+		return fmt.Errorf("import stopped, run it again to resume: %w", err)
+	}
+
+	return nil
+}
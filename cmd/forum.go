@@ -1,46 +1,192 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/pdk/forum/conf"
-	"github.com/pdk/forum/srv"
+	"github.com/pdk/forum/hook"
+	"github.com/pdk/forum/logging"
 	"github.com/pdk/forum/store"
 )
 
+// defaultConfigFile is read if it exists, and no other file is named.
+const defaultConfigFile = "conf.json"
+
+// command is one of the forum subcommands. args are the command line arguments
+// after the command name.
+type command struct {
+	usage string
+	run   func(ctx context.Context, config conf.Configuration, args []string) error
+
+	// unchecked commands run even if the configuration is not valid.
+	unchecked bool
+}
+
+var commands = map[string]command{
+	"serve":       {usage: "serve                                   run the web server", run: serve},
+	"migrate":     {usage: "migrate status|up|down                  show, apply or revert schema migrations", run: migrate},
+	"config":      {usage: "config check                            show the effective configuration, and any problems", run: configCheck, unchecked: true},
+	"user":        {usage: "user create|rename|ban|unban|set-role   manage users", run: manageUsers},
+	"topic":       {usage: "topic create|rename|delete              manage topics", run: manageTopics},
+	"thread":      {usage: "thread move|lock|unlock                 manage threads", run: manageThreads},
+	"post":        {usage: "post delete                             manage posts", run: managePosts},
+	"export":      {usage: "export [file]                           write users, topics, threads and posts as JSON Lines", run: export},
+	"import":      {usage: "import [-keep-roles] [-merge-users] file add the contents of an exported file", run: importDump},
+	"backup":      {usage: "backup [file or directory]              copy the database, safely while the server runs", run: backUp},
+	"import-mbox": {usage: "import-mbox [-topic name] file ...      import mailing list archives", run: importMbox},
+}
+
+func usage() {
+
+	fmt.Fprintf(os.Stderr, "usage: forum [-config config.json] command [args]\n\n")
+	fmt.Fprintf(os.Stderr, "commands:\n")
+
+	names := []string{}
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %s\n", commands[name].usage)
+	}
+
+	fmt.Fprintf(os.Stderr, "\nuser, topic, thread and post take -json, to print the result as JSON, and\n")
+	fmt.Fprintf(os.Stderr, "-as user, to act as that user. Run eg forum user -h for details.\n")
+	fmt.Fprintf(os.Stderr, "\nforum config.json is the same as forum -config config.json serve\n")
+	fmt.Fprintf(os.Stderr, "\nThe configuration file defaults to $%sCONFIG, or else %s if it exists.\n", conf.EnvPrefix, defaultConfigFile)
+	fmt.Fprintf(os.Stderr, "Settings can be overridden by %s* environment variables, eg %s.\n", conf.EnvPrefix, conf.EnvName("ListenAddress"))
+}
+
 func main() {
 
-	if len(os.Args) != 2 {
-		fmt.Println("usage: forum config.json")
+	configFileName := flag.String("config", os.Getenv(conf.EnvPrefix+"CONFIG"), "configuration file")
+	flag.Usage = usage
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) == 1 && strings.HasSuffix(args[0], ".json") {
+		// the original usage: forum config.json
+		*configFileName = args[0]
+		args = []string{"serve"}
+	}
+
+	if len(args) == 0 {
+		usage()
+		os.Exit(1)
+	}
+
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %s\n\n", args[0])
+		usage()
 		os.Exit(1)
 	}
-	configFileName := os.Args[1]
 
-	log.Printf("starting forum...")
+	if *configFileName == "" {
+		if _, err := os.Stat(defaultConfigFile); err == nil {
+			*configFileName = defaultConfigFile
+		}
+	}
 
-	config, err := conf.ReadConfiguration(configFileName)
+	config, err := conf.ReadConfiguration(*configFileName)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	// fmt.Printf("configuration loaded: %v", config)
+	if !cmd.unchecked {
+		err = checkConfig(config)
+		if err != nil {
+			log.Fatalf("invalid configuration:\n%s", err)
+		}
+	}
+
+	setUpLogging(config)
+
+	// interrupting stops the server, or cancels the database work of other
+	// commands.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 
-	db, err := store.NewConnection(config.Database)
+	err = cmd.run(ctx, config, args[1:])
+	stop()
 	if err != nil {
-		log.Fatal(err)
+		logging.Default().Error("forum "+args[0]+" failed", "error", err)
+		os.Exit(1)
+	}
+}
+
+// setUpLogging makes the default logger write in the configured format and
+// level, and sends what is logged with the standard log package through it,
+// at info level. A bad setting (allowed by unchecked commands) keeps its
+// default.
+func setUpLogging(config conf.Configuration) {
+
+	level, err := logging.ParseLevel(config.LogLevel)
+	if err != nil {
+		level = logging.Info
 	}
 
-	err = db.Ping()
+	logger := logging.New(os.Stderr, config.LogFormat, level)
+	logging.SetDefault(logger)
+
+	log.SetFlags(0)
+	log.SetOutput(logger.Writer(logging.Info))
+}
+
+// checkConfig validates the configuration, including what depends on the
+// database driver and the webhook events.
+func checkConfig(config conf.Configuration) error {
+
+	var backups error
+	if config.BackupDir != "" && store.DriverFor(config.Database) != store.SQLite {
+		backups = errors.New("BackupDir: backups are only supported for sqlite databases")
+	}
+
+	database := store.CheckDatabase(config.Database)
+	if database != nil {
+		database = fmt.Errorf("Database: %w", database)
+	}
+
+	return errors.Join(config.Validate(), database, backups, hook.CheckEvents(config.Webhooks))
+}
+
+// databaseOptions returns the Database* settings, for store.OpenWith. A
+// DatabaseBusyTimeout which is not a duration (see Validate) is taken as 0.
+func databaseOptions(config conf.Configuration) store.Options {
+
+	busyTimeout, _ := time.ParseDuration(config.DatabaseBusyTimeout)
+
+	return store.Options{
+		JournalMode: config.DatabaseJournalMode,
+		BusyTimeout: busyTimeout,
+		ForeignKeys: config.DatabaseForeignKeys,
+		MaxConns:    config.DatabaseMaxConns,
+	}
+}
+
+// openDatabase connects to the configured database.
+func openDatabase(ctx context.Context, config conf.Configuration) (*store.SQLStore, error) {
+
+	db, err := store.OpenWith(config.Database, databaseOptions(config))
 	if err != nil {
-		log.Fatalf("failed to ping database: %s", err)
+		return nil, err
 	}
 
-	server, err := srv.NewServer(db, config.AssetsDir)
+	err = db.DB().PingContext(ctx)
 	if err != nil {
-		log.Fatalf("failed to initialize server: %s", err)
+		db.Close()
+		return nil, fmt.Errorf("failed to ping database: %w", err)
 	}
 
-	server.ListenAndServe(config.ListenAddress)
+	return db, nil
 }
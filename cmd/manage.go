@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pdk/forum/action"
+	"github.com/pdk/forum/conf"
+	"github.com/pdk/forum/hook"
+	"github.com/pdk/forum/model"
+	"github.com/pdk/forum/store"
+)
+
+// subcommand is one action of a management command (user, topic, thread or
+// post). run returns the changed thing, to be printed.
+type subcommand struct {
+	args string
+	run  func(ctx context.Context, a action.Actions, as model.User, args []string) (interface{}, error)
+}
+
+// manage runs one of the subcommands, eg "forum topic rename 3 golang". The
+// changes go through the same actions as the web pages, so they get the same
+// validation and webhooks. Changes are made as action.Operator, unless -as
+// names a user.
+func manage(ctx context.Context, config conf.Configuration, noun string, subcommands map[string]subcommand, args []string) error {
+
+	flags := flag.NewFlagSet(noun, flag.ContinueOnError)
+	jsonOutput := flags.Bool("json", false, "print the result as JSON")
+	asName := flags.String("as", "", "act as the named user, rather than the operator")
+	flags.Usage = func() { manageUsage(flags.Output(), noun, subcommands) }
+
+	args, err := parseInterspersed(flags, args)
+	if errors.Is(err, flag.ErrHelp) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if len(args) == 0 {
+		manageUsage(os.Stderr, noun, subcommands)
+		return fmt.Errorf("missing %s command", noun)
+	}
+
+	sub, ok := subcommands[args[0]]
+	if !ok {
+		manageUsage(os.Stderr, noun, subcommands)
+		return fmt.Errorf("unknown %s command %s", noun, args[0])
+	}
+
+	if len(args)-1 != len(strings.Fields(sub.args)) {
+		return fmt.Errorf("usage: forum %s %s %s", noun, args[0], sub.args)
+	}
+
+	db, err := openDatabase(ctx, config)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	err = checkMigrated(ctx, db)
+	if err != nil {
+		return err
+	}
+
+	a := action.Actions{
+		Store:  db,
+		Admins: config.Admins,
+	}
+
+	// webhook deliveries are recorded as pending, and sent by the server the
+	// next time it starts.
+	if len(config.Webhooks) > 0 {
+		a.Hooks = hook.NewDispatcher(db, config.Webhooks)
+	}
+
+	as := action.Operator
+	if *asName != "" {
+		as, err = db.GetUserByName(ctx, *asName)
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("no such user %s", *asName)
+		}
+		if err != nil {
+			return fmt.Errorf("cannot get user %s: %w", *asName, err)
+		}
+	}
+
+	result, err := sub.run(ctx, a, as, args[1:])
+	if err != nil {
+		return describeError(err)
+	}
+
+	if *jsonOutput {
+		return json.NewEncoder(os.Stdout).Encode(result)
+	}
+
+	fmt.Println(describe(result))
+
+	return nil
+}
+
+func manageUsage(w io.Writer, noun string, subcommands map[string]subcommand) {
+
+	names := []string{}
+	for name := range subcommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintf(w, "usage:\n")
+	for _, name := range names {
+		fmt.Fprintf(w, "  forum %s [-json] [-as user] %s %s\n", noun, name, subcommands[name].args)
+	}
+}
+
+// parseInterspersed parses flags which may come before, between or after the
+// other arguments, and returns the other arguments.
+func parseInterspersed(flags *flag.FlagSet, args []string) ([]string, error) {
+
+	rest := []string{}
+	for {
+		err := flags.Parse(args)
+		if err != nil {
+			return nil, err
+		}
+
+		if flags.NArg() == 0 {
+			return rest, nil
+		}
+
+		rest = append(rest, flags.Arg(0))
+		args = flags.Args()[1:]
+	}
+}
+
+// checkMigrated returns an error if the database schema is not up to date.
+func checkMigrated(ctx context.Context, db *store.SQLStore) error {
+
+	current, err := db.SchemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+
+	latest, err := db.LatestSchemaVersion()
+	if err != nil {
+		return err
+	}
+
+	if current < latest {
+		return fmt.Errorf("database is at version %d, but should be at %d: run forum migrate up", current, latest)
+	}
+
+	return db.CheckSchemaVersion(ctx)
+}
+
+// describeError makes errors from actions fit for the command line.
+func describeError(err error) error {
+
+	var validationErr model.ValidationError
+
+	switch {
+	case errors.As(err, &validationErr):
+		return validationErr
+	case errors.Is(err, sql.ErrNoRows):
+		return fmt.Errorf("not found: %w", err)
+	case store.IsDuplicate(err):
+		return fmt.Errorf("already exists: %w", err)
+	}
+
+	return err
+}
+
+// describe returns a one line, human readable description of a result.
+func describe(result interface{}) string {
+
+	switch r := result.(type) {
+	case model.User:
+		desc := fmt.Sprintf("user %d %q, role %s", r.ID, r.Name, r.Role)
+		if r.Banned {
+			desc += ", banned"
+		}
+		return desc
+	case model.Topic:
+		return fmt.Sprintf("topic %d %q", r.ID, r.Name)
+	case model.Thread:
+		desc := fmt.Sprintf("thread %d %q in topic %d", r.ID, r.Subject, r.TopicID)
+		if r.Locked {
+			desc += ", locked"
+		}
+		return desc
+	case model.Post:
+		return fmt.Sprintf("post %d in thread %d", r.ID, r.ThreadID)
+	}
+
+	return fmt.Sprintf("%v", result)
+}
+
+// parseID parses the ID of a topic, thread or post.
+func parseID(what, s string) (int64, error) {
+
+	id, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s id %s", what, s)
+	}
+
+	return id, nil
+}
+
+// userByName finds a user, for the user commands.
+func userByName(ctx context.Context, a action.Actions, name string) (model.User, error) {
+
+	user, err := a.Store.GetUserByName(ctx, name)
+	if err != nil {
+		return user, fmt.Errorf("cannot get user %s: %w", name, err)
+	}
+
+	return user, nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pdk/forum/conf"
+	"github.com/pdk/forum/dump"
+	"github.com/pdk/forum/mbox"
+)
+
+// importMbox imports mailing list archives: each becomes a topic, named by
+// -topic, or the list's List-Id, or else the file name.
+func importMbox(ctx context.Context, config conf.Configuration, args []string) error {
+
+	flags := flag.NewFlagSet("import-mbox", flag.ContinueOnError)
+	topicName := flags.String("topic", "", "name of the topic to import into")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if flags.NArg() == 0 {
+		return fmt.Errorf("usage: forum import-mbox [-topic name] file.mbox ...")
+	}
+
+	db, err := openDatabase(ctx, config)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	err = checkMigrated(ctx, db)
+	if err != nil {
+		return err
+	}
+
+	for _, fileName := range flags.Args() {
+
+		content, err := os.ReadFile(fileName)
+		if err != nil {
+			return fmt.Errorf("cannot read archive: %w", err)
+		}
+
+		messages, err := mbox.Read(bytes.NewReader(content))
+		if err != nil {
+			return fmt.Errorf("cannot read %s: %w", fileName, err)
+		}
+
+		if len(messages) == 0 {
+			fmt.Printf("%s: no messages\n", fileName)
+			continue
+		}
+
+		name := *topicName
+		if name == "" {
+			name = messages[0].List
+		}
+		if name == "" {
+			name = strings.TrimSuffix(filepath.Base(fileName), filepath.Ext(fileName))
+		}
+
+		// the same archive gets the same source, so an interrupted import of it
+		// resumes.
+		sum := sha256.Sum256(content)
+		source := "mbox-" + hex.EncodeToString(sum[:])
+
+		d := mbox.Convert(name, source, messages)
+
+		// senders' display names are not to be trusted, so they are never
+		// merged into existing users, and are only members.
+		stats, err := dump.Import(ctx, db, d, dump.Options{})
+		fmt.Printf("%s: %d messages into topic %s: %d new users, %d threads, %d posts; skipped %d already imported\n",
+			fileName, len(messages), name, stats.Users, stats.Threads, stats.Posts, stats.Skipped)
+		if err != nil {
+			return fmt.Errorf("import of %s stopped, run it again to resume: %w", fileName, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/pdk/forum/conf"
+)
+
+// migrate shows, applies or reverts schema migrations.
+func migrate(ctx context.Context, config conf.Configuration, args []string) error {
+
+	if len(args) != 1 {
+		return fmt.Errorf("usage: forum migrate status|up|down")
+	}
+
+	db, err := openDatabase(ctx, config)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	switch args[0] {
+	case "status":
+		states, err := db.MigrationStatus(ctx)
+		if err != nil {
+			return err
+		}
+
+		version, err := db.SchemaVersion(ctx)
+		if err != nil {
+			return err
+		}
+
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintf(tw, "VERSION\tNAME\tAPPLIED\n")
+		for _, state := range states {
+			applied := "pending"
+			if state.Applied {
+				applied = state.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Fprintf(tw, "%d\t%s\t%s\n", state.Version, state.Name, applied)
+		}
+		tw.Flush()
+
+		fmt.Printf("\ndatabase is at version %d\n", version)
+
+		return db.CheckSchemaVersion(ctx)
+
+	case "up":
+		applied, err := db.MigrateUp(ctx)
+		for _, m := range applied {
+			fmt.Printf("applied %d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			return err
+		}
+		if len(applied) == 0 {
+			fmt.Printf("already up to date\n")
+		}
+
+	case "down":
+		m, err := db.MigrateDown(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("reverted %d_%s\n", m.Version, m.Name)
+
+	default:
+		return fmt.Errorf("unknown migrate command %s, expected status, up or down", args[0])
+	}
+
+	return nil
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/pdk/forum/action"
+	"github.com/pdk/forum/backup"
+	"github.com/pdk/forum/broker"
+	"github.com/pdk/forum/conf"
+	"github.com/pdk/forum/hook"
+	"github.com/pdk/forum/logging"
+	"github.com/pdk/forum/metrics"
+	"github.com/pdk/forum/srv"
+	"github.com/pdk/forum/store"
+)
+
+// serve brings the database schema up to date, and runs the web server until
+// interrupted (SIGINT or SIGTERM). It then lets in-flight requests finish,
+// stops background work, and closes the database.
+func serve(ctx context.Context, config conf.Configuration, args []string) error {
+
+	if len(args) != 0 {
+		return fmt.Errorf("usage: forum serve")
+	}
+
+	logger := logging.Default()
+	logger.Info("starting forum...")
+
+	// cancelled on the way out too, so that background work stops if the
+	// server fails.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	db, err := openDatabase(ctx, config)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		logger.Info("closing database")
+		db.Close()
+	}()
+
+	applied, err := db.MigrateUp(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to migrate database: %w", err)
+	}
+
+	for _, m := range applied {
+		logger.Info("applied migration", "version", m.Version, "name", m.Name)
+	}
+
+	report, err := db.CheckPragmas(ctx)
+	logger.Info("database settings", "driver", db.Driver(), "settings", report)
+	if err != nil {
+		logger.Warn("database is not configured as requested", "error", err)
+	}
+
+	registry := metrics.NewRegistry()
+	observePools(registry, db)
+
+	var st store.Store = store.NewObservedStore(db, observeStore(registry))
+	var cache *store.CachedStore
+
+	if config.CacheSize > 0 {
+		ttl, _ := time.ParseDuration(config.CacheTTL)
+		cache = store.NewCachedStore(st, config.CacheSize, ttl)
+		st = cache
+		logger.Info("caching lookups", "size", config.CacheSize, "ttl", ttl)
+		observeCache(registry, cache)
+		defer func() {
+			stats := cache.Stats()
+			logger.Info("cache stats", "hits", stats.Hits, "misses", stats.Misses, "evictions", stats.Evictions)
+		}()
+	}
+
+	server, err := srv.NewServer(st, config.AssetsDir)
+	if err != nil {
+		return fmt.Errorf("failed to initialize server: %w", err)
+	}
+
+	server.Cache = cache
+	server.Metrics = registry
+	action.RegisterCounters(registry)
+
+	server.Admins = config.Admins
+	server.DevMode = config.DevMode
+	server.BaseURL = config.BaseURL
+	server.MetricsToken = config.MetricsToken
+	server.RequestTimeout, _ = time.ParseDuration(config.RequestTimeout)
+	server.TLS = srv.TLSOptions{
+		CertFile:        config.TLSCertFile,
+		KeyFile:         config.TLSKeyFile,
+		MinVersion:      config.TLSMinVersion,
+		RedirectAddress: config.TLSRedirectAddress,
+	}
+
+	switch {
+	case config.DevMode && config.AssetsDir == "":
+		logger.Info("dev mode: no AssetsDir, so templates will not reload")
+	case config.DevMode:
+		logger.Info("dev mode: templates reload when changed", "dir", config.AssetsDir)
+	}
+
+	server.Events = broker.NewBroker(config.MaxEventSubscribers)
+
+	if len(config.Webhooks) > 0 {
+		server.Hooks = hook.NewDispatcher(db, config.Webhooks)
+		server.Hooks.Start()
+		defer func() {
+			logger.Info("stopping webhook deliveries")
+			server.Hooks.Stop()
+		}()
+		logger.Info("sending events to webhooks", "webhooks", len(config.Webhooks))
+	}
+
+	if config.BackupInterval != "" {
+		interval, _ := time.ParseDuration(config.BackupInterval)
+		backups := make(chan struct{})
+		go func() {
+			backup.Schedule(ctx, db, config.BackupDir, config.BackupKeep, interval)
+			close(backups)
+		}()
+		defer func() {
+			cancel()
+			<-backups
+		}()
+		logger.Info("backing up", "dir", config.BackupDir, "interval", interval, "keep", config.BackupKeep)
+	}
+
+	err = server.ListenAndServe(ctx, config.ListenAddress)
+	if err != nil {
+		return err
+	}
+
+	logger.Info("server stopped")
+
+	return nil
+}
+
+// observeStore counts the calls to each store function, their errors, and how
+// long they take. A lookup of something which does not exist is not an error.
+func observeStore(registry *metrics.Registry) store.Observer {
+
+	calls := registry.Counter("forum_db_calls_total", "Calls to the store, by function.", "function")
+	errs := registry.Counter("forum_db_errors_total", "Calls to the store which failed, by function.", "function")
+	durations := registry.Histogram("forum_db_call_duration_seconds", "How long calls to the store took, by function.",
+		metrics.DefaultBuckets, "function")
+
+	return func(function string, elapsed time.Duration, err error) {
+		calls.Inc(function)
+		durations.Observe(elapsed.Seconds(), function)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			errs.Inc(function)
+		}
+	}
+}
+
+// observePools reports the database's connection pools, from sql.DBStats.
+func observePools(registry *metrics.Registry, db *store.SQLStore) {
+
+	open := registry.Gauge("forum_db_connections_open", "Open connections, by pool.", "pool")
+	inUse := registry.Gauge("forum_db_connections_in_use", "Connections in use, by pool.", "pool")
+	idle := registry.Gauge("forum_db_connections_idle", "Idle connections, by pool.", "pool")
+	maxOpen := registry.Gauge("forum_db_connections_max_open", "The most connections allowed, by pool.", "pool")
+	waits := registry.Counter("forum_db_connection_waits_total", "Times a connection was waited for, by pool.", "pool")
+	waited := registry.Counter("forum_db_connection_wait_seconds_total", "Time spent waiting for connections, by pool.", "pool")
+
+	registry.OnScrape(func() {
+		for pool, stats := range db.PoolStats() {
+			open.Set(float64(stats.OpenConnections), pool)
+			inUse.Set(float64(stats.InUse), pool)
+			idle.Set(float64(stats.Idle), pool)
+			maxOpen.Set(float64(stats.MaxOpenConnections), pool)
+			waits.Set(float64(stats.WaitCount), pool)
+			waited.Set(stats.WaitDuration.Seconds(), pool)
+		}
+	})
+}
+
+// observeCache reports the cache's hits and misses.
+func observeCache(registry *metrics.Registry, cache *store.CachedStore) {
+
+	hits := registry.Counter("forum_cache_hits_total", "Lookups answered by the cache.")
+	misses := registry.Counter("forum_cache_misses_total", "Lookups the cache could not answer.")
+	evictions := registry.Counter("forum_cache_evictions_total", "Results dropped from the cache to make room.")
+	entries := registry.Gauge("forum_cache_entries", "Results in the cache.")
+
+	registry.OnScrape(func() {
+		stats := cache.Stats()
+		hits.Set(float64(stats.Hits))
+		misses.Set(float64(stats.Misses))
+		evictions.Set(float64(stats.Evictions))
+		entries.Set(float64(stats.Entries))
+	})
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"github.com/pdk/forum/action"
+	"github.com/pdk/forum/conf"
+	"github.com/pdk/forum/model"
+)
+
+var userCommands = map[string]subcommand{
+	"create": {args: "NAME", run: func(ctx context.Context, a action.Actions, as model.User, args []string) (interface{}, error) {
+		return a.CreateUser(ctx, args[0])
+	}},
+	"rename": {args: "NAME NEW-NAME", run: func(ctx context.Context, a action.Actions, as model.User, args []string) (interface{}, error) {
+		user, err := userByName(ctx, a, args[0])
+		if err != nil {
+			return nil, err
+		}
+		return a.RenameUser(ctx, as, user.ID, args[1])
+	}},
+	"ban": {args: "NAME", run: func(ctx context.Context, a action.Actions, as model.User, args []string) (interface{}, error) {
+		return banUser(ctx, a, as, args[0], true)
+	}},
+	"unban": {args: "NAME", run: func(ctx context.Context, a action.Actions, as model.User, args []string) (interface{}, error) {
+		return banUser(ctx, a, as, args[0], false)
+	}},
+	"set-role": {args: "NAME member|moderator|admin", run: func(ctx context.Context, a action.Actions, as model.User, args []string) (interface{}, error) {
+		user, err := userByName(ctx, a, args[0])
+		if err != nil {
+			return nil, err
+		}
+		return a.SetUserRole(ctx, as, user.ID, args[1])
+	}},
+}
+
+// manageUsers creates, renames, bans and sets the roles of users.
+func manageUsers(ctx context.Context, config conf.Configuration, args []string) error {
+	return manage(ctx, config, "user", userCommands, args)
+}
+
+func banUser(ctx context.Context, a action.Actions, as model.User, name string, banned bool) (interface{}, error) {
+
+	user, err := userByName(ctx, a, name)
+	if err != nil {
+		return nil, err
+	}
+
+	return a.BanUser(ctx, as, user.ID, banned)
+}
@@ -1,10 +1,14 @@
 package conf
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"os"
+
+	"github.com/pdk/forum/logging"
 )
 
 // Configuration contains settings for the current run
@@ -12,23 +16,127 @@ type Configuration struct {
 	Database      string
 	ListenAddress string
 	AssetsDir     string
+	Admins        []string
+	Webhooks      []Webhook
+
+	// BaseURL (eg "https://forum.example.com") is where the forum is served,
+	// for links and IDs in feeds. If blank, each request's scheme and host are
+	// used, so feed readers may see the same post under different IDs.
+	BaseURL string
+
+	// MetricsToken turns on /metrics, for requests with it as their bearer
+	// token (eg bearer_token in a Prometheus scrape config).
+	MetricsToken string `secret:"true"`
+
+	// TLSCertFile and TLSKeyFile turn on HTTPS. They are reloaded when they
+	// change, or on SIGHUP. TLSMinVersion is eg "1.2" (the default) or "1.3".
+	// If TLSRedirectAddress is set, plain HTTP requests there are redirected
+	// to HTTPS.
+	TLSCertFile        string
+	TLSKeyFile         string
+	TLSMinVersion      string
+	TLSRedirectAddress string
+
+	// DevMode reloads templates from AssetsDir when they change, and shows
+	// template errors in the browser. Not for production.
+	DevMode bool
+
+	// MaxEventSubscribers limits the number of browsers receiving live
+	// updates at one time.
+	MaxEventSubscribers int
+
+	// RequestTimeout (eg "30s") limits how long a request may spend, mostly
+	// waiting for the database. "0" means no limit. Live update streams are
+	// not limited.
+	RequestTimeout string
+
+	// LogFormat is "logfmt" (the default) or "json". LogLevel is the least
+	// important level logged: "debug", "info" (the default), "warn" or
+	// "error".
+	LogFormat string
+	LogLevel  string
+
+	// DatabaseJournalMode (eg "wal"), DatabaseBusyTimeout (eg "5s") and
+	// DatabaseForeignKeys tune the connections to a sqlite database.
+	// DatabaseMaxConns limits the connections reading sqlite (there is one
+	// more for writing), or all the connections to PostgreSQL.
+	DatabaseJournalMode string
+	DatabaseBusyTimeout string
+	DatabaseForeignKeys bool
+	DatabaseMaxConns    int
+
+	// CacheSize is the number of lookups (eg the list of topics) the server
+	// keeps in memory, for up to CacheTTL (eg "30s"). 0 turns the cache off.
+	CacheSize int
+	CacheTTL  string
+
+	// BackupDir is where backups are kept, by forum backup and by scheduled
+	// backups. If BackupInterval is set (eg "6h"), the server takes a backup
+	// that often. Only the latest BackupKeep backups are kept.
+	BackupDir      string
+	BackupInterval string
+	BackupKeep     int
+}
+
+// Webhook is an outgoing webhook. Events lists the event names to send, eg
+// "post.created". Payloads are signed with Secret.
+type Webhook struct {
+	URL    string
+	Secret string `secret:"true"`
+	Events []string
+}
+
+// Defaults returns the Configuration used for anything not set in the file or
+// the environment.
+func Defaults() Configuration {
+	return Configuration{
+		Database:            "forum.db",
+		ListenAddress:       "localhost:9753",
+		TLSMinVersion:       "1.2",
+		MaxEventSubscribers: 1000,
+		RequestTimeout:      "30s",
+		LogFormat:           logging.LogFmt,
+		LogLevel:            logging.Info.String(),
+		// the Database* settings are the same as store.DefaultOptions.
+		DatabaseJournalMode: "wal",
+		DatabaseBusyTimeout: "5s",
+		DatabaseForeignKeys: true,
+		DatabaseMaxConns:    8,
+		CacheSize:           1000,
+		CacheTTL:            "30s",
+		BackupKeep:          7,
+	}
 }
 
 // ReadConfiguration reads the named file as JSON and returns the Configuration.
+// Settings not in the file keep their Defaults, and FORUM_* environment
+// variables override both (see ApplyEnvironment). A blank fileName reads no
+// file. Unknown fields in the file are an error, to catch typos. The result is
+// not validated: see Validate.
 func ReadConfiguration(fileName string) (Configuration, error) {
 
-	newConf := Configuration{}
+	newConf := Defaults()
 
-	log.Printf("reading configuration from %s", fileName)
+	if fileName != "" {
+		log.Printf("reading configuration from %s", fileName)
 
-	confData, err := ioutil.ReadFile(fileName)
-	if err != nil {
-		return newConf, fmt.Errorf("cannot read configuration: %w", err)
+		confData, err := ioutil.ReadFile(fileName)
+		if err != nil {
+			return newConf, fmt.Errorf("cannot read configuration: %w", err)
+		}
+
+		decoder := json.NewDecoder(bytes.NewReader(confData))
+		decoder.DisallowUnknownFields()
+
+		err = decoder.Decode(&newConf)
+		if err != nil {
+			return newConf, fmt.Errorf("cannot parse configuration %s: %w", fileName, err)
+		}
 	}
 
-	err = json.Unmarshal(confData, &newConf)
+	err := ApplyEnvironment(&newConf, os.Environ())
 	if err != nil {
-		return newConf, fmt.Errorf("cannot parse configuration %s: %w", fileName, err)
+		return newConf, err
 	}
 
 	return newConf, nil
@@ -0,0 +1,93 @@
+package conf_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/pdk/forum/conf"
+	"github.com/pdk/forum/store"
+)
+
+func TestEnvName(t *testing.T) {
+
+	tests := map[string]string{
+		"Database":            "FORUM_DATABASE",
+		"ListenAddress":       "FORUM_LISTEN_ADDRESS",
+		"TLSCertFile":         "FORUM_TLS_CERT_FILE",
+		"MaxEventSubscribers": "FORUM_MAX_EVENT_SUBSCRIBERS",
+	}
+
+	for field, expected := range tests {
+		if got := conf.EnvName(field); got != expected {
+			t.Errorf("expected %s for %s, but got %s", expected, field, got)
+		}
+	}
+}
+
+func TestApplyEnvironment(t *testing.T) {
+
+	config := conf.Defaults()
+
+	err := conf.ApplyEnvironment(&config, []string{
+		"FORUM_LISTEN_ADDRESS=:8080",
+		"FORUM_ADMINS=pdk, bob",
+		"FORUM_DEV_MODE=true",
+		"FORUM_MAX_EVENT_SUBSCRIBERS=5",
+		"OTHER_THING=ignored",
+	})
+	if err != nil {
+		t.Fatalf("expected to apply environment, but failed: %v", err)
+	}
+
+	if config.ListenAddress != ":8080" || !config.DevMode || config.MaxEventSubscribers != 5 {
+		t.Errorf("expected overrides to be applied, but got %+v", config)
+	}
+
+	if !reflect.DeepEqual(config.Admins, []string{"pdk", "bob"}) {
+		t.Errorf("expected admins [pdk bob], but got %v", config.Admins)
+	}
+
+	if config.Database != conf.Defaults().Database {
+		t.Errorf("expected default database, but got %s", config.Database)
+	}
+
+	err = conf.ApplyEnvironment(&config, []string{"FORUM_MAX_EVENT_SUBSCRIBERS=lots"})
+	if err == nil {
+		t.Errorf("expected an error for a bad number")
+	}
+}
+
+func TestRedacted(t *testing.T) {
+
+	config := conf.Defaults()
+	config.Webhooks = []conf.Webhook{{URL: "http://example.com", Secret: "sekrit"}}
+	config.MetricsToken = "scraper"
+
+	shown := config.Redacted()
+
+	if shown.Webhooks[0].Secret == "sekrit" {
+		t.Errorf("expected secret to be redacted")
+	}
+
+	if shown.MetricsToken != "xxxxx" || shown.Webhooks[0].Secret != "xxxxx" {
+		t.Errorf("expected secrets to be shown as xxxxx, as passwords in URLs are, but got %q and %q",
+			shown.MetricsToken, shown.Webhooks[0].Secret)
+	}
+
+	if config.Webhooks[0].Secret != "sekrit" {
+		t.Errorf("expected original to keep its secret, but got %s", config.Webhooks[0].Secret)
+	}
+}
+
+func TestDatabaseDefaults(t *testing.T) {
+
+	config := conf.Defaults()
+	opts := store.DefaultOptions()
+
+	busyTimeout, _ := time.ParseDuration(config.DatabaseBusyTimeout)
+	if config.DatabaseJournalMode != opts.JournalMode || busyTimeout != opts.BusyTimeout ||
+		config.DatabaseForeignKeys != opts.ForeignKeys || config.DatabaseMaxConns != opts.MaxConns {
+		t.Errorf("expected the database defaults to be %+v, but got %+v", opts, config)
+	}
+}
@@ -0,0 +1,108 @@
+package conf
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// EnvPrefix starts the names of environment variables which override the
+// configuration.
+const EnvPrefix = "FORUM_"
+
+// EnvName returns the environment variable which overrides a Configuration
+// field, eg FORUM_LISTEN_ADDRESS for ListenAddress.
+func EnvName(fieldName string) string {
+
+	sb := strings.Builder{}
+	runes := []rune(fieldName)
+
+	for i, r := range runes {
+		// start a new word at a capital which follows a lower case letter, or
+		// which starts a word after an acronym (the S in TLSCertFile).
+		if i > 0 && unicode.IsUpper(r) &&
+			(unicode.IsLower(runes[i-1]) || (i+1 < len(runes) && unicode.IsLower(runes[i+1]))) {
+			sb.WriteRune('_')
+		}
+		sb.WriteRune(unicode.ToUpper(r))
+	}
+
+	return EnvPrefix + sb.String()
+}
+
+// ApplyEnvironment overrides fields of the configuration with the matching
+// FORUM_* variables in environ (as from os.Environ). Strings, numbers and
+// booleans are supported, and lists as comma separated values (eg
+// FORUM_ADMINS=pdk,bob). Webhooks can only be set in the file.
+func ApplyEnvironment(config *Configuration, environ []string) error {
+
+	env := map[string]string{}
+	for _, kv := range environ {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) == 2 && strings.HasPrefix(parts[0], EnvPrefix) {
+			env[parts[0]] = parts[1]
+		}
+	}
+
+	v := reflect.ValueOf(config).Elem()
+	t := v.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := EnvName(field.Name)
+
+		value, ok := env[name]
+		if !ok {
+			continue
+		}
+
+		err := setField(v.Field(i), value)
+		if err != nil {
+			return fmt.Errorf("cannot use %s=%q: %w", name, value, err)
+		}
+	}
+
+	return nil
+}
+
+func setField(field reflect.Value, value string) error {
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("expected true or false")
+		}
+		field.SetBool(b)
+
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("expected a whole number")
+		}
+		field.SetInt(n)
+
+	case reflect.Slice:
+		if field.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("can only be set in the configuration file")
+		}
+		list := []string{}
+		for _, item := range strings.Split(value, ",") {
+			item = strings.TrimSpace(item)
+			if item != "" {
+				list = append(list, item)
+			}
+		}
+		field.Set(reflect.ValueOf(list))
+
+	default:
+		return fmt.Errorf("can only be set in the configuration file")
+	}
+
+	return nil
+}
@@ -0,0 +1,126 @@
+package conf_test
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pdk/forum/conf"
+)
+
+func TestValidate(t *testing.T) {
+
+	dir := t.TempDir()
+
+	valid := func() conf.Configuration {
+		config := conf.Defaults()
+		config.Database = filepath.Join(dir, "forum.db")
+		return config
+	}
+
+	err := valid().Validate()
+	if err != nil {
+		t.Fatalf("expected the defaults to be valid, but got %v", err)
+	}
+
+	unlimited := valid()
+	unlimited.RequestTimeout = "0"
+	err = unlimited.Validate()
+	if err != nil {
+		t.Errorf("expected a RequestTimeout of 0 (no limit) to be valid, but got %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		change   func(c *conf.Configuration)
+		expected string
+	}{
+		{"no database", func(c *conf.Configuration) { c.Database = "" }, "Database: must not be blank"},
+		{"listen address without port", func(c *conf.Configuration) { c.ListenAddress = "localhost" }, "ListenAddress:"},
+		{"listen port out of range", func(c *conf.Configuration) { c.ListenAddress = ":70000" }, "ListenAddress:"},
+		{"journal mode", func(c *conf.Configuration) { c.DatabaseJournalMode = "memory" }, "DatabaseJournalMode:"},
+		{"busy timeout", func(c *conf.Configuration) { c.DatabaseBusyTimeout = "soon" }, "DatabaseBusyTimeout:"},
+		{"max conns", func(c *conf.Configuration) { c.DatabaseMaxConns = 0 }, "DatabaseMaxConns:"},
+		{"cache size", func(c *conf.Configuration) { c.CacheSize = -1 }, "CacheSize:"},
+		{"cache ttl", func(c *conf.Configuration) { c.CacheTTL = "0s" }, "CacheTTL:"},
+		{"base url", func(c *conf.Configuration) { c.BaseURL = "forum.example.com" }, "BaseURL:"},
+		{"assets dir", func(c *conf.Configuration) { c.AssetsDir = filepath.Join(dir, "missing") }, "AssetsDir:"},
+		{"dev mode without assets", func(c *conf.Configuration) { c.DevMode = true }, "DevMode: requires AssetsDir"},
+		{"event subscribers", func(c *conf.Configuration) { c.MaxEventSubscribers = 0 }, "MaxEventSubscribers:"},
+		{"request timeout", func(c *conf.Configuration) { c.RequestTimeout = "-1s" }, "RequestTimeout:"},
+		{"log format", func(c *conf.Configuration) { c.LogFormat = "xml" }, "LogFormat:"},
+		{"log level", func(c *conf.Configuration) { c.LogLevel = "loud" }, "LogLevel:"},
+		{"cert without key", func(c *conf.Configuration) { c.TLSCertFile = filepath.Join(dir, "cert.pem") }, "TLSCertFile, TLSKeyFile: must be set together"},
+		{"unreadable cert", func(c *conf.Configuration) {
+			c.TLSCertFile, c.TLSKeyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+		}, "TLSCertFile:"},
+		{"tls version", func(c *conf.Configuration) { c.TLSMinVersion = "1.4" }, "TLSMinVersion:"},
+		{"redirect without tls", func(c *conf.Configuration) { c.TLSRedirectAddress = ":80" }, "TLSRedirectAddress: requires TLSCertFile"},
+		{"backup interval without dir", func(c *conf.Configuration) { c.BackupInterval = "6h" }, "BackupInterval: requires BackupDir"},
+		{"backup interval too short", func(c *conf.Configuration) { c.BackupDir, c.BackupInterval = dir, "1s" }, "BackupInterval:"},
+		{"backup keep", func(c *conf.Configuration) { c.BackupKeep = 0 }, "BackupKeep:"},
+		{"webhook url", func(c *conf.Configuration) {
+			c.Webhooks = []conf.Webhook{{URL: "ftp://example.com", Secret: "s", Events: []string{"post.created"}}}
+		}, "Webhooks[0].URL:"},
+		{"webhook secret", func(c *conf.Configuration) {
+			c.Webhooks = []conf.Webhook{{URL: "https://example.com", Events: []string{"post.created"}}}
+		}, "Webhooks[0].Secret:"},
+		{"webhook events", func(c *conf.Configuration) {
+			c.Webhooks = []conf.Webhook{{URL: "https://example.com", Secret: "s"}}
+		}, "Webhooks[0].Events:"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			config := valid()
+			test.change(&config)
+
+			err := config.Validate()
+			if err == nil || !strings.Contains(err.Error(), test.expected) {
+				t.Errorf("expected an error containing %q, but got %v", test.expected, err)
+			}
+		})
+	}
+}
+
+func TestValidateReportsEveryProblem(t *testing.T) {
+
+	config := conf.Defaults()
+	config.Database = ""
+	config.LogFormat = "xml"
+	config.BackupKeep = 0
+
+	err := config.Validate()
+	if err == nil {
+		t.Fatalf("expected errors")
+	}
+
+	for _, expected := range []string{"Database:", "LogFormat:", "BackupKeep:"} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected an error containing %q, but got %v", expected, err)
+		}
+	}
+}
+
+func TestRedactedDatabase(t *testing.T) {
+
+	tests := map[string]string{
+		"forum.db":                              "forum.db",
+		"postgres://forum@db/forum":             "postgres://forum@db/forum",
+		"postgres://forum:secret@db/forum":      "postgres://forum:xxxxx@db/forum",
+		"postgres://db/forum?password=secret":   "postgres://db/forum?password=xxxxx",
+		"postgres://forum:se%zzcret@db/forum":   "xxxxx",
+		"file:forum.db?cache=shared&mode=rwc":   "file:forum.db?cache=shared&mode=rwc",
+		"postgresql://u:p@db:5432/f?sslmode=no": "postgresql://u:xxxxx@db:5432/f?sslmode=no",
+	}
+
+	for database, expected := range tests {
+		config := conf.Defaults()
+		config.Database = database
+
+		if shown := config.Redacted().Database; shown != expected {
+			t.Errorf("expected %s to be shown as %s, but got %s", database, expected, shown)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+// Package dump writes and reads the users, topics, threads and posts of a forum
+// as JSON Lines, to move a forum between servers, or to keep an archive.
+//
+// The first line is a Header. Every other line is a Record, holding one
+// model.User, model.Topic, model.Thread or model.Post, with the IDs it had in
+// the exported forum.
+package dump
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/pdk/forum/model"
+	"github.com/pdk/forum/store"
+)
+
+// Format and Version identify a dump, and the version of its layout.
+const (
+	Format  = "forum-dump"
+	Version = 1
+)
+
+// maxProblems limits how many problems Check reports.
+const maxProblems = 20
+
+// Header is the first line of a dump.
+type Header struct {
+	Format     string    `json:"format"`
+	Version    int       `json:"version"`
+	ID         string    `json:"id"`
+	ExportedAt time.Time `json:"exported_at"`
+}
+
+// Record is one line of a dump after the header. Type is one of the store.Kind
+// constants, and says what Data holds.
+type Record struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+// Dump is a whole dump, read into memory.
+type Dump struct {
+	Header  Header
+	Users   []model.User
+	Topics  []model.Topic
+	Threads []model.Thread
+	Posts   []model.Post
+}
+
+// Read reads a dump. It checks the header, but not the records: see Check.
+func Read(r io.Reader) (Dump, error) {
+
+	d := Dump{}
+	dec := json.NewDecoder(r)
+
+	err := dec.Decode(&d.Header)
+	if err != nil {
+		return d, fmt.Errorf("cannot read dump header: %w", err)
+	}
+
+	switch {
+	case d.Header.Format != Format:
+		return d, fmt.Errorf("not a forum dump: format is %q", d.Header.Format)
+	case d.Header.Version > Version:
+		return d, fmt.Errorf("dump is version %d, this program knows up to %d", d.Header.Version, Version)
+	case d.Header.ID == "":
+		return d, fmt.Errorf("dump header has no id")
+	}
+
+	for line := 2; ; line++ {
+
+		rec := Record{}
+		err := dec.Decode(&rec)
+		if errors.Is(err, io.EOF) {
+			return d, nil
+		}
+		if err != nil {
+			return d, fmt.Errorf("cannot read dump line %d: %w", line, err)
+		}
+
+		switch rec.Type {
+		case store.KindUser:
+			user := model.User{}
+			err = json.Unmarshal(rec.Data, &user)
+			d.Users = append(d.Users, user)
+		case store.KindTopic:
+			topic := model.Topic{}
+			err = json.Unmarshal(rec.Data, &topic)
+			d.Topics = append(d.Topics, topic)
+		case store.KindThread:
+			thread := model.Thread{}
+			err = json.Unmarshal(rec.Data, &thread)
+			d.Threads = append(d.Threads, thread)
+		case store.KindPost:
+			post := model.Post{}
+			err = json.Unmarshal(rec.Data, &post)
+			d.Posts = append(d.Posts, post)
+		default:
+			err = fmt.Errorf("unknown record type %q", rec.Type)
+		}
+
+		if err != nil {
+			return d, fmt.Errorf("cannot read dump line %d: %w", line, err)
+		}
+	}
+}
+
+// problems collects what Check finds wrong.
+type problems struct {
+	errs  []error
+	count int
+}
+
+func (p *problems) add(format string, args ...interface{}) {
+
+	p.count++
+	if len(p.errs) < maxProblems {
+		p.errs = append(p.errs, fmt.Errorf(format, args...))
+	}
+}
+
+func (p *problems) err() error {
+
+	if p.count > len(p.errs) {
+		p.errs = append(p.errs, fmt.Errorf("and %d more problems", p.count-len(p.errs)))
+	}
+
+	return errors.Join(p.errs...)
+}
+
+// Check validates every record, and checks that every reference (eg from a post
+// to its thread and user) is to a record in the dump.
+func (d Dump) Check() error {
+
+	p := &problems{}
+
+	users := map[int64]bool{}
+	userNames := map[string]bool{}
+	for _, user := range d.Users {
+		switch {
+		case users[user.ID]:
+			p.add("user %d appears more than once", user.ID)
+		case userNames[user.Name]:
+			p.add("user %d: name %s appears more than once", user.ID, user.Name)
+		}
+		if err := user.Validate(); err != nil {
+			p.add("user %d: %w", user.ID, err)
+		}
+		users[user.ID] = true
+		userNames[user.Name] = true
+	}
+
+	topics := map[int64]bool{}
+	topicNames := map[string]bool{}
+	for _, topic := range d.Topics {
+		switch {
+		case topics[topic.ID]:
+			p.add("topic %d appears more than once", topic.ID)
+		case topicNames[topic.Name]:
+			p.add("topic %d: name %s appears more than once", topic.ID, topic.Name)
+		}
+		if err := topic.Validate(); err != nil {
+			p.add("topic %d: %w", topic.ID, err)
+		}
+		if !users[topic.CreatedByID] {
+			p.add("topic %d: created by user %d, who is not in the dump", topic.ID, topic.CreatedByID)
+		}
+		topics[topic.ID] = true
+		topicNames[topic.Name] = true
+	}
+
+	threads := map[int64]bool{}
+	for _, thread := range d.Threads {
+		if threads[thread.ID] {
+			p.add("thread %d appears more than once", thread.ID)
+		}
+		if err := thread.Validate(); err != nil {
+			p.add("thread %d: %w", thread.ID, err)
+		}
+		if !topics[thread.TopicID] {
+			p.add("thread %d: in topic %d, which is not in the dump", thread.ID, thread.TopicID)
+		}
+		if !users[thread.CreatedByID] {
+			p.add("thread %d: created by user %d, who is not in the dump", thread.ID, thread.CreatedByID)
+		}
+		threads[thread.ID] = true
+	}
+
+	posts := map[int64]bool{}
+	for _, post := range d.Posts {
+		if posts[post.ID] {
+			p.add("post %d appears more than once", post.ID)
+		}
+		if err := post.Validate(); err != nil {
+			p.add("post %d: %w", post.ID, err)
+		}
+		if !threads[post.ThreadID] {
+			p.add("post %d: in thread %d, which is not in the dump", post.ID, post.ThreadID)
+		}
+		if !users[post.PostedByID] {
+			p.add("post %d: posted by user %d, who is not in the dump", post.ID, post.PostedByID)
+		}
+		posts[post.ID] = true
+	}
+
+	return p.err()
+}
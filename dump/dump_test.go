@@ -0,0 +1,164 @@
+package dump_test
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/pdk/forum/action"
+	"github.com/pdk/forum/dump"
+	"github.com/pdk/forum/model"
+	"github.com/pdk/forum/store"
+)
+
+func newDB(t *testing.T) *store.SQLStore {
+
+	ctx := context.Background()
+
+	db, _ := store.Open(":memory:")
+	t.Cleanup(func() { db.Close() })
+
+	_, err := db.MigrateUp(ctx)
+	if err != nil {
+		t.Fatalf("expected to migrate, but failed: %v", err)
+	}
+
+	return db
+}
+
+func TestExportImport(t *testing.T) {
+
+	ctx := context.Background()
+
+	source := newDB(t)
+	a := action.Actions{Store: source}
+
+	alice, _ := a.CreateUser(ctx, "alice")
+	bob, _ := a.CreateUser(ctx, "bob")
+	topic, _ := a.CreateTopic(ctx, alice, "golang")
+	thread, _, _ := a.CreateThread(ctx, alice, topic.ID, "hello", "first post")
+	a.CreatePost(ctx, bob, thread.ID, "second post")
+
+	buf := &bytes.Buffer{}
+	err := dump.Export(ctx, source, buf)
+	if err != nil {
+		t.Fatalf("expected to export, but failed: %v", err)
+	}
+	exported := buf.String()
+
+	d, err := dump.Read(strings.NewReader(exported))
+	if err != nil {
+		t.Fatalf("expected to read dump, but failed: %v", err)
+	}
+
+	target := newDB(t)
+	b := action.Actions{Store: target}
+	b.CreateUser(ctx, "carol")
+	b.CreateUser(ctx, "bob")
+
+	stats, err := dump.Import(ctx, target, d, dump.Options{MergeUsers: true})
+	if err != nil {
+		t.Fatalf("expected to import, but failed: %v", err)
+	}
+
+	if stats.Users != 1 || stats.Merged != 1 || stats.Topics != 1 || stats.Threads != 1 || stats.Posts != 2 {
+		t.Errorf("expected 1 user, 1 merged, 1 topic, 1 thread, 2 posts, but got %+v", stats)
+	}
+
+	if len(stats.MergedUsers) != 1 || stats.MergedUsers[0] != "bob" {
+		t.Errorf("expected bob to be reported merged, but got %v", stats.MergedUsers)
+	}
+
+	imported, err := target.GetTopicByName(ctx, "golang")
+	if err != nil {
+		t.Fatalf("expected to find imported topic, but failed: %v", err)
+	}
+
+	threads, _ := target.ListThreadsByTopicID(ctx, imported.ID, store.AllRows)
+	if len(threads) != 1 {
+		t.Fatalf("expected 1 thread, but got %d", len(threads))
+	}
+
+	posts, _ := target.ListPostsByThreadID(ctx, threads[0].ID, store.AllRows)
+	if len(posts) != 2 {
+		t.Fatalf("expected 2 posts, but got %d", len(posts))
+	}
+
+	poster, _ := target.GetUserByID(ctx, posts[1].PostedByID)
+	if poster.Name != "bob" {
+		t.Errorf("expected second post by bob, but got %s", poster.Name)
+	}
+
+	stats, err = dump.Import(ctx, target, d, dump.Options{MergeUsers: true})
+	if err != nil || stats.Skipped != 6 {
+		t.Errorf("expected to skip 6 already imported records, but got %+v, %v", stats, err)
+	}
+}
+
+func TestImportChecksReferences(t *testing.T) {
+
+	ctx := context.Background()
+
+	input := `{"format":"forum-dump","version":1,"id":"x"}
+{"type":"user","data":{"id":1,"name":"alice","role":"member"}}
+{"type":"post","data":{"id":1,"thread_id":7,"posted_by_id":1,"body":"orphan"}}
+`
+
+	d, err := dump.Read(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("expected to read dump, but failed: %v", err)
+	}
+
+	db := newDB(t)
+
+	_, err = dump.Import(ctx, db, d, dump.Options{})
+	if err == nil || !strings.Contains(err.Error(), "thread 7") {
+		t.Errorf("expected error about thread 7, but got %v", err)
+	}
+
+	users, _ := db.ListUsers(ctx, store.AllRows)
+	if len(users) != 0 {
+		t.Errorf("expected nothing imported, but got %d users", len(users))
+	}
+}
+
+func TestImportDistrustsUsers(t *testing.T) {
+
+	ctx := context.Background()
+
+	input := `{"format":"forum-dump","version":1,"id":"x"}
+{"type":"user","data":{"id":1,"name":"admin","role":"admin"}}
+{"type":"user","data":{"id":2,"name":"mallory","role":"admin"}}
+`
+
+	d, err := dump.Read(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("expected to read dump, but failed: %v", err)
+	}
+
+	db := newDB(t)
+	a := action.Actions{Store: db}
+	local, _ := a.CreateUser(ctx, "admin")
+
+	stats, err := dump.Import(ctx, db, d, dump.Options{})
+	if err != nil {
+		t.Fatalf("expected to import, but failed: %v", err)
+	}
+
+	if stats.Users != 2 || stats.Merged != 0 || len(stats.RenamedUsers) != 1 || stats.RenamedUsers[0] != "admin as admin (2)" {
+		t.Errorf("expected 2 new users, admin renamed, but got %+v", stats)
+	}
+
+	existing, _ := db.GetUserByName(ctx, "admin")
+	if existing.ID != local.ID {
+		t.Errorf("expected the local admin to be left alone, but got %+v", existing)
+	}
+
+	for _, name := range []string{"admin (2)", "mallory"} {
+		user, err := db.GetUserByName(ctx, name)
+		if err != nil || user.Role != model.RoleMember {
+			t.Errorf("expected %s imported as a member, but got %+v, %v", name, user, err)
+		}
+	}
+}
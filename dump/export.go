@@ -0,0 +1,125 @@
+package dump
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/pdk/forum/store"
+)
+
+// Export writes every user, topic, thread and post in the database to w.
+//
+// The server may keep running during an export: everything is read in one
+// read only transaction, so the dump is as the database was when it began.
+func Export(ctx context.Context, db store.Store, w io.Writer) error {
+	return db.ReadTx(ctx, func(tx store.Store) error {
+		return export(ctx, tx, w)
+	})
+}
+
+func export(ctx context.Context, db store.Store, w io.Writer) error {
+
+	id, err := newDumpID()
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(w)
+
+	err = enc.Encode(Header{
+		Format:     Format,
+		Version:    Version,
+		ID:         id,
+		ExportedAt: time.Now(),
+	})
+	if err != nil {
+		return fmt.Errorf("cannot write dump header: %w", err)
+	}
+
+	users, err := db.ListUsers(ctx, store.AllRows)
+	if err != nil {
+		return err
+	}
+
+	for _, user := range users {
+		err = writeRecord(enc, store.KindUser, user)
+		if err != nil {
+			return err
+		}
+	}
+
+	topics, err := db.ListTopics(ctx, store.AllRows)
+	if err != nil {
+		return err
+	}
+
+	for _, topic := range topics {
+		err = writeRecord(enc, store.KindTopic, topic)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, topic := range topics {
+
+		threads, err := db.ListThreadsByTopicID(ctx, topic.ID, store.AllRows)
+		if err != nil {
+			return err
+		}
+
+		for _, thread := range threads {
+
+			err = writeRecord(enc, store.KindThread, thread)
+			if err != nil {
+				return err
+			}
+
+			posts, err := db.ListPostsByThreadID(ctx, thread.ID, store.AllRows)
+			if err != nil {
+				return err
+			}
+
+			for _, post := range posts {
+				err = writeRecord(enc, store.KindPost, post)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func writeRecord(enc *json.Encoder, kind string, v interface{}) error {
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("cannot encode %s: %w", kind, err)
+	}
+
+	err = enc.Encode(Record{Type: kind, Data: data})
+	if err != nil {
+		return fmt.Errorf("cannot write %s: %w", kind, err)
+	}
+
+	return nil
+}
+
+// newDumpID returns a random ID for a dump, so that an import of it can be
+// resumed.
+func newDumpID() (string, error) {
+
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", fmt.Errorf("cannot generate dump id: %w", err)
+	}
+
+	return hex.EncodeToString(b), nil
+}
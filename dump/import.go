@@ -0,0 +1,237 @@
+package dump
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pdk/forum/model"
+	"github.com/pdk/forum/store"
+)
+
+// BatchSize is the number of records imported in each transaction.
+const BatchSize = 500
+
+// Stats counts what an import did.
+type Stats struct {
+	Users   int `json:"users"`
+	Topics  int `json:"topics"`
+	Threads int `json:"threads"`
+	Posts   int `json:"posts"`
+
+	// Merged counts users and topics which matched an existing one by name.
+	Merged int `json:"merged"`
+
+	// MergedUsers names the existing users which imported users were merged
+	// into, and RenamedUsers the imported users saved under a new name, eg
+	// "bob as bob (2)", because theirs was taken.
+	MergedUsers  []string `json:"merged_users,omitempty"`
+	RenamedUsers []string `json:"renamed_users,omitempty"`
+
+	// Skipped counts records imported by an earlier, interrupted, run.
+	Skipped int `json:"skipped"`
+}
+
+// Options say how far to trust the users in a dump. The zero Options are the
+// safe choice for a dump from elsewhere.
+type Options struct {
+	// KeepRoles keeps the roles in the dump, eg admin. Otherwise imported
+	// users are members.
+	KeepRoles bool
+
+	// MergeUsers merges users into existing users of the same name, so that
+	// their posts are attributed to the existing accounts. Otherwise they are
+	// saved under new names.
+	MergeUsers bool
+}
+
+// Import checks the dump, and then saves its records with new IDs. Topics with
+// the same name as existing ones are merged into them, and users as opts say.
+//
+// Records are committed in batches, along with the mapping from their old IDs
+// to new ones, so if an import is interrupted it may simply be run again: the
+// records already imported are skipped.
+func Import(ctx context.Context, db *store.SQLStore, d Dump, opts Options) (Stats, error) {
+
+	err := d.Check()
+	if err != nil {
+		return Stats{}, fmt.Errorf("dump has problems, nothing imported:\n%w", err)
+	}
+
+	imp := &importer{db: db, source: d.Header.ID}
+	defer imp.rollback()
+
+	for _, user := range d.Users {
+		if !opts.KeepRoles {
+			user.Role = model.RoleMember
+		}
+
+		err = imp.step(ctx, store.KindUser, user.ID, func(b *store.ImportBatch) error {
+			saved, created, err := b.ImportUser(ctx, user.ID, user, opts.MergeUsers)
+			if err != nil {
+				return err
+			}
+			imp.count(created, &imp.stats.Users)
+
+			switch {
+			case !created:
+				imp.stats.MergedUsers = append(imp.stats.MergedUsers, saved.Name)
+			case saved.Name != user.Name:
+				imp.stats.RenamedUsers = append(imp.stats.RenamedUsers, user.Name+" as "+saved.Name)
+			}
+
+			return nil
+		})
+		if err != nil {
+			return imp.stats, err
+		}
+	}
+
+	for _, topic := range d.Topics {
+		err = imp.step(ctx, store.KindTopic, topic.ID, func(b *store.ImportBatch) error {
+			var err error
+			topic.CreatedByID, err = mapID(ctx, b, store.KindUser, topic.CreatedByID)
+			if err != nil {
+				return err
+			}
+			_, created, err := b.ImportTopic(ctx, topic.ID, topic)
+			imp.count(created, &imp.stats.Topics)
+			return err
+		})
+		if err != nil {
+			return imp.stats, err
+		}
+	}
+
+	for _, thread := range d.Threads {
+		err = imp.step(ctx, store.KindThread, thread.ID, func(b *store.ImportBatch) error {
+			var err error
+			thread.TopicID, err = mapID(ctx, b, store.KindTopic, thread.TopicID)
+			if err != nil {
+				return err
+			}
+			thread.CreatedByID, err = mapID(ctx, b, store.KindUser, thread.CreatedByID)
+			if err != nil {
+				return err
+			}
+			_, err = b.ImportThread(ctx, thread.ID, thread)
+			imp.count(true, &imp.stats.Threads)
+			return err
+		})
+		if err != nil {
+			return imp.stats, err
+		}
+	}
+
+	for _, post := range d.Posts {
+		err = imp.step(ctx, store.KindPost, post.ID, func(b *store.ImportBatch) error {
+			var err error
+			post.ThreadID, err = mapID(ctx, b, store.KindThread, post.ThreadID)
+			if err != nil {
+				return err
+			}
+			post.PostedByID, err = mapID(ctx, b, store.KindUser, post.PostedByID)
+			if err != nil {
+				return err
+			}
+			_, err = b.ImportPost(ctx, post.ID, post)
+			imp.count(true, &imp.stats.Posts)
+			return err
+		})
+		if err != nil {
+			return imp.stats, err
+		}
+	}
+
+	return imp.stats, imp.commit()
+}
+
+// importer runs the import in batches.
+type importer struct {
+	db     *store.SQLStore
+	source string
+	batch  *store.ImportBatch
+	size   int
+	stats  Stats
+}
+
+// step imports one record, unless it was imported by an earlier run.
+func (imp *importer) step(ctx context.Context, kind string, oldID int64, save func(b *store.ImportBatch) error) error {
+
+	if imp.batch == nil {
+		batch, err := imp.db.BeginImport(ctx, imp.source)
+		if err != nil {
+			return err
+		}
+		imp.batch = batch
+		imp.size = 0
+	}
+
+	_, done, err := imp.batch.ImportedID(ctx, kind, oldID)
+	if err != nil {
+		return err
+	}
+
+	if done {
+		imp.stats.Skipped++
+		return nil
+	}
+
+	err = save(imp.batch)
+	if err != nil {
+		return fmt.Errorf("cannot import %s %d: %w", kind, oldID, err)
+	}
+
+	imp.size++
+	if imp.size >= BatchSize {
+		return imp.commit()
+	}
+
+	return nil
+}
+
+func (imp *importer) count(created bool, n *int) {
+
+	if created {
+		*n++
+	} else {
+		imp.stats.Merged++
+	}
+}
+
+func (imp *importer) commit() error {
+
+	if imp.batch == nil {
+		return nil
+	}
+
+	err := imp.batch.Commit()
+	imp.batch = nil
+	if err != nil {
+		return fmt.Errorf("cannot commit import: %w", err)
+	}
+
+	return nil
+}
+
+func (imp *importer) rollback() {
+
+	if imp.batch != nil {
+		imp.batch.Rollback()
+		imp.batch = nil
+	}
+}
+
+// mapID returns the new ID of a record which has already been imported.
+func mapID(ctx context.Context, b *store.ImportBatch, kind string, oldID int64) (int64, error) {
+
+	newID, ok, err := b.ImportedID(ctx, kind, oldID)
+	if err != nil {
+		return 0, err
+	}
+
+	if !ok {
+		return 0, fmt.Errorf("%s %d has not been imported", kind, oldID)
+	}
+
+	return newID, nil
+}
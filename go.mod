@@ -1,6 +1,12 @@
 module github.com/pdk/forum
 
-go 1.13
+go 1.20
+
+require (
+	github.com/andybalholm/brotli v1.1.1
+	github.com/lib/pq v1.10.9
+	github.com/mattn/go-sqlite3 v1.11.0
+)
 
 require (
 	github.com/fatih/color v1.7.0 // indirect
@@ -8,6 +14,5 @@ require (
 	github.com/howeyc/fsnotify v0.9.0 // indirect
 	github.com/mattn/go-colorable v0.1.4 // indirect
 	github.com/mattn/go-isatty v0.0.10 // indirect
-	github.com/mattn/go-sqlite3 v1.11.0
 	github.com/radovskyb/watcher v1.0.7 // indirect
 )
@@ -0,0 +1,389 @@
+// Package hook sends forum events to outgoing webhooks.
+package hook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pdk/forum/conf"
+	"github.com/pdk/forum/logging"
+	"github.com/pdk/forum/model"
+	"github.com/pdk/forum/store"
+)
+
+// Event names which may be subscribed to in the configuration.
+const (
+	ThreadCreated = "thread.created"
+	PostCreated   = "post.created"
+	TopicCreated  = "topic.created"
+	UserJoined    = "user.joined"
+)
+
+// Events lists all the event names.
+var Events = []string{ThreadCreated, PostCreated, TopicCreated, UserJoined}
+
+// Headers set on every delivery.
+const (
+	EventHeader     = "X-Forum-Event"
+	DeliveryHeader  = "X-Forum-Delivery"
+	SignatureHeader = "X-Forum-Signature"
+)
+
+// Payload is the JSON body sent to a webhook.
+type Payload struct {
+	Event      string      `json:"event"`
+	OccurredAt time.Time   `json:"occurred_at"`
+	Data       interface{} `json:"data"`
+}
+
+// Dispatcher records and delivers events to the configured webhooks. A nil
+// *Dispatcher is valid, and does nothing.
+type Dispatcher struct {
+	Store       store.DeliveryStore
+	Hooks       []conf.Webhook
+	Client      *http.Client
+	Workers     int
+	MaxAttempts int
+	Backoff     time.Duration
+
+	queue chan int64
+	quit  chan struct{}
+	wg    sync.WaitGroup
+}
+
+// NewDispatcher returns a Dispatcher for the given webhooks. Call Start to
+// begin delivering.
+func NewDispatcher(deliveries store.DeliveryStore, hooks []conf.Webhook) *Dispatcher {
+	return &Dispatcher{
+		Store:       deliveries,
+		Hooks:       hooks,
+		Client:      &http.Client{Timeout: 10 * time.Second},
+		Workers:     2,
+		MaxAttempts: 6,
+		Backoff:     2 * time.Second,
+		queue:       make(chan int64, 100),
+		quit:        make(chan struct{}),
+	}
+}
+
+// Start kicks off the delivery workers, and re-queues any deliveries left
+// pending by a previous run, including those it was sending when it stopped.
+func (d *Dispatcher) Start() {
+
+	if d == nil {
+		return
+	}
+
+	for i := 0; i < d.Workers; i++ {
+		d.wg.Add(1)
+		go d.work()
+	}
+
+	pending, err := d.Store.QueryPendingDeliveries(context.Background())
+	if err != nil {
+		logging.Default().Error("cannot re-queue pending webhook deliveries", "error", err)
+		return
+	}
+
+	for _, delivery := range pending {
+		if delivery.Status == model.DeliverySending {
+			_, err := d.Store.SetDeliveryStatus(context.Background(), delivery.ID, model.DeliveryPending, model.DeliverySending)
+			if err != nil {
+				logging.Default().Error("cannot re-queue webhook delivery", "delivery_id", delivery.ID, "error", err)
+				continue
+			}
+		}
+		go d.enqueue(delivery.ID)
+	}
+}
+
+// Stop signals the workers to finish, and waits for them. Deliveries not yet
+// made remain pending, and will be retried on the next Start.
+func (d *Dispatcher) Stop() {
+
+	if d == nil {
+		return
+	}
+
+	close(d.quit)
+	d.wg.Wait()
+}
+
+// Fire records a delivery of the event to each webhook subscribed to it, and
+// queues them to be sent. The deliveries are recorded even if the request
+// which caused the event has since gone away.
+func (d *Dispatcher) Fire(event string, data interface{}) {
+
+	if d == nil {
+		return
+	}
+
+	body, err := json.Marshal(Payload{
+		Event:      event,
+		OccurredAt: time.Now(),
+		Data:       data,
+	})
+	if err != nil {
+		logging.Default().Error("cannot encode webhook payload", "event", event, "error", err)
+		return
+	}
+
+	for i, hook := range d.Hooks {
+		if !subscribed(hook, event) {
+			continue
+		}
+
+		delivery, err := d.Store.CreateDelivery(context.Background(), model.NewDelivery(event, i, hook.URL, string(body)))
+		if err != nil {
+			logging.Default().Error("cannot record webhook delivery", "event", event, "error", err)
+			continue
+		}
+
+		go d.enqueue(delivery.ID)
+	}
+}
+
+// ErrDeliveryQueued is returned when replaying a delivery which is still
+// pending or being sent.
+var ErrDeliveryQueued = errors.New("delivery is already queued")
+
+// Replay resets a delivered or failed delivery to pending and queues it to be
+// sent again. A delivery still pending (eg waiting to retry) is left alone,
+// so that it is not sent twice.
+func (d *Dispatcher) Replay(ctx context.Context, deliveryID int64) error {
+
+	if d == nil {
+		return fmt.Errorf("webhooks are not enabled")
+	}
+
+	delivery, err := d.Store.GetDeliveryByID(ctx, deliveryID)
+	if err != nil {
+		return fmt.Errorf("cannot replay delivery: %w", err)
+	}
+
+	// claim it, so that nothing else sends it while it is reset.
+	claimed, err := d.Store.SetDeliveryStatus(ctx, deliveryID, model.DeliverySending, model.DeliveryDelivered, model.DeliveryFailed)
+	if err != nil {
+		return fmt.Errorf("cannot replay delivery: %w", err)
+	}
+
+	if !claimed {
+		return fmt.Errorf("cannot replay delivery %d: %w", deliveryID, ErrDeliveryQueued)
+	}
+
+	delivery.Status = model.DeliveryPending
+	delivery.Attempts = 0
+	delivery.UpdatedAt = time.Now()
+
+	err = d.Store.UpdateDelivery(ctx, delivery)
+	if err != nil {
+		return fmt.Errorf("cannot replay delivery: %w", err)
+	}
+
+	go d.enqueue(delivery.ID)
+
+	return nil
+}
+
+// CheckEvents returns an error if a webhook subscribes to an unknown event.
+func CheckEvents(hooks []conf.Webhook) error {
+
+	known := map[string]bool{"*": true}
+	for _, e := range Events {
+		known[e] = true
+	}
+
+	for i, hook := range hooks {
+		for _, e := range hook.Events {
+			if !known[e] {
+				return fmt.Errorf("Webhooks[%d].Events: unknown event %q, expected one of %s", i, e, strings.Join(Events, ", "))
+			}
+		}
+	}
+
+	return nil
+}
+
+// Sign returns the signature of the body, as sent in the X-Forum-Signature
+// header: "sha256=" followed by the hex HMAC-SHA256 of the body.
+func Sign(secret string, body []byte) string {
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func subscribed(hook conf.Webhook, event string) bool {
+
+	for _, e := range hook.Events {
+		if e == event || e == "*" {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (d *Dispatcher) enqueue(deliveryID int64) {
+	select {
+	case d.queue <- deliveryID:
+	case <-d.quit:
+	}
+}
+
+func (d *Dispatcher) work() {
+
+	defer d.wg.Done()
+
+	for {
+		select {
+		case id := <-d.queue:
+			d.attempt(id)
+		case <-d.quit:
+			return
+		}
+	}
+}
+
+// attempt makes one attempt at a delivery. On failure, another attempt is
+// scheduled with exponential backoff, until MaxAttempts is reached. The
+// delivery is claimed first, so that it is only sent once however many times
+// it was queued.
+func (d *Dispatcher) attempt(deliveryID int64) {
+
+	ctx := context.Background()
+
+	claimed, err := d.Store.SetDeliveryStatus(ctx, deliveryID, model.DeliverySending, model.DeliveryPending)
+	if err != nil {
+		logging.Default().Error("cannot claim webhook delivery", "delivery_id", deliveryID, "error", err)
+		return
+	}
+	if !claimed {
+		return
+	}
+
+	delivery, err := d.Store.GetDeliveryByID(ctx, deliveryID)
+	if err != nil {
+		logging.Default().Error("cannot load webhook delivery", "delivery_id", deliveryID, "error", err)
+		d.release(ctx, deliveryID)
+		return
+	}
+
+	delivery.Attempts++
+	delivery.ResponseCode, err = d.send(delivery)
+	delivery.UpdatedAt = time.Now()
+
+	switch {
+	case err == nil:
+		delivery.Status = model.DeliveryDelivered
+		delivery.LastError = ""
+	case delivery.Attempts >= d.MaxAttempts:
+		delivery.Status = model.DeliveryFailed
+		delivery.LastError = err.Error()
+	default:
+		delivery.Status = model.DeliveryPending
+		delivery.LastError = err.Error()
+	}
+
+	err = d.Store.UpdateDelivery(ctx, delivery)
+	if err != nil {
+		logging.Default().Error("cannot save webhook delivery", "delivery_id", deliveryID, "error", err)
+		return
+	}
+
+	if delivery.Status == model.DeliveryPending {
+		wait := d.Backoff << uint(delivery.Attempts-1)
+		time.AfterFunc(wait, func() { d.enqueue(deliveryID) })
+	}
+}
+
+// release returns a claimed delivery which could not be attempted to pending,
+// and tries it again after Backoff.
+func (d *Dispatcher) release(ctx context.Context, deliveryID int64) {
+
+	released, err := d.Store.SetDeliveryStatus(ctx, deliveryID, model.DeliveryPending, model.DeliverySending)
+	if err != nil {
+		logging.Default().Error("cannot release webhook delivery", "delivery_id", deliveryID, "error", err)
+		return
+	}
+
+	if released {
+		time.AfterFunc(d.Backoff, func() { d.enqueue(deliveryID) })
+	}
+}
+
+// send posts the payload to the webhook, and returns the response status code.
+func (d *Dispatcher) send(delivery model.Delivery) (int, error) {
+
+	hook, ok := d.hookFor(delivery)
+	if !ok {
+		return 0, fmt.Errorf("no webhook configured for %s", delivery.URL)
+	}
+
+	body := []byte(delivery.Payload)
+
+	req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, fmt.Errorf("cannot build request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "forum-webhook")
+	req.Header.Set(EventHeader, delivery.Event)
+	req.Header.Set(DeliveryHeader, strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set(SignatureHeader, Sign(hook.Secret, body))
+
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("webhook responded %s", resp.Status)
+	}
+
+	return resp.StatusCode, nil
+}
+
+// hookFor returns the configured webhook a delivery is for, by its index, as
+// long as it still has the same URL. Deliveries recorded without an index are
+// matched by URL, if only one webhook has it, so that a secret is never used
+// for another webhook.
+func (d *Dispatcher) hookFor(delivery model.Delivery) (conf.Webhook, bool) {
+
+	if delivery.Hook >= 0 {
+		if delivery.Hook < len(d.Hooks) && d.Hooks[delivery.Hook].URL == delivery.URL {
+			return d.Hooks[delivery.Hook], true
+		}
+		return conf.Webhook{}, false
+	}
+
+	found := []conf.Webhook{}
+	for _, hook := range d.Hooks {
+		if hook.URL == delivery.URL {
+			found = append(found, hook)
+		}
+	}
+
+	if len(found) != 1 {
+		return conf.Webhook{}, false
+	}
+
+	return found[0], true
+}
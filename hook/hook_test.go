@@ -0,0 +1,228 @@
+package hook_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pdk/forum/conf"
+	"github.com/pdk/forum/hook"
+	"github.com/pdk/forum/model"
+	"github.com/pdk/forum/store"
+)
+
+func newDB(t *testing.T) *store.SQLStore {
+
+	db, _ := store.Open(":memory:")
+	t.Cleanup(func() { db.Close() })
+
+	_, err := db.MigrateUp(context.Background())
+	if err != nil {
+		t.Fatalf("expected to migrate database, but failed: %v", err)
+	}
+
+	return db
+}
+
+func TestFireRetriesAndSigns(t *testing.T) {
+
+	ctx := context.Background()
+
+	db := newDB(t)
+
+	calls := make(chan bool, 10)
+	var attempts int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.Header.Get(hook.SignatureHeader) != hook.Sign("sekrit", body) {
+			t.Errorf("expected valid signature, but got %s", r.Header.Get(hook.SignatureHeader))
+		}
+
+		// fail the first attempt, to exercise the retry.
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			calls <- false
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		calls <- true
+	}))
+	defer receiver.Close()
+
+	d := hook.NewDispatcher(db, []conf.Webhook{
+		{URL: receiver.URL, Secret: "sekrit", Events: []string{hook.PostCreated}},
+	})
+	d.Backoff = 10 * time.Millisecond
+	d.Start()
+	defer d.Stop()
+
+	d.Fire(hook.TopicCreated, model.NewTopic(1, "not subscribed"))
+	d.Fire(hook.PostCreated, model.NewPost(1, 1, "hello"))
+
+	for _, expected := range []bool{false, true} {
+		select {
+		case ok := <-calls:
+			if ok != expected {
+				t.Errorf("expected call success %t, but got %t", expected, ok)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for webhook call")
+		}
+	}
+
+	var delivery model.Delivery
+	var err error
+	for i := 0; i < 100; i++ {
+		delivery, err = db.GetDeliveryByID(ctx, 1)
+		if err == nil && delivery.Status != model.DeliveryPending && delivery.Status != model.DeliverySending {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if delivery.Status != model.DeliveryDelivered || delivery.Attempts != 2 {
+		t.Errorf("expected delivered after 2 attempts, but got %s after %d", delivery.Status, delivery.Attempts)
+	}
+
+	deliveries, _ := db.QueryRecentDeliveries(ctx, 10)
+	if len(deliveries) != 1 {
+		t.Errorf("expected 1 delivery recorded, but got %d", len(deliveries))
+	}
+}
+
+// flakyDeliveries fails to load a delivery the first time.
+type flakyDeliveries struct {
+	store.DeliveryStore
+	failed int32
+}
+
+func (f *flakyDeliveries) GetDeliveryByID(ctx context.Context, deliveryID int64) (model.Delivery, error) {
+
+	if atomic.CompareAndSwapInt32(&f.failed, 0, 1) {
+		return model.Delivery{}, errors.New("database is busy")
+	}
+
+	return f.DeliveryStore.GetDeliveryByID(ctx, deliveryID)
+}
+
+func TestLoadFailureRetries(t *testing.T) {
+
+	ctx := context.Background()
+
+	db := newDB(t)
+
+	calls := make(chan bool, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls <- true
+	}))
+	defer receiver.Close()
+
+	d := hook.NewDispatcher(&flakyDeliveries{DeliveryStore: db}, []conf.Webhook{
+		{URL: receiver.URL, Secret: "sekrit", Events: []string{hook.PostCreated}},
+	})
+	d.Backoff = 10 * time.Millisecond
+	d.Start()
+	defer d.Stop()
+
+	d.Fire(hook.PostCreated, model.NewPost(1, 1, "hello"))
+
+	select {
+	case <-calls:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the delivery to be retried after failing to load it")
+	}
+
+	var delivery model.Delivery
+	for i := 0; i < 100; i++ {
+		delivery, _ = db.GetDeliveryByID(ctx, 1)
+		if delivery.Status == model.DeliveryDelivered {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if delivery.Status != model.DeliveryDelivered || delivery.Attempts != 1 {
+		t.Errorf("expected delivered after 1 attempt, but got %s after %d", delivery.Status, delivery.Attempts)
+	}
+}
+
+func TestSharedURLAndReplay(t *testing.T) {
+
+	ctx := context.Background()
+
+	db := newDB(t)
+
+	signatures := make(chan string, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		switch r.Header.Get(hook.SignatureHeader) {
+		case hook.Sign("first", body):
+			signatures <- "first"
+		case hook.Sign("second", body):
+			signatures <- "second"
+		default:
+			signatures <- "neither"
+		}
+	}))
+	defer receiver.Close()
+
+	// two webhooks at one URL, for different events, with different secrets.
+	d := hook.NewDispatcher(db, []conf.Webhook{
+		{URL: receiver.URL, Secret: "first", Events: []string{hook.TopicCreated}},
+		{URL: receiver.URL, Secret: "second", Events: []string{hook.PostCreated}},
+	})
+	d.Start()
+	defer d.Stop()
+
+	d.Fire(hook.PostCreated, model.NewPost(1, 1, "hello"))
+
+	select {
+	case signature := <-signatures:
+		if signature != "second" {
+			t.Errorf("expected the post.created webhook's secret, but got %s", signature)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for webhook call")
+	}
+
+	for i := 0; i < 100; i++ {
+		delivery, err := db.GetDeliveryByID(ctx, 1)
+		if err == nil && delivery.Status == model.DeliveryDelivered {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	err := d.Replay(ctx, 1)
+	if err != nil {
+		t.Fatalf("expected to replay the delivery, but failed: %v", err)
+	}
+
+	// replaying again while it is queued is refused, so it is not sent twice.
+	// (unless it was delivered in between, when it is sent again.)
+	expected := 2
+	err = d.Replay(ctx, 1)
+	if errors.Is(err, hook.ErrDeliveryQueued) {
+		expected = 1
+	} else if err != nil {
+		t.Errorf("expected ErrDeliveryQueued, but got %v", err)
+	}
+
+	for i := 0; i < expected; i++ {
+		select {
+		case <-signatures:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for replayed webhook call")
+		}
+	}
+
+	select {
+	case <-signatures:
+		t.Errorf("expected the replayed delivery to be sent %d times, but got more", expected)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,281 @@
+// Package logging writes leveled, structured log records, as logfmt or JSON
+// lines, eg:
+//
+//	time=2026-01-02T15:04:05.000Z level=info msg=request method=GET path=/topics status=200
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level is how important a record is.
+type Level int
+
+// The levels, least important first.
+const (
+	Debug Level = iota
+	Info
+	Warn
+	Error
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+
+	if l < Debug || l > Error {
+		return "level(" + strconv.Itoa(int(l)) + ")"
+	}
+
+	return levelNames[l]
+}
+
+// ParseLevel returns the level named, eg "info".
+func ParseLevel(name string) (Level, error) {
+
+	for i, levelName := range levelNames {
+		if strings.EqualFold(name, levelName) {
+			return Level(i), nil
+		}
+	}
+
+	return Info, fmt.Errorf("%q is not one of %s", name, strings.Join(levelNames, ", "))
+}
+
+// The formats a Logger can write.
+const (
+	LogFmt = "logfmt"
+	JSON   = "json"
+)
+
+// Logger writes records at or above its level. Loggers made by With share
+// their parent's output.
+type Logger struct {
+	out    *output
+	level  Level
+	format string
+	fields []interface{} // key, value pairs added to every record
+}
+
+// output serializes writes from a Logger and those made from it.
+type output struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// New returns a Logger writing records at or above level to w, in the format
+// (LogFmt or JSON).
+func New(w io.Writer, format string, level Level) *Logger {
+	return &Logger{out: &output{w: w}, level: level, format: format}
+}
+
+var (
+	defaultMu     sync.Mutex
+	defaultLogger = New(os.Stderr, LogFmt, Info)
+)
+
+// Default returns the Logger used when no other is at hand.
+func Default() *Logger {
+
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+
+	return defaultLogger
+}
+
+// SetDefault replaces the default Logger.
+func SetDefault(l *Logger) {
+
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+
+	defaultLogger = l
+}
+
+// With returns a Logger which adds the key, value pairs to every record.
+func (l *Logger) With(keyValues ...interface{}) *Logger {
+
+	fields := make([]interface{}, 0, len(l.fields)+len(keyValues))
+	fields = append(fields, l.fields...)
+	fields = append(fields, keyValues...)
+
+	return &Logger{out: l.out, level: l.level, format: l.format, fields: fields}
+}
+
+// Enabled reports whether records at level are written.
+func (l *Logger) Enabled(level Level) bool {
+	return level >= l.level
+}
+
+// Debug writes a record for developers.
+func (l *Logger) Debug(msg string, keyValues ...interface{}) {
+	l.Log(Debug, msg, keyValues...)
+}
+
+// Info writes a record of something which happened as it should.
+func (l *Logger) Info(msg string, keyValues ...interface{}) {
+	l.Log(Info, msg, keyValues...)
+}
+
+// Warn writes a record of something which may need attention.
+func (l *Logger) Warn(msg string, keyValues ...interface{}) {
+	l.Log(Warn, msg, keyValues...)
+}
+
+// Error writes a record of something which failed.
+func (l *Logger) Error(msg string, keyValues ...interface{}) {
+	l.Log(Error, msg, keyValues...)
+}
+
+// Log writes a record at level, with the message and the key, value pairs,
+// after the Logger's own.
+func (l *Logger) Log(level Level, msg string, keyValues ...interface{}) {
+
+	if !l.Enabled(level) {
+		return
+	}
+
+	fields := []interface{}{
+		"time", time.Now().UTC().Format("2006-01-02T15:04:05.000Z07:00"),
+		"level", level.String(),
+		"msg", msg,
+	}
+	fields = append(fields, l.fields...)
+	fields = append(fields, keyValues...)
+	if len(fields)%2 != 0 {
+		fields = append(fields, "!MISSING")
+	}
+
+	buf := bytes.Buffer{}
+	if l.format == JSON {
+		writeJSON(&buf, fields)
+	} else {
+		writeLogFmt(&buf, fields)
+	}
+	buf.WriteByte('\n')
+
+	l.out.mu.Lock()
+	defer l.out.mu.Unlock()
+
+	l.out.w.Write(buf.Bytes())
+}
+
+// Writer returns a writer which logs each line written to it at level, for
+// the standard log package (see log.SetOutput).
+func (l *Logger) Writer(level Level) io.Writer {
+	return lineWriter{l, level}
+}
+
+type lineWriter struct {
+	l     *Logger
+	level Level
+}
+
+func (lw lineWriter) Write(p []byte) (int, error) {
+
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		lw.l.Log(lw.level, line)
+	}
+
+	return len(p), nil
+}
+
+func writeLogFmt(buf *bytes.Buffer, fields []interface{}) {
+
+	for i := 0; i < len(fields); i += 2 {
+		if i > 0 {
+			buf.WriteByte(' ')
+		}
+		buf.WriteString(fmt.Sprint(fields[i]))
+		buf.WriteByte('=')
+		buf.WriteString(logFmtValue(fields[i+1]))
+	}
+}
+
+// logFmtValue formats a value, quoting it if it is blank or has spaces,
+// quotes, equals signs or control characters.
+func logFmtValue(v interface{}) string {
+
+	s := valueString(v)
+
+	if s == "" || strings.ContainsAny(s, " \"=\\") || strings.IndexFunc(s, func(r rune) bool { return r < ' ' }) >= 0 {
+		return strconv.Quote(s)
+	}
+
+	return s
+}
+
+func writeJSON(buf *bytes.Buffer, fields []interface{}) {
+
+	buf.WriteByte('{')
+	for i := 0; i < len(fields); i += 2 {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+
+		key, _ := json.Marshal(fmt.Sprint(fields[i]))
+		buf.Write(key)
+		buf.WriteByte(':')
+
+		value, err := json.Marshal(jsonValue(fields[i+1]))
+		if err != nil {
+			value, _ = json.Marshal(valueString(fields[i+1]))
+		}
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+}
+
+// jsonValue keeps numbers and booleans as they are, and makes anything else
+// a string.
+func jsonValue(v interface{}) interface{} {
+
+	switch v.(type) {
+	case int, int32, int64, uint, uint32, uint64, float32, float64, bool:
+		return v
+	}
+
+	return valueString(v)
+}
+
+func valueString(v interface{}) string {
+
+	switch v := v.(type) {
+	case string:
+		return v
+	case error:
+		return v.Error()
+	case time.Duration:
+		return v.String()
+	case nil:
+		return ""
+	}
+
+	return fmt.Sprint(v)
+}
+
+type contextKey struct{}
+
+// NewContext returns a context carrying the Logger, eg one With a request ID.
+func NewContext(ctx context.Context, l *Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, l)
+}
+
+// FromContext returns the Logger in the context, or the Default.
+func FromContext(ctx context.Context) *Logger {
+
+	if l, ok := ctx.Value(contextKey{}).(*Logger); ok {
+		return l
+	}
+
+	return Default()
+}
@@ -0,0 +1,66 @@
+package logging_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/pdk/forum/logging"
+)
+
+func TestLogFmt(t *testing.T) {
+
+	buf := bytes.Buffer{}
+	logger := logging.New(&buf, logging.LogFmt, logging.Info).With("request_id", "abc")
+
+	logger.Info("request failed", "status", 500, "error", errors.New(`no "such" thing`))
+
+	line := buf.String()
+	_, line, _ = strings.Cut(line, " ") // drop the time
+
+	expected := `level=info msg="request failed" request_id=abc status=500 error="no \"such\" thing"` + "\n"
+	if line != expected {
+		t.Errorf("expected %s, but got %s", expected, line)
+	}
+}
+
+func TestJSON(t *testing.T) {
+
+	buf := bytes.Buffer{}
+	logger := logging.New(&buf, logging.JSON, logging.Info)
+
+	logger.Warn("slow", "duration_ms", 1.5, "path", "/topics")
+
+	record := map[string]interface{}{}
+	err := json.Unmarshal(buf.Bytes(), &record)
+	if err != nil {
+		t.Fatalf("expected a JSON record, but got %s: %v", buf.String(), err)
+	}
+
+	if record["level"] != "warn" || record["msg"] != "slow" || record["duration_ms"] != 1.5 || record["path"] != "/topics" {
+		t.Errorf("expected the record's fields, but got %v", record)
+	}
+}
+
+func TestLevels(t *testing.T) {
+
+	buf := bytes.Buffer{}
+	logger := logging.New(&buf, logging.LogFmt, logging.Warn)
+
+	logger.Debug("debug")
+	logger.Info("info")
+	logger.Warn("warn")
+	logger.Error("error")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[0], "level=warn") || !strings.Contains(lines[1], "level=error") {
+		t.Errorf("expected warn and error records, but got %s", buf.String())
+	}
+
+	_, err := logging.ParseLevel("loud")
+	if err == nil {
+		t.Errorf("expected an unknown level to be refused")
+	}
+}
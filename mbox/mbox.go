@@ -0,0 +1,245 @@
+// Package mbox reads mailing list archives in mbox format, and turns them into
+// dumps which can be imported: each archive becomes a topic, and each
+// conversation a thread of posts.
+package mbox
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+	"time"
+)
+
+// fromLineTime is the layout of the date on an mbox "From " line.
+const fromLineTime = "Mon Jan _2 15:04:05 2006"
+
+// Message is one message from an archive.
+type Message struct {
+	ID         string
+	InReplyTo  string
+	References []string
+	From       *mail.Address
+	Subject    string
+	List       string
+	Date       time.Time
+	Body       string
+}
+
+// Read splits an mbox archive into messages, and parses each.
+func Read(r io.Reader) ([]Message, error) {
+
+	messages := []Message{}
+	raw := &bytes.Buffer{}
+	fromLine := ""
+	blankBefore := true
+	count := 0
+
+	flush := func() error {
+		if fromLine == "" {
+			return nil
+		}
+		count++
+		// the blank line before the next From line is not part of the message.
+		content := bytes.TrimSuffix(raw.Bytes(), []byte("\n"))
+		msg, err := parseMessage(content, fromLine)
+		if err != nil {
+			return fmt.Errorf("cannot read message %d: %w", count, err)
+		}
+		messages = append(messages, msg)
+		raw.Reset()
+		return nil
+	}
+
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadString('\n')
+		if line == "" && errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil && !errors.Is(err, io.EOF) {
+			return messages, fmt.Errorf("cannot read mbox: %w", err)
+		}
+
+		switch {
+		case blankBefore && strings.HasPrefix(line, "From "):
+			err = flush()
+			if err != nil {
+				return messages, err
+			}
+			fromLine = line
+		case fromLine == "":
+			if strings.TrimSpace(line) != "" {
+				return messages, fmt.Errorf("not an mbox archive: no From line at the start")
+			}
+		default:
+			// mboxrd escapes lines starting with "From " as ">From ".
+			if strings.HasPrefix(strings.TrimLeft(line, ">"), "From ") {
+				line = line[1:]
+			}
+			raw.WriteString(line)
+		}
+
+		blankBefore = strings.TrimSpace(line) == ""
+	}
+
+	return messages, flush()
+}
+
+// parseMessage parses one message. fromLine gives its date, if the Date header
+// is missing or bad.
+func parseMessage(raw []byte, fromLine string) (Message, error) {
+
+	m, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return Message{}, err
+	}
+
+	msg := Message{
+		ID:         strings.TrimSpace(m.Header.Get("Message-Id")),
+		InReplyTo:  firstMessageID(m.Header.Get("In-Reply-To")),
+		References: strings.Fields(m.Header.Get("References")),
+		Subject:    decodeHeader(m.Header.Get("Subject")),
+		List:       listName(decodeHeader(m.Header.Get("List-Id"))),
+	}
+
+	from, err := m.Header.AddressList("From")
+	if err != nil || len(from) == 0 {
+		msg.From = &mail.Address{Address: strings.TrimSpace(m.Header.Get("From"))}
+	} else {
+		msg.From = from[0]
+	}
+
+	msg.Date, err = m.Header.Date()
+	if err != nil {
+		msg.Date = fromLineDate(fromLine)
+	}
+
+	msg.Body, err = textBody(m.Header.Get("Content-Type"), m.Header.Get("Content-Transfer-Encoding"), m.Body)
+	if err != nil {
+		return msg, err
+	}
+
+	return msg, nil
+}
+
+// firstMessageID returns the first <...> in an In-Reply-To header, which may
+// have other text around it.
+func firstMessageID(header string) string {
+
+	start := strings.Index(header, "<")
+	end := strings.Index(header, ">")
+	if start < 0 || end < start {
+		return strings.TrimSpace(header)
+	}
+
+	return header[start : end+1]
+}
+
+// listName returns the description from a List-Id header, eg "Go Nuts" from
+// "Go Nuts <golang-nuts.googlegroups.com>", or else the id.
+func listName(listID string) string {
+
+	start := strings.Index(listID, "<")
+	if start < 0 {
+		return strings.TrimSpace(listID)
+	}
+
+	name := strings.Trim(strings.TrimSpace(listID[:start]), `"`)
+	if name != "" {
+		return name
+	}
+
+	return strings.Trim(strings.TrimSpace(listID[start:]), "<>")
+}
+
+func decodeHeader(header string) string {
+
+	decoded, err := new(mime.WordDecoder).DecodeHeader(header)
+	if err != nil {
+		return header
+	}
+
+	return decoded
+}
+
+// fromLineDate parses the date at the end of an mbox "From " line, eg
+// "From pdk@example.com Mon Jan  2 15:04:05 2006".
+func fromLineDate(fromLine string) time.Time {
+
+	fromLine = strings.TrimSpace(fromLine)
+	if len(fromLine) < len(fromLineTime) {
+		return time.Time{}
+	}
+
+	date, err := time.Parse(fromLineTime, fromLine[len(fromLine)-len(fromLineTime):])
+	if err != nil {
+		return time.Time{}
+	}
+
+	return date
+}
+
+// textBody returns the plain text of a message body, from the first text/plain
+// part if it is multipart.
+func textBody(contentType, encoding string, body io.Reader) (string, error) {
+
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = "text/plain"
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		parts := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := parts.NextRawPart()
+			if err != nil {
+				return "", nil
+			}
+
+			text, err := textBody(part.Header.Get("Content-Type"), part.Header.Get("Content-Transfer-Encoding"), part)
+			if err != nil || text != "" {
+				return text, err
+			}
+		}
+	}
+
+	if mediaType != "text/plain" {
+		return "", nil
+	}
+
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	}
+
+	text, err := io.ReadAll(body)
+	if err != nil {
+		return "", fmt.Errorf("cannot decode body: %w", err)
+	}
+
+	if strings.EqualFold(params["charset"], "iso-8859-1") {
+		return latin1(text), nil
+	}
+
+	return string(text), nil
+}
+
+func latin1(b []byte) string {
+
+	runes := make([]rune, len(b))
+	for i, c := range b {
+		runes[i] = rune(c)
+	}
+
+	return string(runes)
+}
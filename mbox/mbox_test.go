@@ -0,0 +1,149 @@
+package mbox_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/pdk/forum/dump"
+	"github.com/pdk/forum/mbox"
+	"github.com/pdk/forum/model"
+	"github.com/pdk/forum/store"
+)
+
+const archive = `From alice@example.com Mon Jan  2 15:04:05 2006
+From: Alice <alice@example.com>
+Subject: [golang] Hello
+Message-ID: <1@example.com>
+Date: Mon, 2 Jan 2006 15:04:05 +0000
+List-Id: Go Talk <golang.example.com>
+
+First post.
+>From the archive.
+
+From bob@example.com Mon Jan  2 16:04:05 2006
+From: bob@example.com
+Subject: Re: [golang] Hello
+Message-ID: <2@example.com>
+In-Reply-To: <1@example.com>
+References: <1@example.com>
+Date: Mon, 2 Jan 2006 16:04:05 +0000
+Content-Type: text/plain; charset=utf-8
+Content-Transfer-Encoding: quoted-printable
+
+Caf=C3=A9 reply.
+
+From carol@example.com Mon Jan  2 17:04:05 2006
+From: =?utf-8?q?Carol?= <carol@example.com>
+Subject: Re: Hello
+Message-ID: <3@example.com>
+Date: Mon, 2 Jan 2006 17:04:05 +0000
+
+Reply, without references.
+
+From alice@example.com Tue Jan  3 15:04:05 2006
+From: Alice <alice@example.com>
+Subject: Another subject
+Message-ID: <4@example.com>
+Date: Tue, 3 Jan 2006 15:04:05 +0000
+
+New thread.
+`
+
+func TestConvert(t *testing.T) {
+
+	messages, err := mbox.Read(strings.NewReader(archive))
+	if err != nil {
+		t.Fatalf("expected to read archive, but failed: %v", err)
+	}
+
+	if len(messages) != 4 {
+		t.Fatalf("expected 4 messages, but got %d", len(messages))
+	}
+
+	if messages[0].List != "Go Talk" {
+		t.Errorf("expected list Go Talk, but got %q", messages[0].List)
+	}
+
+	if messages[0].Body != "First post.\nFrom the archive.\n" {
+		t.Errorf("expected >From to be unescaped, but got %q", messages[0].Body)
+	}
+
+	if !strings.Contains(messages[1].Body, "Café") {
+		t.Errorf("expected quoted-printable to be decoded, but got %q", messages[1].Body)
+	}
+
+	d := mbox.Convert("golang", "test", messages)
+
+	err = d.Check()
+	if err != nil {
+		t.Fatalf("expected a valid dump, but got %v", err)
+	}
+
+	if len(d.Users) != 3 || len(d.Threads) != 2 || len(d.Posts) != 4 {
+		t.Fatalf("expected 3 users, 2 threads, 4 posts, but got %d, %d, %d", len(d.Users), len(d.Threads), len(d.Posts))
+	}
+
+	if d.Threads[0].Subject != "Hello" {
+		t.Errorf("expected subject Hello, but got %q", d.Threads[0].Subject)
+	}
+
+	for i, threadID := range []int64{1, 1, 1, 2} {
+		if d.Posts[i].ThreadID != threadID {
+			t.Errorf("expected post %d in thread %d, but got %d", i, threadID, d.Posts[i].ThreadID)
+		}
+	}
+
+	// bob has only an address, which is not published.
+	if d.Users[1].Name != "unknown" || d.Users[2].Name != "Carol" {
+		t.Errorf("expected users unknown and Carol, but got %s and %s", d.Users[1].Name, d.Users[2].Name)
+	}
+
+	if !d.Posts[3].PostedAt.Equal(messages[3].Date) {
+		t.Errorf("expected post dated %s, but got %s", messages[3].Date, d.Posts[3].PostedAt)
+	}
+}
+
+func TestImportKeepsSendersApart(t *testing.T) {
+
+	ctx := context.Background()
+
+	db, _ := store.Open(":memory:")
+	defer db.Close()
+
+	_, err := db.MigrateUp(ctx)
+	if err != nil {
+		t.Fatalf("expected to migrate, but failed: %v", err)
+	}
+
+	local, err := db.CreateUser(ctx, model.NewUser("Alice"))
+	if err != nil {
+		t.Fatalf("expected to create user, but failed: %v", err)
+	}
+
+	messages, _ := mbox.Read(strings.NewReader(archive))
+
+	_, err = dump.Import(ctx, db, mbox.Convert("golang", "test", messages), dump.Options{})
+	if err != nil {
+		t.Fatalf("expected to import, but failed: %v", err)
+	}
+
+	posts, _ := db.QueryRecentPosts(ctx, 10)
+	for _, post := range posts {
+		if post.PostedByID == local.ID {
+			t.Errorf("expected no posts by the local Alice, but got %+v", post)
+		}
+	}
+
+	users, _ := db.ListUsers(ctx, store.AllRows)
+	for _, user := range users {
+		if strings.Contains(user.Name, "@") {
+			t.Errorf("expected no addresses as names, but got %s", user.Name)
+		}
+	}
+
+	_, err = db.GetUserByName(ctx, "Alice (2)")
+	if err != nil {
+		t.Errorf("expected the sender Alice to be imported as Alice (2), but got %v", err)
+	}
+}
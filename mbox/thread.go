@@ -0,0 +1,277 @@
+package mbox
+
+import (
+	"fmt"
+	"net/mail"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/pdk/forum/dump"
+	"github.com/pdk/forum/model"
+)
+
+// Placeholders for what a message may lack, but a post may not.
+const (
+	noSubject = "(no subject)"
+	noText    = "(no text)"
+	noSender  = "unknown"
+)
+
+// Convert makes a dump of the messages, ready for dump.Import. The messages
+// become one topic, with each conversation a thread. Messages are put into
+// conversations by their References and In-Reply-To headers, or failing that,
+// replies by their subject. Senders become users, named by their display name,
+// never their address, which is not published. source identifies the archive,
+// so that an import of it can be resumed.
+//
+// Display names are whatever the sender chose, so import the dump without
+// merging users (the zero dump.Options): a sender calling themselves "admin"
+// then becomes a new user, eg "admin (2)", not the forum's admin.
+func Convert(topicName, source string, messages []Message) dump.Dump {
+
+	messages = uniqueByID(messages)
+	sort.SliceStable(messages, func(i, j int) bool {
+		return messages[i].Date.Before(messages[j].Date)
+	})
+
+	d := dump.Dump{
+		Header: dump.Header{
+			Format:     dump.Format,
+			Version:    dump.Version,
+			ID:         source,
+			ExportedAt: time.Now(),
+		},
+	}
+
+	if len(messages) == 0 {
+		return d
+	}
+
+	users := newSenders()
+	roots := conversations(messages)
+	threadIDs := map[int]int64{}
+
+	for i, msg := range messages {
+
+		userID, created := users.id(msg.From)
+		if created {
+			d.Users = append(d.Users, model.User{
+				ID:       userID,
+				JoinedAt: msg.Date,
+				Name:     users.names[userID-1],
+				Role:     model.RoleMember,
+			})
+		}
+
+		if i == 0 {
+			d.Topics = append(d.Topics, model.Topic{ID: 1, CreatedByID: userID, Name: truncate(topicName, model.MaxNameLength)})
+		}
+
+		threadID, ok := threadIDs[roots[i]]
+		if !ok {
+			threadID = int64(len(d.Threads) + 1)
+			threadIDs[roots[i]] = threadID
+
+			subject := strings.TrimSpace(baseSubject(messages[roots[i]].Subject))
+			if subject == "" {
+				subject = noSubject
+			}
+
+			d.Threads = append(d.Threads, model.Thread{
+				ID:          threadID,
+				TopicID:     1,
+				CreatedByID: userID,
+				Subject:     truncate(subject, model.MaxSubjectLength),
+			})
+		}
+
+		body := strings.TrimSpace(strings.ReplaceAll(msg.Body, "\r\n", "\n"))
+		if body == "" {
+			body = noText
+		}
+
+		d.Posts = append(d.Posts, model.Post{
+			ID:         int64(i + 1),
+			ThreadID:   threadID,
+			PostedByID: userID,
+			PostedAt:   msg.Date,
+			Body:       truncate(body, model.MaxBodyLength),
+		})
+	}
+
+	return d
+}
+
+// uniqueByID drops messages with the same Message-ID as an earlier one, as
+// archives often have duplicates.
+func uniqueByID(messages []Message) []Message {
+
+	seen := map[string]bool{}
+	unique := []Message{}
+
+	for _, msg := range messages {
+		if msg.ID != "" && seen[msg.ID] {
+			continue
+		}
+		seen[msg.ID] = true
+		unique = append(unique, msg)
+	}
+
+	return unique
+}
+
+// conversations returns, for each message, the index of the first message of
+// its conversation. The messages must be in date order.
+func conversations(messages []Message) []int {
+
+	byID := map[string]int{}
+	for i, msg := range messages {
+		if msg.ID != "" {
+			byID[msg.ID] = i
+		}
+	}
+
+	parents := make([]int, len(messages))
+	for i, msg := range messages {
+		parents[i] = -1
+
+		// the last reference is the immediate parent, but if it is not in the
+		// archive an earlier one will do.
+		candidates := append([]string{}, msg.References...)
+		candidates = append(candidates, msg.InReplyTo)
+		for j := len(candidates) - 1; j >= 0; j-- {
+			parent, ok := byID[candidates[j]]
+			if ok && parent != i {
+				parents[i] = parent
+				break
+			}
+		}
+	}
+
+	roots := make([]int, len(messages))
+	bySubject := map[string]int{}
+
+	for i, msg := range messages {
+
+		root := i
+		seen := map[int]bool{i: true}
+		for parents[root] >= 0 && !seen[parents[root]] {
+			root = parents[root]
+			seen[root] = true
+		}
+
+		subject := strings.ToLower(baseSubject(msg.Subject))
+		if root == i && isReply(msg.Subject) {
+			if first, ok := bySubject[subject]; ok {
+				root = first
+			}
+		}
+
+		if root == i && subject != "" {
+			if _, ok := bySubject[subject]; !ok {
+				bySubject[subject] = i
+			}
+		}
+
+		roots[i] = root
+	}
+
+	// a reply dated before its parent has the later root: use the root's root.
+	for i := range roots {
+		roots[i] = roots[roots[i]]
+	}
+
+	return roots
+}
+
+// baseSubject strips "Re:" and "[list]" prefixes from a subject.
+func baseSubject(subject string) string {
+
+	for {
+		trimmed := strings.TrimSpace(subject)
+		lower := strings.ToLower(trimmed)
+
+		switch {
+		case strings.HasPrefix(lower, "re:"):
+			trimmed = trimmed[3:]
+		case strings.HasPrefix(lower, "[") && strings.Contains(lower, "]"):
+			trimmed = trimmed[strings.Index(trimmed, "]")+1:]
+		}
+
+		if trimmed == strings.TrimSpace(subject) {
+			return trimmed
+		}
+		subject = trimmed
+	}
+}
+
+func isReply(subject string) bool {
+	return strings.HasPrefix(strings.ToLower(strings.TrimSpace(baseListTag(subject))), "re:")
+}
+
+// baseListTag strips a leading "[list]" tag, which may come before "Re:".
+func baseListTag(subject string) string {
+
+	trimmed := strings.TrimSpace(subject)
+	if strings.HasPrefix(trimmed, "[") && strings.Contains(trimmed, "]") {
+		return trimmed[strings.Index(trimmed, "]")+1:]
+	}
+
+	return trimmed
+}
+
+func truncate(s string, max int) string {
+
+	if utf8.RuneCountInString(s) <= max {
+		return s
+	}
+
+	return string([]rune(s)[:max])
+}
+
+// senders gives each distinct sender address a user ID and a name, unique in
+// the archive.
+type senders struct {
+	ids   map[string]int64
+	names []string
+	taken map[string]bool
+}
+
+func newSenders() *senders {
+	return &senders{
+		ids:   map[string]int64{},
+		taken: map[string]bool{},
+	}
+}
+
+// id returns the user ID of the sender, and true if it is new.
+func (s *senders) id(from *mail.Address) (int64, bool) {
+
+	key := strings.ToLower(from.Address)
+	if key == "" {
+		key = from.Name
+	}
+
+	id, ok := s.ids[key]
+	if ok {
+		return id, false
+	}
+
+	name := truncate(strings.TrimSpace(from.Name), model.MaxNameLength)
+	if name == "" {
+		name = noSender
+	}
+	base := truncate(name, model.MaxNameLength-10)
+	for n := 2; s.taken[name]; n++ {
+		name = fmt.Sprintf("%s (%d)", base, n)
+	}
+
+	s.names = append(s.names, name)
+	s.taken[name] = true
+	id = int64(len(s.names))
+	s.ids[key] = id
+
+	return id, true
+}
@@ -0,0 +1,353 @@
+// Package metrics keeps counters, gauges and histograms, and writes them in the
+// Prometheus text format. A nil *Registry is valid, and records nothing.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pdk/forum/logging"
+)
+
+// DefaultBuckets are the upper bounds of histogram buckets for durations, in
+// seconds.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// The kinds of metric, as named in the text format.
+const (
+	kindCounter   = "counter"
+	kindGauge     = "gauge"
+	kindHistogram = "histogram"
+)
+
+// Registry holds metrics by name.
+type Registry struct {
+	mu       sync.Mutex
+	metrics  map[string]*metric
+	onScrape []func()
+}
+
+// NewRegistry returns an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{metrics: map[string]*metric{}}
+}
+
+// metric is a family of values of one name, one for each set of label
+// values.
+type metric struct {
+	name    string
+	help    string
+	kind    string
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	values map[string]*value // by joined label values
+}
+
+type value struct {
+	labelValues []string
+	number      float64  // counter or gauge
+	counts      []uint64 // histogram, per bucket, not cumulative
+	count       uint64
+	sum         float64
+}
+
+// Counter is a value which only goes up, eg requests served.
+type Counter struct{ m *metric }
+
+// Gauge is a value which goes up and down, eg open connections.
+type Gauge struct{ m *metric }
+
+// Histogram counts observations, eg durations, in buckets.
+type Histogram struct{ m *metric }
+
+// Counter returns the counter of this name, registering it if it is new. The
+// label names must be the same on every call.
+func (r *Registry) Counter(name, help string, labels ...string) *Counter {
+
+	m := r.register(name, help, kindCounter, labels, nil)
+	if m == nil {
+		return nil
+	}
+
+	return &Counter{m}
+}
+
+// Gauge returns the gauge of this name, registering it if it is new.
+func (r *Registry) Gauge(name, help string, labels ...string) *Gauge {
+
+	m := r.register(name, help, kindGauge, labels, nil)
+	if m == nil {
+		return nil
+	}
+
+	return &Gauge{m}
+}
+
+// Histogram returns the histogram of this name, registering it with the given
+// bucket upper bounds if it is new.
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *Histogram {
+
+	m := r.register(name, help, kindHistogram, labels, buckets)
+	if m == nil {
+		return nil
+	}
+
+	return &Histogram{m}
+}
+
+// OnScrape adds a function to run before the metrics are written, eg to set
+// gauges from a source which is cheaper to read only when asked.
+func (r *Registry) OnScrape(fn func()) {
+
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.onScrape = append(r.onScrape, fn)
+}
+
+func (r *Registry) register(name, help, kind string, labels []string, buckets []float64) *metric {
+
+	if r == nil {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	m, ok := r.metrics[name]
+	if ok {
+		if m.kind != kind || len(m.labels) != len(labels) {
+			panic(fmt.Sprintf("metric %s registered again as a different %s", name, kind))
+		}
+		return m
+	}
+
+	m = &metric{
+		name:    name,
+		help:    help,
+		kind:    kind,
+		labels:  labels,
+		buckets: buckets,
+		values:  map[string]*value{},
+	}
+	r.metrics[name] = m
+
+	if len(labels) == 0 {
+		// shown as 0 until something happens.
+		m.with(nil, func(v *value) {})
+	}
+
+	return m
+}
+
+// with runs fn on the value for the label values, under the metric's lock.
+func (m *metric) with(labelValues []string, fn func(v *value)) {
+
+	if len(labelValues) != len(m.labels) {
+		panic(fmt.Sprintf("metric %s needs %d label values, got %d", m.name, len(m.labels), len(labelValues)))
+	}
+
+	key := strings.Join(labelValues, "\x00")
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	v, ok := m.values[key]
+	if !ok {
+		v = &value{labelValues: append([]string(nil), labelValues...)}
+		if m.kind == kindHistogram {
+			v.counts = make([]uint64, len(m.buckets))
+		}
+		m.values[key] = v
+	}
+
+	fn(v)
+}
+
+// Inc adds 1 to the counter for the label values.
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds n, which must not be negative, to the counter.
+func (c *Counter) Add(n float64, labelValues ...string) {
+
+	if c == nil {
+		return
+	}
+
+	c.m.with(labelValues, func(v *value) { v.number += n })
+}
+
+// Set sets the counter, for counts kept elsewhere (eg by database/sql), which
+// are copied in OnScrape.
+func (c *Counter) Set(n float64, labelValues ...string) {
+
+	if c == nil {
+		return
+	}
+
+	c.m.with(labelValues, func(v *value) { v.number = n })
+}
+
+// Set sets the gauge for the label values.
+func (g *Gauge) Set(n float64, labelValues ...string) {
+
+	if g == nil {
+		return
+	}
+
+	g.m.with(labelValues, func(v *value) { v.number = n })
+}
+
+// Observe records one observation, eg a duration in seconds.
+func (h *Histogram) Observe(n float64, labelValues ...string) {
+
+	if h == nil {
+		return
+	}
+
+	h.m.with(labelValues, func(v *value) {
+		for i, upper := range h.m.buckets {
+			if n <= upper {
+				v.counts[i]++
+				break
+			}
+		}
+		v.count++
+		v.sum += n
+	})
+}
+
+// WriteTo writes every metric in the Prometheus text format, sorted by name
+// and then by label values.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+
+	if r == nil {
+		return 0, nil
+	}
+
+	r.mu.Lock()
+	onScrape := append([]func(){}, r.onScrape...)
+	metrics := make([]*metric, 0, len(r.metrics))
+	for _, m := range r.metrics {
+		metrics = append(metrics, m)
+	}
+	r.mu.Unlock()
+
+	for _, fn := range onScrape {
+		fn()
+	}
+
+	sort.Slice(metrics, func(i, j int) bool {
+		return metrics[i].name < metrics[j].name
+	})
+
+	sb := strings.Builder{}
+	for _, m := range metrics {
+		m.write(&sb)
+	}
+
+	n, err := io.WriteString(w, sb.String())
+	return int64(n), err
+}
+
+// ServeHTTP writes the metrics, for Prometheus to scrape.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	_, err := r.WriteTo(w)
+	if err != nil {
+		logging.FromContext(req.Context()).Warn("cannot write metrics", "error", err)
+	}
+}
+
+func (m *metric) write(sb *strings.Builder) {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintf(sb, "# HELP %s %s\n", m.name, escapeHelp(m.help))
+	fmt.Fprintf(sb, "# TYPE %s %s\n", m.name, m.kind)
+
+	keys := make([]string, 0, len(m.values))
+	for key := range m.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		v := m.values[key]
+
+		if m.kind != kindHistogram {
+			fmt.Fprintf(sb, "%s%s %s\n", m.name, m.labelSet(v.labelValues, ""), formatFloat(v.number))
+			continue
+		}
+
+		cumulative := uint64(0)
+		for i, upper := range m.buckets {
+			cumulative += v.counts[i]
+			fmt.Fprintf(sb, "%s_bucket%s %d\n", m.name, m.labelSet(v.labelValues, formatFloat(upper)), cumulative)
+		}
+		fmt.Fprintf(sb, "%s_bucket%s %d\n", m.name, m.labelSet(v.labelValues, "+Inf"), v.count)
+		fmt.Fprintf(sb, "%s_sum%s %s\n", m.name, m.labelSet(v.labelValues, ""), formatFloat(v.sum))
+		fmt.Fprintf(sb, "%s_count%s %d\n", m.name, m.labelSet(v.labelValues, ""), v.count)
+	}
+}
+
+// labelSet formats the labels, eg {route="/topics",le="0.5"}, with le only
+// if it is not blank.
+func (m *metric) labelSet(labelValues []string, le string) string {
+
+	pairs := []string{}
+	for i, label := range m.labels {
+		pairs = append(pairs, label+`="`+escapeLabel(labelValues[i])+`"`)
+	}
+	if le != "" {
+		pairs = append(pairs, `le="`+le+`"`)
+	}
+
+	if len(pairs) == 0 {
+		return ""
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(n float64) string {
+
+	switch {
+	case math.IsInf(n, 1):
+		return "+Inf"
+	case math.IsInf(n, -1):
+		return "-Inf"
+	}
+
+	return strconv.FormatFloat(n, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
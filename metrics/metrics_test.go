@@ -0,0 +1,61 @@
+package metrics_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pdk/forum/metrics"
+)
+
+func TestWriteTo(t *testing.T) {
+
+	r := metrics.NewRegistry()
+
+	requests := r.Counter("requests_total", "Requests.", "route", "code")
+	requests.Inc("/topics", "200")
+	requests.Inc("/topics", "200")
+	requests.Inc(`/a"b`, "404")
+
+	r.Gauge("up", "Whether it is up.").Set(1)
+
+	durations := r.Histogram("duration_seconds", "Durations.", []float64{0.1, 1}, "route")
+	durations.Observe(0.05, "/topics")
+	durations.Observe(0.5, "/topics")
+	durations.Observe(5, "/topics")
+
+	sb := strings.Builder{}
+	r.WriteTo(&sb)
+
+	expected := `# HELP duration_seconds Durations.
+# TYPE duration_seconds histogram
+duration_seconds_bucket{route="/topics",le="0.1"} 1
+duration_seconds_bucket{route="/topics",le="1"} 2
+duration_seconds_bucket{route="/topics",le="+Inf"} 3
+duration_seconds_sum{route="/topics"} 5.55
+duration_seconds_count{route="/topics"} 3
+# HELP requests_total Requests.
+# TYPE requests_total counter
+requests_total{route="/a\"b",code="404"} 1
+requests_total{route="/topics",code="200"} 2
+# HELP up Whether it is up.
+# TYPE up gauge
+up 1
+`
+	if sb.String() != expected {
+		t.Errorf("expected\n%s\nbut got\n%s", expected, sb.String())
+	}
+}
+
+func TestNilRegistry(t *testing.T) {
+
+	var r *metrics.Registry
+
+	r.Counter("requests_total", "Requests.").Inc()
+	r.Histogram("duration_seconds", "Durations.", metrics.DefaultBuckets).Observe(1)
+
+	sb := strings.Builder{}
+	r.WriteTo(&sb)
+	if sb.String() != "" {
+		t.Errorf("expected nothing from a nil registry, but got %s", sb.String())
+	}
+}
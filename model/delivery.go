@@ -0,0 +1,41 @@
+package model
+
+import "time"
+
+// Delivery statuses.
+const (
+	DeliveryPending   = "pending"
+	DeliverySending   = "sending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// Delivery is one attempt (with retries) to send an event to a webhook.
+type Delivery struct {
+	ID           int64     `json:"id"`
+	Event        string    `json:"event"`
+	Hook         int       `json:"hook"` // index of the webhook in the configuration
+	URL          string    `json:"url"`
+	Payload      string    `json:"payload"`
+	Status       string    `json:"status"`
+	Attempts     int       `json:"attempts"`
+	ResponseCode int       `json:"response_code"`
+	LastError    string    `json:"last_error"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// NewDelivery returns a new pending Delivery. hook is the index of the webhook
+// in the configuration, or -1 if unknown.
+func NewDelivery(event string, hook int, url, payload string) Delivery {
+	now := time.Now()
+	return Delivery{
+		Event:     event,
+		Hook:      hook,
+		URL:       url,
+		Payload:   payload,
+		Status:    DeliveryPending,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+}
@@ -6,11 +6,11 @@ import (
 
 // Post is a single post by a user
 type Post struct {
-	ID         int64
-	ThreadID   int64
-	PostedByID int64
-	PostedAt   time.Time
-	Body       string
+	ID         int64     `json:"id"`
+	ThreadID   int64     `json:"thread_id"`
+	PostedByID int64     `json:"posted_by_id"`
+	PostedAt   time.Time `json:"posted_at"`
+	Body       string    `json:"body"`
 }
 
 // NewPost initializes a new Post
@@ -2,10 +2,11 @@ package model
 
 // Thread is a chain of posts with a single subject.
 type Thread struct {
-	ID          int64
-	TopicID     int64
-	CreatedByID int64
-	Subject     string
+	ID          int64  `json:"id"`
+	TopicID     int64  `json:"topic_id"`
+	CreatedByID int64  `json:"created_by_id"`
+	Subject     string `json:"subject"`
+	Locked      bool   `json:"locked"`
 }
 
 // NewThread returns a new Thread.
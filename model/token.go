@@ -0,0 +1,76 @@
+package model
+
+import (
+	"strings"
+	"time"
+)
+
+// Token scopes. A token with the write scope may also read.
+const (
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+)
+
+// APIToken lets scripts and bots act as a user. Only a hash of the token
+// itself is kept.
+type APIToken struct {
+	ID         int64     `json:"id"`
+	UserID     int64     `json:"user_id"`
+	Name       string    `json:"name"`
+	Hash       string    `json:"-"`
+	Scopes     string    `json:"scopes"`
+	CreatedAt  time.Time `json:"created_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+}
+
+// NewAPIToken returns a new APIToken. A zero expiresAt means the token does not
+// expire.
+func NewAPIToken(userID int64, name, hash string, scopes []string, expiresAt time.Time) APIToken {
+	return APIToken{
+		UserID:    userID,
+		Name:      name,
+		Hash:      hash,
+		Scopes:    strings.Join(scopes, " "),
+		CreatedAt: time.Now(),
+		ExpiresAt: expiresAt,
+	}
+}
+
+// HasScope returns true if the token grants the scope.
+func (t APIToken) HasScope(scope string) bool {
+
+	for _, s := range strings.Fields(t.Scopes) {
+		if s == scope || (s == ScopeWrite && scope == ScopeRead) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Expired returns true if the token has an expiry, and it has passed.
+func (t APIToken) Expired(now time.Time) bool {
+	return !t.ExpiresAt.IsZero() && now.After(t.ExpiresAt)
+}
+
+// Validate checks that the APIToken may be saved.
+func (t APIToken) Validate() error {
+
+	switch {
+	case blank(t.Name):
+		return invalid("name", "token name must not be blank")
+	case tooLong(t.Name, MaxNameLength):
+		return invalid("name", "token name must be at most %d characters", MaxNameLength)
+	case t.Scopes == "":
+		return invalid("scopes", "token must have at least one scope")
+	}
+
+	for _, s := range strings.Fields(t.Scopes) {
+		if s != ScopeRead && s != ScopeWrite {
+			return invalid("scopes", "unknown scope %s", s)
+		}
+	}
+
+	return nil
+}
@@ -2,9 +2,9 @@ package model
 
 // Topic is an area of discussion.
 type Topic struct {
-	ID          int64
-	CreatedByID int64
-	Name        string
+	ID          int64  `json:"id"`
+	CreatedByID int64  `json:"created_by_id"`
+	Name        string `json:"name"`
 }
 
 // NewTopic makes a new Topic.
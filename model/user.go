@@ -2,11 +2,24 @@ package model
 
 import "time"
 
+// Roles a user may have. Moderators may edit, move, lock and delete other
+// users' content. Admins may also manage users.
+const (
+	RoleMember    = "member"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+// Roles lists the valid user roles.
+var Roles = []string{RoleMember, RoleModerator, RoleAdmin}
+
 // User is a human who uses this service.
 type User struct {
-	ID       int64
-	JoinedAt time.Time
-	Name     string
+	ID       int64     `json:"id"`
+	JoinedAt time.Time `json:"joined_at"`
+	Name     string    `json:"name"`
+	Role     string    `json:"role"`
+	Banned   bool      `json:"banned"`
 }
 
 // NewUser returns a new User.
@@ -14,5 +27,11 @@ func NewUser(name string) User {
 	return User{
 		JoinedAt: time.Now(),
 		Name:     name,
+		Role:     RoleMember,
 	}
 }
+
+// IsModerator returns true if the user may manage other users' content.
+func (u User) IsModerator() bool {
+	return u.Role == RoleModerator || u.Role == RoleAdmin
+}
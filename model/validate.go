@@ -0,0 +1,105 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Limits on the sizes of user supplied text.
+const (
+	MaxNameLength    = 80
+	MaxSubjectLength = 200
+	MaxBodyLength    = 50000
+)
+
+// ValidationError describes a problem with user supplied data, which the user
+// needs to correct.
+type ValidationError struct {
+	Field   string
+	Message string
+}
+
+func (e ValidationError) Error() string {
+	return e.Message
+}
+
+func invalid(field, message string, args ...interface{}) error {
+	return ValidationError{
+		Field:   field,
+		Message: fmt.Sprintf(message, args...),
+	}
+}
+
+func blank(s string) bool {
+	return strings.TrimSpace(s) == ""
+}
+
+func tooLong(s string, max int) bool {
+	return utf8.RuneCountInString(s) > max
+}
+
+// Validate checks that the User may be saved.
+func (u User) Validate() error {
+
+	switch {
+	case blank(u.Name):
+		return invalid("name", "name must not be blank")
+	case tooLong(u.Name, MaxNameLength):
+		return invalid("name", "name must be at most %d characters", MaxNameLength)
+	case !validRole(u.Role):
+		return invalid("role", "role must be one of %s", strings.Join(Roles, ", "))
+	}
+
+	return nil
+}
+
+func validRole(role string) bool {
+
+	for _, r := range Roles {
+		if r == role {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Validate checks that the Topic may be saved.
+func (t Topic) Validate() error {
+
+	switch {
+	case blank(t.Name):
+		return invalid("name", "new topic name must not be blank")
+	case tooLong(t.Name, MaxNameLength):
+		return invalid("name", "topic name must be at most %d characters", MaxNameLength)
+	}
+
+	return nil
+}
+
+// Validate checks that the Thread may be saved.
+func (t Thread) Validate() error {
+
+	switch {
+	case blank(t.Subject):
+		return invalid("subject", "thread subject must not be blank")
+	case tooLong(t.Subject, MaxSubjectLength):
+		return invalid("subject", "thread subject must be at most %d characters", MaxSubjectLength)
+	}
+
+	return nil
+}
+
+// Validate checks that the Post may be saved.
+func (p Post) Validate() error {
+
+	switch {
+	case blank(p.Body):
+		return invalid("body", "Cannot post with blank comment.")
+	case tooLong(p.Body, MaxBodyLength):
+		return invalid("body", "comments must be at most %d characters", MaxBodyLength)
+	}
+
+	return nil
+}
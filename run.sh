@@ -1,2 +1 @@
-
-CompileDaemon -exclude-dir=.git -exclude=".#*" -include="*.html" -include="*.css" -build="go build cmd/forum.go" -command="forum ./conf.json"
\ No newline at end of file
+CompileDaemon -exclude-dir=.git -exclude=".#*" -include="*.html" -include="*.css" -include="*.sql" -build="go build -o forum ./cmd" -command="./forum -config ./conf.json serve"
\ No newline at end of file
@@ -0,0 +1,85 @@
+package srv
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/pdk/forum/hook"
+)
+
+// OnlyAdmin will respond 403 Forbidden if the current user is not an admin.
+func (s Server) OnlyAdmin(handler http.HandlerFunc) http.HandlerFunc {
+	return s.OnlySignedIn(func(w http.ResponseWriter, r *http.Request) {
+
+		user, err := CurrentUser(s.Store, r)
+		if handleError(w, r, "cannot get current user: %w", err) {
+			return
+		}
+
+		if !s.IsAdmin(user) {
+			http.Error(w, "admins only", http.StatusForbidden)
+			return
+		}
+
+		handler(w, r)
+	})
+}
+
+// WebhooksPage shows the recent webhook deliveries.
+func (s Server) WebhooksPage(w http.ResponseWriter, r *http.Request) {
+
+	deliveries, err := s.Store.QueryRecentDeliveries(r.Context(), 100)
+	if handleError(w, r, "cannot get webhook deliveries: %w", err) {
+		return
+	}
+
+	s.WritePage(w, r, "admin-webhooks.html", map[string]interface{}{
+		"enabled":    s.Hooks != nil,
+		"deliveries": deliveries,
+	})
+}
+
+// CachePage shows how well the store's cache is doing.
+func (s Server) CachePage(w http.ResponseWriter, r *http.Request) {
+
+	data := map[string]interface{}{
+		"enabled": s.Cache != nil,
+	}
+
+	if s.Cache != nil {
+		stats := s.Cache.Stats()
+		data["stats"] = stats
+
+		if lookups := stats.Hits + stats.Misses; lookups > 0 {
+			data["hitRate"] = fmt.Sprintf("%.1f%%", float64(stats.Hits)*100/float64(lookups))
+		}
+	}
+
+	s.WritePage(w, r, "admin-cache.html", data)
+}
+
+// ReplayDelivery queues a webhook delivery to be sent again.
+func (s Server) ReplayDelivery(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	deliveryIDString := r.FormValue("deliveryID")
+	deliveryID, err := strconv.ParseInt(deliveryIDString, 10, 64)
+	if handleError(w, r, "cannot parse delivery id %s: %w", deliveryIDString, err) {
+		return
+	}
+
+	err = s.Hooks.Replay(r.Context(), deliveryID)
+	if errorNotFound(w, r, err) ||
+		s.MaybeUserError(w, r, errors.Is(err, hook.ErrDeliveryQueued), "delivery %d is still queued, so cannot be replayed yet", deliveryID) ||
+		handleError(w, r, "cannot replay delivery %d: %w", deliveryID, err) {
+		return
+	}
+
+	http.Redirect(w, r, "/admin/webhooks", http.StatusSeeOther)
+}
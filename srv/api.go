@@ -0,0 +1,538 @@
+package srv
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/pdk/forum/action"
+	"github.com/pdk/forum/logging"
+	"github.com/pdk/forum/model"
+	"github.com/pdk/forum/store"
+)
+
+// APIPrefix is the path under which the JSON API is served.
+const APIPrefix = "/api/v1/"
+
+// Pagination limits for API list requests.
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 200
+)
+
+// API error codes. Clients may depend on these, so they must not change.
+const (
+	CodeBadRequest       = "bad_request"
+	CodeInvalid          = "invalid"
+	CodeUnauthorized     = "unauthorized"
+	CodeForbidden        = "forbidden"
+	CodeNotFound         = "not_found"
+	CodeMethodNotAllowed = "method_not_allowed"
+	CodeUnsupportedType  = "unsupported_media_type"
+	CodeConflict         = "conflict"
+	CodeInternal         = "internal"
+	CodeTimeout          = "timeout"
+)
+
+// APIError is the body of every unsuccessful API response.
+type APIError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Field   string `json:"field,omitempty"`
+
+	// RequestID identifies the request in the server's log, for internal
+	// errors.
+	RequestID string `json:"request_id,omitempty"`
+}
+
+type apiErrorBody struct {
+	Error APIError `json:"error"`
+}
+
+// apiList is the body of API list responses. NextOffset is null when there
+// are no more items.
+type apiList struct {
+	Items      interface{} `json:"items"`
+	Limit      int         `json:"limit"`
+	Offset     int         `json:"offset"`
+	NextOffset *int        `json:"next_offset"`
+}
+
+// badRequest is an error in the request itself, eg a malformed ID or body.
+type badRequest struct {
+	message string
+}
+
+func (e badRequest) Error() string {
+	return e.message
+}
+
+func badRequestf(message string, args ...interface{}) error {
+	return badRequest{message: fmt.Sprintf(message, args...)}
+}
+
+// errNotJSON is returned for a request body which is not labelled as JSON.
+// A form or text/plain body may be sent cross site, with the user's cookie,
+// so only application/json (which needs CORS) is accepted.
+var errNotJSON = errors.New("request body must be application/json")
+
+// API routes requests under /api/v1/ to the resource handlers.
+func (s Server) API(w http.ResponseWriter, r *http.Request) {
+
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, APIPrefix), "/")
+	parts := strings.Split(path, "/")
+
+	resource := parts[0]
+	var id int64
+	if len(parts) > 1 && !(resource == "users" && parts[1] == "me") {
+		var err error
+		id, err = strconv.ParseInt(parts[1], 10, 64)
+		if err != nil {
+			writeAPIError(w, r, http.StatusNotFound, APIError{Code: CodeNotFound, Message: "no such resource"})
+			return
+		}
+	}
+
+	if resource == "users" && len(parts) == 1 && r.Method == http.MethodPost {
+		// creating a user is the API version of signing in, so is open to all.
+		s.apiCreateUser(w, r)
+		return
+	}
+
+	user, err := CurrentUser(s.Store, r)
+	if apiFailed(w, r, err) {
+		return
+	}
+
+	type route struct {
+		resource string
+		parts    int
+		sub      string
+	}
+
+	handlers := map[route]map[string]func(http.ResponseWriter, *http.Request, model.User, int64){
+		{"topics", 1, ""}:        {http.MethodGet: s.apiListTopics, http.MethodPost: s.apiCreateTopic},
+		{"topics", 2, ""}:        {http.MethodGet: s.apiGetTopic, http.MethodPatch: s.apiEditTopic},
+		{"topics", 3, "threads"}: {http.MethodGet: s.apiListThreads},
+		{"threads", 1, ""}:       {http.MethodPost: s.apiCreateThread},
+		{"threads", 2, ""}:       {http.MethodGet: s.apiGetThread, http.MethodPatch: s.apiEditThread},
+		{"threads", 3, "posts"}:  {http.MethodGet: s.apiListPosts},
+		{"posts", 1, ""}:         {http.MethodPost: s.apiCreatePost},
+		{"posts", 2, ""}:         {http.MethodGet: s.apiGetPost, http.MethodPatch: s.apiEditPost},
+		{"users", 1, ""}:         {http.MethodGet: s.apiListUsers},
+		{"users", 2, ""}:         {http.MethodGet: s.apiGetUser, http.MethodPatch: s.apiEditUser},
+	}
+
+	key := route{resource: resource, parts: len(parts)}
+	if len(parts) == 3 {
+		key.sub = parts[2]
+	}
+
+	methods, ok := handlers[key]
+	if !ok {
+		writeAPIError(w, r, http.StatusNotFound, APIError{Code: CodeNotFound, Message: "no such resource"})
+		return
+	}
+
+	handler, ok := methods[r.Method]
+	if !ok {
+		writeAPIError(w, r, http.StatusMethodNotAllowed, APIError{Code: CodeMethodNotAllowed, Message: r.Method + " is not allowed here"})
+		return
+	}
+
+	if resource == "users" && len(parts) == 2 && parts[1] == "me" {
+		id = user.ID
+	}
+
+	handler(w, r, user, id)
+}
+
+func (s Server) apiListTopics(w http.ResponseWriter, r *http.Request, user model.User, id int64) {
+
+	page, err := pageParams(r)
+	if apiFailed(w, r, err) {
+		return
+	}
+
+	topics, err := s.Store.ListTopics(r.Context(), page)
+	if apiFailed(w, r, err) {
+		return
+	}
+
+	writeAPIList(w, r, topics, len(topics), page)
+}
+
+func (s Server) apiGetTopic(w http.ResponseWriter, r *http.Request, user model.User, id int64) {
+
+	topic, err := s.Store.GetTopicByID(r.Context(), id)
+	if apiFailed(w, r, err) {
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, topic)
+}
+
+func (s Server) apiCreateTopic(w http.ResponseWriter, r *http.Request, user model.User, id int64) {
+
+	var req struct {
+		Name string `json:"name"`
+	}
+	if apiFailed(w, r, decodeJSON(r, &req)) {
+		return
+	}
+
+	topic, err := s.CreateTopic(r.Context(), user, req.Name)
+	if apiFailed(w, r, err) {
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("%stopics/%d", APIPrefix, topic.ID))
+	writeJSON(w, r, http.StatusCreated, topic)
+}
+
+func (s Server) apiEditTopic(w http.ResponseWriter, r *http.Request, user model.User, id int64) {
+
+	var req struct {
+		Name string `json:"name"`
+	}
+	if apiFailed(w, r, decodeJSON(r, &req)) {
+		return
+	}
+
+	topic, err := s.RenameTopic(r.Context(), user, id, req.Name)
+	if apiFailed(w, r, err) {
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, topic)
+}
+
+func (s Server) apiListThreads(w http.ResponseWriter, r *http.Request, user model.User, id int64) {
+
+	page, err := pageParams(r)
+	if apiFailed(w, r, err) {
+		return
+	}
+
+	topic, err := s.Store.GetTopicByID(r.Context(), id)
+	if apiFailed(w, r, err) {
+		return
+	}
+
+	threads, err := s.Store.ListThreadsByTopicID(r.Context(), topic.ID, page)
+	if apiFailed(w, r, err) {
+		return
+	}
+
+	writeAPIList(w, r, threads, len(threads), page)
+}
+
+func (s Server) apiGetThread(w http.ResponseWriter, r *http.Request, user model.User, id int64) {
+
+	thread, err := s.Store.GetThreadByID(r.Context(), id)
+	if apiFailed(w, r, err) {
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, thread)
+}
+
+func (s Server) apiCreateThread(w http.ResponseWriter, r *http.Request, user model.User, id int64) {
+
+	var req struct {
+		TopicID int64  `json:"topic_id"`
+		Subject string `json:"subject"`
+		Body    string `json:"body"`
+	}
+	if apiFailed(w, r, decodeJSON(r, &req)) {
+		return
+	}
+
+	thread, post, err := s.CreateThread(r.Context(), user, req.TopicID, req.Subject, req.Body)
+	if apiFailed(w, r, err) {
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("%sthreads/%d", APIPrefix, thread.ID))
+	writeJSON(w, r, http.StatusCreated, map[string]interface{}{
+		"thread": thread,
+		"post":   post,
+	})
+}
+
+func (s Server) apiEditThread(w http.ResponseWriter, r *http.Request, user model.User, id int64) {
+
+	var req struct {
+		Subject string `json:"subject"`
+	}
+	if apiFailed(w, r, decodeJSON(r, &req)) {
+		return
+	}
+
+	thread, err := s.EditThread(r.Context(), user, id, req.Subject)
+	if apiFailed(w, r, err) {
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, thread)
+}
+
+func (s Server) apiListPosts(w http.ResponseWriter, r *http.Request, user model.User, id int64) {
+
+	page, err := pageParams(r)
+	if apiFailed(w, r, err) {
+		return
+	}
+
+	thread, err := s.Store.GetThreadByID(r.Context(), id)
+	if apiFailed(w, r, err) {
+		return
+	}
+
+	posts, err := s.Store.ListPostsByThreadID(r.Context(), thread.ID, page)
+	if apiFailed(w, r, err) {
+		return
+	}
+
+	writeAPIList(w, r, posts, len(posts), page)
+}
+
+func (s Server) apiGetPost(w http.ResponseWriter, r *http.Request, user model.User, id int64) {
+
+	post, err := s.Store.GetPostByID(r.Context(), id)
+	if apiFailed(w, r, err) {
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, post)
+}
+
+func (s Server) apiCreatePost(w http.ResponseWriter, r *http.Request, user model.User, id int64) {
+
+	var req struct {
+		ThreadID int64  `json:"thread_id"`
+		Body     string `json:"body"`
+	}
+	if apiFailed(w, r, decodeJSON(r, &req)) {
+		return
+	}
+
+	_, post, err := s.CreatePost(r.Context(), user, req.ThreadID, req.Body)
+	if apiFailed(w, r, err) {
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("%sposts/%d", APIPrefix, post.ID))
+	writeJSON(w, r, http.StatusCreated, post)
+}
+
+func (s Server) apiEditPost(w http.ResponseWriter, r *http.Request, user model.User, id int64) {
+
+	var req struct {
+		Body string `json:"body"`
+	}
+	if apiFailed(w, r, decodeJSON(r, &req)) {
+		return
+	}
+
+	post, err := s.EditPost(r.Context(), user, id, req.Body)
+	if apiFailed(w, r, err) {
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, post)
+}
+
+func (s Server) apiListUsers(w http.ResponseWriter, r *http.Request, user model.User, id int64) {
+
+	page, err := pageParams(r)
+	if apiFailed(w, r, err) {
+		return
+	}
+
+	users, err := s.Store.ListUsers(r.Context(), page)
+	if apiFailed(w, r, err) {
+		return
+	}
+
+	writeAPIList(w, r, users, len(users), page)
+}
+
+func (s Server) apiGetUser(w http.ResponseWriter, r *http.Request, user model.User, id int64) {
+
+	found, err := s.Store.GetUserByID(r.Context(), id)
+	if apiFailed(w, r, err) {
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, found)
+}
+
+func (s Server) apiCreateUser(w http.ResponseWriter, r *http.Request) {
+
+	var req struct {
+		Name string `json:"name"`
+	}
+	if apiFailed(w, r, decodeJSON(r, &req)) {
+		return
+	}
+
+	user, err := s.CreateUser(r.Context(), req.Name)
+	if apiFailed(w, r, err) {
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("%susers/%d", APIPrefix, user.ID))
+	writeJSON(w, r, http.StatusCreated, user)
+}
+
+func (s Server) apiEditUser(w http.ResponseWriter, r *http.Request, user model.User, id int64) {
+
+	var req struct {
+		Name string `json:"name"`
+	}
+	if apiFailed(w, r, decodeJSON(r, &req)) {
+		return
+	}
+
+	renamed, err := s.RenameUser(r.Context(), user, id, req.Name)
+	if apiFailed(w, r, err) {
+		return
+	}
+
+	if renamed.ID == user.ID {
+		// keep the cookie pointing at the (renamed) current user.
+		setSignedInUserName(w, renamed.Name)
+	}
+
+	writeJSON(w, r, http.StatusOK, renamed)
+}
+
+// pageParams reads the limit and offset query parameters.
+func pageParams(r *http.Request) (store.Page, error) {
+
+	page := store.Page{Limit: defaultPageLimit}
+
+	var err error
+	if v := r.URL.Query().Get("limit"); v != "" {
+		page.Limit, err = strconv.Atoi(v)
+		if err != nil || page.Limit < 1 || page.Limit > maxPageLimit {
+			return page, badRequestf("limit must be between 1 and %d", maxPageLimit)
+		}
+	}
+
+	if v := r.URL.Query().Get("offset"); v != "" {
+		page.Offset, err = strconv.Atoi(v)
+		if err != nil || page.Offset < 0 {
+			return page, badRequestf("offset must be a non-negative integer")
+		}
+	}
+
+	return page, nil
+}
+
+// decodeJSON reads the request body as JSON into v. The Content-Type must be
+// application/json (see errNotJSON).
+func decodeJSON(r *http.Request, v interface{}) error {
+
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "application/json" {
+		return errNotJSON
+	}
+
+	decoder := json.NewDecoder(http.MaxBytesReader(nil, r.Body, 1<<20))
+	decoder.DisallowUnknownFields()
+
+	err = decoder.Decode(v)
+	if err != nil {
+		return badRequestf("cannot parse request body: %s", err)
+	}
+
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, r *http.Request, status int, v interface{}) {
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("cannot write JSON response", "error", err)
+	}
+}
+
+func writeAPIList(w http.ResponseWriter, r *http.Request, items interface{}, count int, page store.Page) {
+
+	list := apiList{
+		Items:  items,
+		Limit:  page.Limit,
+		Offset: page.Offset,
+	}
+
+	if count == page.Limit {
+		next := page.Offset + page.Limit
+		list.NextOffset = &next
+	}
+
+	writeJSON(w, r, http.StatusOK, list)
+}
+
+func writeAPIError(w http.ResponseWriter, r *http.Request, status int, apiErr APIError) {
+	writeJSON(w, r, status, apiErrorBody{Error: apiErr})
+}
+
+// apiFailed writes a JSON error response appropriate to the error. Returns
+// true if there was an error, and the handler should stop.
+func apiFailed(w http.ResponseWriter, r *http.Request, err error) bool {
+
+	if err == nil {
+		return false
+	}
+
+	var validationErr model.ValidationError
+	var badReq badRequest
+
+	switch {
+	case errors.As(err, &validationErr):
+		writeAPIError(w, r, http.StatusUnprocessableEntity, APIError{Code: CodeInvalid, Message: validationErr.Message, Field: validationErr.Field})
+	case errors.As(err, &badReq):
+		writeAPIError(w, r, http.StatusBadRequest, APIError{Code: CodeBadRequest, Message: badReq.message})
+	case errors.Is(err, errNotJSON):
+		writeAPIError(w, r, http.StatusUnsupportedMediaType, APIError{Code: CodeUnsupportedType, Message: err.Error()})
+	case errors.Is(err, ErrInvalidToken):
+		w.Header().Set("WWW-Authenticate", `Bearer realm="forum", error="invalid_token"`)
+		writeAPIError(w, r, http.StatusUnauthorized, APIError{Code: CodeUnauthorized, Message: "invalid or expired token"})
+	case errors.Is(err, ErrNotSignedIn):
+		writeAPIError(w, r, http.StatusUnauthorized, APIError{Code: CodeUnauthorized, Message: "not signed in"})
+	case errors.Is(err, ErrInsufficientScope):
+		writeAPIError(w, r, http.StatusForbidden, APIError{Code: CodeForbidden, Message: "token lacks the required scope"})
+	case errors.Is(err, action.ErrBanned):
+		writeAPIError(w, r, http.StatusForbidden, APIError{Code: CodeForbidden, Message: "user is banned"})
+	case errors.Is(err, action.ErrThreadLocked):
+		writeAPIError(w, r, http.StatusForbidden, APIError{Code: CodeForbidden, Message: "thread is locked"})
+	case errors.Is(err, action.ErrForbidden):
+		writeAPIError(w, r, http.StatusForbidden, APIError{Code: CodeForbidden, Message: "not permitted"})
+	case errors.Is(err, sql.ErrNoRows):
+		writeAPIError(w, r, http.StatusNotFound, APIError{Code: CodeNotFound, Message: "not found"})
+	case store.IsDuplicate(err):
+		writeAPIError(w, r, http.StatusConflict, APIError{Code: CodeConflict, Message: "already exists"})
+	case errors.Is(err, context.DeadlineExceeded):
+		logging.FromContext(r.Context()).Warn("request timed out", "error", err)
+		writeAPIError(w, r, http.StatusServiceUnavailable, APIError{Code: CodeTimeout, Message: "request timed out"})
+	case errors.Is(err, context.Canceled):
+		// the client has gone away, so there is no one to tell.
+		logging.FromContext(r.Context()).Info("request cancelled", "error", err)
+	default:
+		logging.FromContext(r.Context()).Error("request failed", "error", err)
+		writeAPIError(w, r, http.StatusInternalServerError, APIError{Code: CodeInternal, Message: "internal error", RequestID: RequestID(r.Context())})
+	}
+
+	return true
+}
@@ -0,0 +1,134 @@
+package srv_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// apiCall sends a request to the API, with body as JSON if it is not blank,
+// and returns the status and the decoded response.
+func apiCall(t *testing.T, client *http.Client, method, url, contentType, body string) (int, map[string]interface{}) {
+
+	req, _ := http.NewRequest(method, url, strings.NewReader(body))
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("expected to %s %s, but failed: %v", method, url, err)
+	}
+	defer resp.Body.Close()
+
+	content, _ := ioutil.ReadAll(resp.Body)
+
+	decoded := map[string]interface{}{}
+	err = json.Unmarshal(content, &decoded)
+	if err != nil {
+		t.Fatalf("expected JSON from %s %s, but got %d %s", method, url, resp.StatusCode, content)
+	}
+
+	return resp.StatusCode, decoded
+}
+
+// errorCode returns the code of an API error response, or "".
+func errorCode(response map[string]interface{}) string {
+
+	apiErr, _ := response["error"].(map[string]interface{})
+	code, _ := apiErr["code"].(string)
+
+	return code
+}
+
+func TestAPICreateAndList(t *testing.T) {
+
+	ts, client := newTestServer(t)
+	api := ts.URL + "/api/v1"
+
+	post(t, client, ts.URL+"/sign-in", url.Values{"name": {"pdk"}})
+
+	status, topic := apiCall(t, client, http.MethodPost, api+"/topics", "application/json", `{"name":"golang"}`)
+	if status != http.StatusCreated || topic["name"] != "golang" {
+		t.Fatalf("expected to create a topic, but got %d %v", status, topic)
+	}
+
+	status, created := apiCall(t, client, http.MethodPost, api+"/threads", "application/json; charset=utf-8",
+		`{"topic_id":1,"subject":"hello","body":"first post"}`)
+	if status != http.StatusCreated || created["thread"] == nil || created["post"] == nil {
+		t.Fatalf("expected to create a thread, but got %d %v", status, created)
+	}
+
+	for _, body := range []string{"second post", "third post"} {
+		status, created = apiCall(t, client, http.MethodPost, api+"/posts", "application/json", `{"thread_id":1,"body":"`+body+`"}`)
+		if status != http.StatusCreated || created["body"] != body {
+			t.Fatalf("expected to create a post, but got %d %v", status, created)
+		}
+	}
+
+	status, list := apiCall(t, client, http.MethodGet, api+"/threads/1/posts?limit=2", "", "")
+	items, _ := list["items"].([]interface{})
+	if status != http.StatusOK || len(items) != 2 || list["next_offset"] != 2.0 {
+		t.Fatalf("expected the first page of posts, but got %d %v", status, list)
+	}
+
+	status, list = apiCall(t, client, http.MethodGet, api+"/threads/1/posts?limit=2&offset=2", "", "")
+	items, _ = list["items"].([]interface{})
+	if status != http.StatusOK || len(items) != 1 || list["next_offset"] != nil {
+		t.Fatalf("expected the last page of posts, but got %d %v", status, list)
+	}
+	if last, _ := items[0].(map[string]interface{}); last["body"] != "third post" {
+		t.Errorf("expected the third post last, but got %v", items[0])
+	}
+}
+
+func TestAPIErrors(t *testing.T) {
+
+	ts, client := newTestServer(t)
+	api := ts.URL + "/api/v1"
+
+	status, response := apiCall(t, http.DefaultClient, http.MethodGet, api+"/topics", "", "")
+	if status != http.StatusUnauthorized || errorCode(response) != "unauthorized" {
+		t.Errorf("expected unauthorized without a cookie, but got %d %v", status, response)
+	}
+
+	post(t, client, ts.URL+"/sign-in", url.Values{"name": {"pdk"}})
+
+	for _, test := range []struct {
+		method, path, contentType, body string
+		status                          int
+		code                            string
+	}{
+		{http.MethodPost, "/topics", "text/plain", `{"name":"golang"}`, http.StatusUnsupportedMediaType, "unsupported_media_type"},
+		{http.MethodPost, "/topics", "application/x-www-form-urlencoded", `{"name":"golang"}`, http.StatusUnsupportedMediaType, "unsupported_media_type"},
+		{http.MethodPost, "/topics", "", `{"name":"golang"}`, http.StatusUnsupportedMediaType, "unsupported_media_type"},
+		{http.MethodPost, "/users", "text/plain", `{"name":"mallory"}`, http.StatusUnsupportedMediaType, "unsupported_media_type"},
+		{http.MethodPost, "/topics", "application/json", `{"name":`, http.StatusBadRequest, "bad_request"},
+		{http.MethodPost, "/topics", "application/json", `{"title":"golang"}`, http.StatusBadRequest, "bad_request"},
+		{http.MethodPost, "/topics", "application/json", `{"name":"  "}`, http.StatusUnprocessableEntity, "invalid"},
+		{http.MethodGet, "/topics?limit=0", "", "", http.StatusBadRequest, "bad_request"},
+		{http.MethodGet, "/topics/99", "", "", http.StatusNotFound, "not_found"},
+		{http.MethodGet, "/topics/abc", "", "", http.StatusNotFound, "not_found"},
+		{http.MethodGet, "/widgets", "", "", http.StatusNotFound, "not_found"},
+		{http.MethodDelete, "/topics/1", "", "", http.StatusMethodNotAllowed, "method_not_allowed"},
+	} {
+		status, response := apiCall(t, client, test.method, api+test.path, test.contentType, test.body)
+		if status != test.status || errorCode(response) != test.code {
+			t.Errorf("expected %s %s (%s) to fail %d %s, but got %d %v",
+				test.method, test.path, test.contentType, test.status, test.code, status, response)
+		}
+	}
+
+	status, _ = apiCall(t, client, http.MethodPost, api+"/topics", "application/json", `{"name":"golang"}`)
+	if status != http.StatusCreated {
+		t.Fatalf("expected to create a topic, but got %d", status)
+	}
+
+	status, response = apiCall(t, client, http.MethodPost, api+"/topics", "application/json", `{"name":"golang"}`)
+	if status != http.StatusConflict || errorCode(response) != "conflict" {
+		t.Errorf("expected a duplicate topic to conflict, but got %d %v", status, response)
+	}
+}
@@ -0,0 +1,125 @@
+package srv
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"sort"
+
+	"github.com/pdk/forum/assets"
+)
+
+// AssetsFS returns the templates and static files to serve: the ones built into
+// the binary, overlaid by any in assetsDir (if not blank). Files in assetsDir
+// take precedence, so a theme may replace just, say, css/forum.css.
+func AssetsFS(assetsDir string) fs.FS {
+
+	if assetsDir == "" {
+		return assets.FS
+	}
+
+	return overlayFS{os.DirFS(assetsDir), assets.FS}
+}
+
+// overlayFS looks for each file in each of its layers in turn. Directory
+// listings are merged.
+type overlayFS []fs.FS
+
+func (o overlayFS) Open(name string) (fs.File, error) {
+
+	var firstErr error
+
+	for _, layer := range o {
+		f, err := layer.Open(name)
+		if err == nil {
+			return o.mergeDir(name, f)
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return nil, firstErr
+}
+
+// mergeDir wraps f, if it is a directory, so that listing it includes the
+// entries from every layer.
+func (o overlayFS) mergeDir(name string, f fs.File) (fs.File, error) {
+
+	info, err := f.Stat()
+	if err != nil || !info.IsDir() {
+		return f, err
+	}
+
+	entries, err := o.ReadDir(name)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return &overlayDir{File: f, entries: entries}, nil
+}
+
+// overlayDir is a directory whose listing has been merged from all layers.
+type overlayDir struct {
+	fs.File
+	entries []fs.DirEntry
+}
+
+func (d *overlayDir) ReadDir(n int) ([]fs.DirEntry, error) {
+
+	if n <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+
+	if n > len(d.entries) {
+		n = len(d.entries)
+	}
+
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+
+	return entries, nil
+}
+
+func (o overlayFS) ReadDir(name string) ([]fs.DirEntry, error) {
+
+	seen := map[string]bool{}
+	entries := []fs.DirEntry{}
+	found := false
+
+	for _, layer := range o {
+		layerEntries, err := fs.ReadDir(layer, name)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		found = true
+		for _, entry := range layerEntries {
+			if !seen[entry.Name()] {
+				seen[entry.Name()] = true
+				entries = append(entries, entry)
+			}
+		}
+	}
+
+	if !found {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+
+	return entries, nil
+}
@@ -0,0 +1,110 @@
+package srv_test
+
+import (
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/pdk/forum/srv"
+)
+
+// writeFiles writes each file (content by slash separated name) under dir.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+
+		err := os.MkdirAll(filepath.Dir(path), 0755)
+		if err == nil {
+			err = os.WriteFile(path, []byte(content), 0644)
+		}
+		if err != nil {
+			t.Fatalf("expected to write %s, but failed: %v", path, err)
+		}
+	}
+}
+
+func TestAssetsOverlay(t *testing.T) {
+
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"static/css/forum.css":  "/* theme */",
+		"static/css/theme.css":  "/* extra */",
+		"static/fonts/a.woff2":  "font",
+		"templates/footer.html": "{{ define \"footer.html\" }}{{ end }}",
+	})
+
+	assets := srv.AssetsFS(dir)
+
+	content, err := fs.ReadFile(assets, "static/css/forum.css")
+	if err != nil || string(content) != "/* theme */" {
+		t.Errorf("expected the theme's forum.css, but got %q, %v", content, err)
+	}
+
+	content, err = fs.ReadFile(assets, "static/css/main.css")
+	if err != nil || len(content) == 0 {
+		t.Errorf("expected the built in main.css, but got %q, %v", content, err)
+	}
+
+	_, err = fs.ReadFile(assets, "static/css/missing.css")
+	if !os.IsNotExist(err) {
+		t.Errorf("expected a missing file not to exist, but got %v", err)
+	}
+
+	entries, err := fs.ReadDir(assets, "static/css")
+	names := []string{}
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	expected := "forum.css main.css normalize.css theme.css"
+	if err != nil || strings.Join(names, " ") != expected {
+		t.Errorf("expected static/css to list %s, but got %v, %v", expected, names, err)
+	}
+
+	err = fstest.TestFS(assets,
+		"static/css/forum.css", "static/css/theme.css", "static/css/main.css",
+		"static/fonts/a.woff2", "templates/home.html", "templates/footer.html")
+	if err != nil {
+		t.Errorf("expected a well behaved file system, but got %v", err)
+	}
+}
+
+func TestAssetsOverlayServed(t *testing.T) {
+
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"static/css/forum.css": "/* theme */",
+		"templates/home.html":  "{{ template \"head.html\" }}<p>themed home</p>{{ template \"foot.html\" }}",
+	})
+
+	server, err := srv.NewServer(newTestStore(t), dir)
+	if err != nil {
+		t.Fatalf("expected to create server, but failed: %v", err)
+	}
+
+	handler, err := server.Handler()
+	if err != nil {
+		t.Fatalf("expected to get handler, but failed: %v", err)
+	}
+
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	client := ts.Client()
+
+	for path, expected := range map[string]string{
+		"/":              "themed home",
+		"/css/forum.css": "/* theme */",
+		"/css/main.css":  "HTML5 Boilerplate",
+	} {
+		status, body := get(t, client, ts.URL+path)
+		if status != http.StatusOK || !strings.Contains(body, expected) {
+			t.Errorf("expected %s to contain %q, but got %d %s", path, expected, status, body)
+		}
+	}
+}
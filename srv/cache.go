@@ -0,0 +1,201 @@
+package srv
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pdk/forum/model"
+)
+
+// Cache-Control for static files. A URL with the file's version (see
+// assetVersions.url) always gets the same content, so it is kept for a year.
+// Other URLs are checked again after an hour.
+const (
+	versionedAssetCache = "public, max-age=31536000, immutable"
+	assetCache          = "public, max-age=3600"
+)
+
+// pageCache is the Cache-Control for pages. They depend on the user, so are
+// not shared, and may change at any time, so are always checked.
+const pageCache = "private, no-cache"
+
+// assetVersions fingerprints the static files, so that their URLs can change
+// whenever their content does.
+type assetVersions struct {
+	assets fs.FS
+
+	mu       sync.Mutex
+	versions map[string]assetVersion
+}
+
+type assetVersion struct {
+	stamp   string // size and modification time, to notice a changed file
+	version string
+}
+
+func newAssetVersions(assets fs.FS) *assetVersions {
+	return &assetVersions{
+		assets:   assets,
+		versions: map[string]assetVersion{},
+	}
+}
+
+// version returns a hash of the static file at urlPath, eg "/css/forum.css".
+// Files are only read again if they have changed.
+func (a *assetVersions) version(urlPath string) (string, error) {
+
+	name := "static" + urlPath
+
+	info, err := fs.Stat(a.assets, name)
+	if err != nil {
+		return "", err
+	}
+	stamp := fmt.Sprintf("%d:%d", info.Size(), info.ModTime().UnixNano())
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if v, ok := a.versions[urlPath]; ok && v.stamp == stamp {
+		return v.version, nil
+	}
+
+	content, err := fs.ReadFile(a.assets, name)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(content)
+	version := hex.EncodeToString(sum[:6])
+	a.versions[urlPath] = assetVersion{stamp: stamp, version: version}
+
+	return version, nil
+}
+
+// url returns the URL of a static file, with its version, for templates (as
+// the "asset" function). If the file cannot be read, the plain URL is used.
+func (a *assetVersions) url(urlPath string) string {
+
+	version, err := a.version(urlPath)
+	if err != nil {
+		return urlPath
+	}
+
+	return urlPath + "?v=" + version
+}
+
+// cacheStatic sets Cache-Control for static files, and uses the version as
+// the ETag, since files built into the binary have no modification time.
+func (a *assetVersions) cacheStatic(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		version, err := a.version(r.URL.Path)
+		if err == nil {
+			w.Header().Set("ETag", `"`+version+`"`)
+
+			if r.URL.Query().Get("v") == version {
+				w.Header().Set("Cache-Control", versionedAssetCache)
+			} else {
+				w.Header().Set("Cache-Control", assetCache)
+			}
+		}
+
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// templatesVersion returns a hash of the page templates, so that page
+// validators change when the templates do.
+func templatesVersion(assets fs.FS) string {
+
+	names, _ := fs.Glob(assets, templateGlob)
+	sort.Strings(names)
+
+	h := sha256.New()
+	for _, name := range names {
+		content, _ := fs.ReadFile(assets, name)
+		fmt.Fprintf(h, "%s:%d:", name, len(content))
+		h.Write(content)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)[:6])
+}
+
+// pageETag returns a weak validator for a page showing data to user. It is a
+// hash of the data, which is the rows the page is about and the stamps of its
+// lists (eg PostsStamp), so that it changes with any row the page shows (eg an
+// edited post, not just a new one), and of the user's state and the
+// templates. It is made before the lists are read, so that a page which has
+// not changed costs only those queries.
+func (s Server) pageETag(user model.User, data ...interface{}) string {
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%d:%s:%s:%t\n", s.templatesVersion, user.ID, user.Name, user.Role, user.Banned)
+
+	encoder := json.NewEncoder(h)
+	for _, d := range data {
+		encoder.Encode(d)
+	}
+
+	return `W/"` + hex.EncodeToString(h.Sum(nil)[:12]) + `"`
+}
+
+// notModified sets the validators for a page, and responds 304 Not Modified
+// if the client already has it. Returns true if so. In dev mode, pages are
+// never validated, since the templates may change at any time.
+func (s Server) notModified(w http.ResponseWriter, r *http.Request, etag string) bool {
+
+	if s.DevMode {
+		return false
+	}
+
+	w.Header().Set("Cache-Control", pageCache)
+	w.Header().Set("ETag", etag)
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+
+	if !etagMatches(r.Header.Get("If-None-Match"), etag) {
+		return false
+	}
+
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// lastModified sets Last-Modified for a page, eg the time of its latest post.
+// It is only for information: edits and moderation do not change it, so a
+// request is only answered 304 by matching the ETag (see notModified).
+func (s Server) lastModified(w http.ResponseWriter, t time.Time) {
+
+	if s.DevMode || t.IsZero() {
+		return
+	}
+
+	w.Header().Set("Last-Modified", t.UTC().Format(http.TimeFormat))
+}
+
+// etagMatches reports whether an If-None-Match header matches etag, by weak
+// comparison.
+func etagMatches(ifNoneMatch, etag string) bool {
+
+	if strings.TrimSpace(ifNoneMatch) == "*" {
+		return true
+	}
+
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(candidate), "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+
+	return false
+}
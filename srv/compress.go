@@ -0,0 +1,317 @@
+package srv
+
+import (
+	"compress/gzip"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+)
+
+// minCompressSize is the smallest response worth compressing. Anything
+// smaller is sent as it is, unless it is flushed first (eg a stream of
+// events).
+const minCompressSize = 1024
+
+// compressibleTypes are the content types worth compressing. Others (eg
+// images) are usually compressed already.
+var compressibleTypes = map[string]bool{
+	"application/atom+xml":   true,
+	"application/javascript": true,
+	"application/json":       true,
+	"application/rss+xml":    true,
+	"application/xml":        true,
+	"image/svg+xml":          true,
+	"text/css":               true,
+	"text/csv":               true,
+	"text/event-stream":      true,
+	"text/html":              true,
+	"text/javascript":        true,
+	"text/plain":             true,
+	"text/xml":               true,
+}
+
+// encodingExtensions are the file extensions of precompressed static files,
+// by encoding, eg css/forum.css.br.
+var encodingExtensions = map[string]string{
+	"br":   ".br",
+	"gzip": ".gz",
+}
+
+// acceptedEncodings returns the encodings we support which the request
+// accepts, most preferred first. Brotli is preferred to gzip if the client
+// likes them equally.
+func acceptedEncodings(r *http.Request) []string {
+
+	quality := map[string]float64{}
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+
+		quality[name] = q
+	}
+
+	encodings := []string{}
+	for _, name := range []string{"br", "gzip"} {
+		q, ok := quality[name]
+		if !ok {
+			q, ok = quality["*"]
+		}
+		if ok && q > 0 {
+			quality[name] = q
+			encodings = append(encodings, name)
+		}
+	}
+
+	sort.SliceStable(encodings, func(i, j int) bool {
+		return quality[encodings[i]] > quality[encodings[j]]
+	})
+
+	return encodings
+}
+
+// compress compresses responses with brotli or gzip, as the client accepts.
+// Small responses, responses of types which do not compress well, and
+// responses which are already encoded are sent as they are.
+func compress(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		encodings := acceptedEncodings(r)
+		if len(encodings) == 0 || r.Method == http.MethodHead || r.Header.Get("Range") != "" {
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressWriter{ResponseWriter: w, encoding: encodings[0]}
+		defer cw.Close()
+
+		handler.ServeHTTP(cw, r)
+	})
+}
+
+var (
+	gzipWriters   = sync.Pool{New: func() interface{} { return gzip.NewWriter(nil) }}
+	brotliWriters = sync.Pool{New: func() interface{} { return brotli.NewWriterLevel(nil, 4) }}
+)
+
+// compressWriter holds back the start of a response until it knows whether
+// to compress it: when minCompressSize has been written, or it is flushed, or
+// the handler has finished.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+
+	status  int
+	buf     []byte
+	decided bool
+	encoder io.WriteCloser // nil if not compressing
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+
+	if cw.decided {
+		cw.ResponseWriter.WriteHeader(status)
+		return
+	}
+
+	if cw.status == 0 {
+		cw.status = status
+	}
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+
+	if cw.decided {
+		if cw.encoder != nil {
+			return cw.encoder.Write(p)
+		}
+		return cw.ResponseWriter.Write(p)
+	}
+
+	cw.buf = append(cw.buf, p...)
+	if len(cw.buf) < minCompressSize {
+		return len(p), nil
+	}
+
+	err := cw.decide(true)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// decide starts the response, compressed if worthwhile, and writes what has
+// been held back.
+func (cw *compressWriter) decide(worthwhile bool) error {
+
+	cw.decided = true
+
+	h := cw.Header()
+	if h.Get("Content-Type") == "" && len(cw.buf) > 0 {
+		// as net/http would, had it seen the start of the body.
+		h.Set("Content-Type", http.DetectContentType(cw.buf))
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(h.Get("Content-Type"))
+
+	status := cw.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	if worthwhile && status != http.StatusNoContent && status != http.StatusNotModified &&
+		h.Get("Content-Encoding") == "" && compressibleTypes[mediaType] {
+
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", cw.encoding)
+
+		// the compressed bytes differ from what a strong ETag promised.
+		if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			h.Set("ETag", "W/"+etag)
+		}
+
+		switch cw.encoding {
+		case "br":
+			bw := brotliWriters.Get().(*brotli.Writer)
+			bw.Reset(cw.ResponseWriter)
+			cw.encoder = bw
+		default:
+			gw := gzipWriters.Get().(*gzip.Writer)
+			gw.Reset(cw.ResponseWriter)
+			cw.encoder = gw
+		}
+	}
+
+	cw.ResponseWriter.WriteHeader(status)
+
+	if len(cw.buf) == 0 {
+		return nil
+	}
+
+	var err error
+	if cw.encoder != nil {
+		_, err = cw.encoder.Write(cw.buf)
+	} else {
+		_, err = cw.ResponseWriter.Write(cw.buf)
+	}
+	cw.buf = nil
+
+	return err
+}
+
+// Flush sends what has been written so far, compressed if the response is
+// being compressed, so that streams of events arrive as they are sent.
+func (cw *compressWriter) Flush() {
+
+	if !cw.decided {
+		cw.decide(true)
+	}
+
+	switch encoder := cw.encoder.(type) {
+	case *gzip.Writer:
+		encoder.Flush()
+	case *brotli.Writer:
+		encoder.Flush()
+	}
+
+	if flusher, ok := cw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Close finishes the response. A response which never reached
+// minCompressSize is sent as it is.
+func (cw *compressWriter) Close() error {
+
+	if !cw.decided {
+		return cw.decide(false)
+	}
+
+	if cw.encoder == nil {
+		return nil
+	}
+
+	err := cw.encoder.Close()
+
+	switch encoder := cw.encoder.(type) {
+	case *gzip.Writer:
+		gzipWriters.Put(encoder)
+	case *brotli.Writer:
+		brotliWriters.Put(encoder)
+	}
+	cw.encoder = nil
+
+	return err
+}
+
+// Unwrap lets http.ResponseController reach the underlying ResponseWriter.
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// precompressed serves a precompressed copy of a static file, eg
+// css/forum.css.br for css/forum.css, if there is one the client accepts.
+// Otherwise the request is left to handler.
+func precompressed(staticFS fs.FS, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		name := strings.TrimPrefix(path.Clean(r.URL.Path), "/")
+
+		for _, encoding := range acceptedEncodings(r) {
+
+			ext := encodingExtensions[encoding]
+
+			info, err := fs.Stat(staticFS, name+ext)
+			if err != nil || info.IsDir() {
+				continue
+			}
+
+			contentType := mime.TypeByExtension(path.Ext(name))
+			if contentType != "" {
+				w.Header().Set("Content-Type", contentType)
+			}
+			w.Header().Set("Content-Encoding", encoding)
+
+			// a different representation needs a different validator.
+			if etag := w.Header().Get("ETag"); etag != "" {
+				w.Header().Set("ETag", strings.TrimSuffix(etag, `"`)+"-"+encoding+`"`)
+			}
+
+			r2 := new(http.Request)
+			*r2 = *r
+			r2.URL = new(url.URL)
+			*r2.URL = *r.URL
+			r2.URL.Path = "/" + name + ext
+			r2.URL.RawPath = ""
+
+			handler.ServeHTTP(w, r2)
+			return
+		}
+
+		handler.ServeHTTP(w, r)
+	})
+}
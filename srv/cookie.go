@@ -50,9 +50,24 @@ var (
 	ErrNotSignedIn = errors.New("not signed in")
 )
 
-// CurrentUser checks the cookie to get current user, and then looks up that
-// user in the database, returning the model.User.
-func CurrentUser(db *sql.DB, r *http.Request) (model.User, error) {
+// CurrentUser checks for an API token, or else the cookie, to get current user,
+// and then looks up that user in the database, returning the model.User. The
+// user is noted for the access log.
+func CurrentUser(st store.Store, r *http.Request) (model.User, error) {
+
+	user, err := currentUser(st, r)
+	if err == nil {
+		noteUser(r, user)
+	}
+
+	return user, err
+}
+
+func currentUser(st store.Store, r *http.Request) (model.User, error) {
+
+	if token := getBearerToken(r); token != "" {
+		return tokenUser(st, r, token)
+	}
 
 	userName, err := getSignedInUserName(r)
 	if err != nil {
@@ -63,7 +78,11 @@ func CurrentUser(db *sql.DB, r *http.Request) (model.User, error) {
 		return model.User{}, fmt.Errorf("%w", ErrNotSignedIn)
 	}
 
-	user, err := store.GetUserByName(db, userName)
+	user, err := st.GetUserByName(r.Context(), userName)
+	if errors.Is(err, sql.ErrNoRows) {
+		return model.User{}, fmt.Errorf("%w: no such user %s", ErrNotSignedIn, userName)
+	}
+
 	if err != nil {
 		return model.User{}, fmt.Errorf("cannot get user %s from database: %w", userName, err)
 	}
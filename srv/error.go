@@ -1,16 +1,21 @@
 package srv
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
 	"net/http"
+
+	"github.com/pdk/forum/action"
+	"github.com/pdk/forum/logging"
 )
 
 // handleError takes varargs, and assumes the last one is an error var. If not,
 // this method will terminate the program (so that we find & fix such coding
-// errors).
-func handleError(w http.ResponseWriter, message string, args ...interface{}) bool {
+// errors). The message is a format for fmt.Errorf, eg "cannot get topic %d:
+// %w".
+func handleError(w http.ResponseWriter, r *http.Request, message string, args ...interface{}) bool {
 
 	// this will panic if there are 0 args
 	lastArg := args[len(args)-1]
@@ -24,9 +29,79 @@ func handleError(w http.ResponseWriter, message string, args ...interface{}) boo
 		return false
 	}
 
-	message = fmt.Sprintf(message, args...)
+	if contextEnded(w, r, err) {
+		return true
+	}
+
+	internalError(w, r, fmt.Errorf(message, args...))
+
+	return true
+}
+
+// internalError logs an error, and responds 500 with the error and the
+// request's ID, so that the user can tell us which request failed.
+func internalError(w http.ResponseWriter, r *http.Request, err error) {
+
+	logging.FromContext(r.Context()).Error("request failed", "error", err)
+
+	message := err.Error()
+	if id := RequestID(r.Context()); id != "" {
+		message = fmt.Sprintf("%s\n\nrequest ID: %s", message, id)
+	}
+
 	http.Error(w, message, http.StatusInternalServerError)
+}
+
+// contextEnded responds 503 if the request timed out. If the client has gone
+// away, there is no one to respond to, so nothing is written. Returns true if
+// err was either.
+func contextEnded(w http.ResponseWriter, r *http.Request, err error) bool {
+
+	logger := logging.FromContext(r.Context())
+
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		logger.Warn("request timed out", "error", err)
+		http.Error(w, "request timed out", http.StatusServiceUnavailable)
+	case errors.Is(err, context.Canceled):
+		logger.Info("request cancelled", "error", err)
+	default:
+		return false
+	}
+
+	return true
+}
+
+// tokenRefused responds 401 or 403 if an API token was not accepted. Returns
+// true if the request has been refused.
+func tokenRefused(w http.ResponseWriter, r *http.Request, err error) bool {
+
+	switch {
+	case err == nil:
+		return false
+	case contextEnded(w, r, err):
+	case errors.Is(err, action.ErrForbidden):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, ErrNotSignedIn):
+		w.Header().Set("WWW-Authenticate", `Bearer realm="forum"`)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+	default:
+		internalError(w, r, err)
+	}
+
+	return true
+}
+
+// notSignedIn redirects to the front page if err is ErrNotSignedIn, eg for a
+// cookie naming a user who no longer exists, as OnlySignedIn does when there
+// is no cookie. Returns true if so.
+func notSignedIn(w http.ResponseWriter, r *http.Request, err error) bool {
+
+	if !errors.Is(err, ErrNotSignedIn) {
+		return false
+	}
 
+	http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
 	return true
 }
 
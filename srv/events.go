@@ -0,0 +1,187 @@
+package srv
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pdk/forum/broker"
+	"github.com/pdk/forum/logging"
+	"github.com/pdk/forum/model"
+)
+
+// eventsSuffix is the last part of a thread path which asks for the stream of
+// new posts.
+const eventsSuffix = "/events"
+
+const (
+	// heartbeatInterval is how often a comment is sent on an idle stream, to
+	// keep proxies from closing it.
+	heartbeatInterval = 20 * time.Second
+	// catchUpLimit is the most posts sent to a client reconnecting with
+	// Last-Event-ID. A client which missed more is told to reload.
+	catchUpLimit = 500
+)
+
+// ThreadEvents streams new posts in a thread as Server-Sent Events. Each event
+// is a "post" with the post ID as the event ID, and the rendered post as the
+// data. Clients reconnecting with Last-Event-ID (or opening the stream with
+// ?lastEventId=, the last post on their page) are first sent the posts they
+// missed, or a "reload" event if they missed too many.
+func (s Server) ThreadEvents(w http.ResponseWriter, r *http.Request) {
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	// the stream is long lived, so is exempt from the server's write timeout.
+	err := http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("cannot lift write deadline for event stream", "error", err)
+	}
+
+	threadID, err := getPathID(r.URL)
+	if handleError(w, r, "cannot get thread id: %w", err) {
+		return
+	}
+
+	thread, err := s.Store.GetThreadByID(r.Context(), threadID)
+	if errorNotFound(w, r, err) || handleError(w, r, "cannot query thread %d: %w", threadID, err) {
+		return
+	}
+
+	lastID, err := lastEventID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// subscribe before catching up, so that no post falls in between.
+	sub, err := s.Events.Subscribe(thread.ID)
+	if errors.Is(err, broker.ErrTooManySubscribers) || errors.Is(err, broker.ErrClosed) {
+		w.Header().Set("Retry-After", "30")
+		http.Error(w, "live updates are not available right now", http.StatusServiceUnavailable)
+		return
+	}
+	if handleError(w, r, "cannot subscribe to thread %d: %w", thread.ID, err) {
+		return
+	}
+	defer s.Events.Unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	fmt.Fprintf(w, "retry: %d\n\n", 3000)
+
+	if lastID > 0 {
+		missed, err := s.Store.QueryPostsAfterID(r.Context(), thread.ID, lastID, catchUpLimit+1)
+		if err != nil {
+			logging.FromContext(r.Context()).Error("cannot catch up thread", "thread_id", thread.ID, "after_post_id", lastID, "error", err)
+			return
+		}
+
+		if len(missed) > catchUpLimit {
+			// too far behind to catch up by events: the page is reloaded.
+			fmt.Fprint(w, "event: reload\ndata: \n\n")
+			flusher.Flush()
+			return
+		}
+
+		for _, post := range missed {
+			if !s.writePostEvent(r.Context(), w, post) {
+				return
+			}
+			lastID = post.ID
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case post, ok := <-sub.Posts:
+			if !ok {
+				// dropped by the broker (or shutting down). the client will
+				// reconnect with Last-Event-ID and catch up.
+				return
+			}
+			if post.ID <= lastID {
+				continue
+			}
+			if !s.writePostEvent(r.Context(), w, post) {
+				return
+			}
+			lastID = post.ID
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+		case <-r.Context().Done():
+			return
+		}
+
+		flusher.Flush()
+	}
+}
+
+// writePostEvent writes one post as an event. Returns false if the stream
+// should end.
+func (s Server) writePostEvent(ctx context.Context, w http.ResponseWriter, post model.Post) bool {
+
+	posts, err := s.displayPosts(ctx, []model.Post{post})
+	if err != nil {
+		logging.FromContext(ctx).Error("cannot display post", "post_id", post.ID, "error", err)
+		return false
+	}
+
+	tmpl, err := s.templates()
+	if err != nil {
+		logging.FromContext(ctx).Error("cannot render post", "post_id", post.ID, "error", err)
+		return false
+	}
+
+	buf := bytes.Buffer{}
+	err = tmpl.ExecuteTemplate(&buf, "post.html", posts[0])
+	if err != nil {
+		logging.FromContext(ctx).Error("cannot render post", "post_id", post.ID, "error", err)
+		return false
+	}
+
+	fmt.Fprintf(w, "id: %d\nevent: post\n", post.ID)
+	for _, line := range strings.Split(strings.TrimRight(buf.String(), "\n"), "\n") {
+		fmt.Fprintf(w, "data: %s\n", line)
+	}
+	_, err = fmt.Fprint(w, "\n")
+
+	return err == nil
+}
+
+// lastEventID returns the ID of the last event the client saw, if any, from
+// the Last-Event-ID header (or lastEventId query parameter).
+func lastEventID(r *http.Request) (int64, error) {
+
+	value := r.Header.Get("Last-Event-ID")
+	if value == "" {
+		value = r.URL.Query().Get("lastEventId")
+	}
+
+	if value == "" {
+		return 0, nil
+	}
+
+	id, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("cannot parse last event id %s", value)
+	}
+
+	return id, nil
+}
@@ -0,0 +1,236 @@
+package srv
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pdk/forum/model"
+)
+
+// feedSuffix is the last part of a topic or thread path which asks for the
+// Atom feed, rather than the page.
+const feedSuffix = "/feed.atom"
+
+// feedLength is the number of posts included in a feed.
+const feedLength = 50
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomEntry struct {
+	ID        string     `xml:"id"`
+	Title     string     `xml:"title"`
+	Updated   string     `xml:"updated"`
+	Published string     `xml:"published"`
+	Author    atomPerson `xml:"author"`
+	Link      atomLink   `xml:"link"`
+	Content   atomText   `xml:"content"`
+}
+
+type atomPerson struct {
+	Name string `xml:"name"`
+}
+
+type atomText struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+// RecentPostsFeed is the Atom feed of recent posts in the whole forum.
+func (s Server) RecentPostsFeed(w http.ResponseWriter, r *http.Request) {
+
+	stamp, err := s.Store.RecentPostsStamp(r.Context(), feedLength)
+	if handleError(w, r, "cannot get stamp of recent posts: %w", err) || s.feedNotModified(w, r, "forum", "/topics", stamp) {
+		return
+	}
+
+	posts, err := s.Store.QueryRecentPosts(r.Context(), feedLength)
+	if handleError(w, r, "cannot query recent posts: %w", err) {
+		return
+	}
+
+	s.writeFeed(w, r, "forum", "/topics", posts)
+}
+
+// TopicFeed is the Atom feed of recent posts in one topic.
+func (s Server) TopicFeed(w http.ResponseWriter, r *http.Request) {
+
+	topicID, err := getPathID(r.URL)
+	if handleError(w, r, "cannot identify topic id: %w", err) {
+		return
+	}
+
+	topic, err := s.Store.GetTopicByID(r.Context(), topicID)
+	if errorNotFound(w, r, err) || handleError(w, r, "cannot get topic %d: %w", topicID, err) {
+		return
+	}
+
+	title, pagePath := "forum: "+topic.Name, fmt.Sprintf("/topics/%d", topic.ID)
+
+	stamp, err := s.Store.RecentPostsStampByTopicID(r.Context(), topic.ID, feedLength)
+	if handleError(w, r, "cannot get stamp of posts for topic %d: %w", topic.ID, err) || s.feedNotModified(w, r, title, pagePath, stamp) {
+		return
+	}
+
+	posts, err := s.Store.QueryRecentPostsByTopicID(r.Context(), topic.ID, feedLength)
+	if handleError(w, r, "cannot query posts for topic %d: %w", topic.ID, err) {
+		return
+	}
+
+	s.writeFeed(w, r, title, pagePath, posts)
+}
+
+// ThreadFeed is the Atom feed of recent posts in one thread.
+func (s Server) ThreadFeed(w http.ResponseWriter, r *http.Request) {
+
+	threadID, err := getPathID(r.URL)
+	if handleError(w, r, "cannot identify thread id: %w", err) {
+		return
+	}
+
+	thread, err := s.Store.GetThreadByID(r.Context(), threadID)
+	if errorNotFound(w, r, err) || handleError(w, r, "cannot get thread %d: %w", threadID, err) {
+		return
+	}
+
+	title, pagePath := "forum: "+thread.Subject, fmt.Sprintf("/threads/%d", thread.ID)
+
+	stamp, err := s.Store.PostsStamp(r.Context(), thread.ID)
+	if handleError(w, r, "cannot get stamp of posts for thread %d: %w", thread.ID, err) || s.feedNotModified(w, r, title, pagePath, stamp) {
+		return
+	}
+
+	posts, err := s.Store.QueryRecentPostsByThreadID(r.Context(), thread.ID, feedLength)
+	if handleError(w, r, "cannot query posts for thread %d: %w", thread.ID, err) {
+		return
+	}
+
+	s.writeFeed(w, r, title, pagePath, posts)
+}
+
+// feedNotModified sets the headers of a feed, with an ETag made from the stamp
+// of its posts (eg RecentPostsStamp), and responds 304 Not Modified if the
+// client already has it, before any posts are read. Returns true if so.
+func (s Server) feedNotModified(w http.ResponseWriter, r *http.Request, title, pagePath, stamp string) bool {
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s\n%s\n%s\n", s.baseURL(r), title, pagePath, stamp)
+	etag := `"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+
+	w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache")
+
+	if !etagMatches(r.Header.Get("If-None-Match"), etag) {
+		return false
+	}
+
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// writeFeed renders the posts (newest first) as an Atom feed. There is no
+// Last-Modified, since posts have no time of their last edit: feeds are only
+// validated by the ETag (see feedNotModified).
+func (s Server) writeFeed(w http.ResponseWriter, r *http.Request, title, pagePath string, posts []model.Post) {
+
+	base := s.baseURL(r)
+
+	updated := time.Unix(0, 0).UTC()
+	if len(posts) > 0 {
+		updated = posts[0].PostedAt.UTC()
+	}
+
+	threads := map[int64]model.Thread{}
+	users := map[int64]model.User{}
+
+	for _, post := range posts {
+
+		if _, ok := threads[post.ThreadID]; !ok {
+			thread, err := s.Store.GetThreadByID(r.Context(), post.ThreadID)
+			if handleError(w, r, "cannot get thread %d: %w", post.ThreadID, err) {
+				return
+			}
+			threads[thread.ID] = thread
+		}
+
+		if _, ok := users[post.PostedByID]; !ok {
+			user, err := s.Store.GetUserByID(r.Context(), post.PostedByID)
+			if handleError(w, r, "cannot get user %d: %w", post.PostedByID, err) {
+				return
+			}
+			users[user.ID] = user
+		}
+	}
+
+	feed := atomFeed{
+		ID:      base + pagePath,
+		Title:   title,
+		Updated: updated.Format(time.RFC3339),
+		Links: []atomLink{
+			{Rel: "alternate", Type: "text/html", Href: base + pagePath},
+			{Rel: "self", Type: "application/atom+xml", Href: base + r.URL.Path},
+		},
+		Entries: []atomEntry{},
+	}
+
+	for _, post := range posts {
+
+		thread := threads[post.ThreadID]
+		link := fmt.Sprintf("%s/threads/%d#post-%d", base, thread.ID, post.ID)
+		postedAt := post.PostedAt.UTC().Format(time.RFC3339)
+
+		feed.Entries = append(feed.Entries, atomEntry{
+			ID:        link,
+			Title:     thread.Subject,
+			Updated:   postedAt,
+			Published: postedAt,
+			Author:    atomPerson{Name: users[post.PostedByID].Name},
+			Link:      atomLink{Rel: "alternate", Type: "text/html", Href: link},
+			Content:   atomText{Type: "html", Body: string(bodyAsHTML(post.Body))},
+		})
+	}
+
+	buf := bytes.Buffer{}
+	buf.WriteString(xml.Header)
+	err := xml.NewEncoder(&buf).Encode(feed)
+	if handleError(w, r, "cannot encode feed: %w", err) {
+		return
+	}
+
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(buf.Bytes()))
+}
+
+// baseURL returns the configured BaseURL, or else the scheme and host the
+// request was made to.
+func (s Server) baseURL(r *http.Request) string {
+
+	if s.BaseURL != "" {
+		return strings.TrimSuffix(s.BaseURL, "/")
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+
+	return scheme + "://" + r.Host
+}
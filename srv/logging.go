@@ -0,0 +1,112 @@
+package srv
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"github.com/pdk/forum/logging"
+	"github.com/pdk/forum/model"
+)
+
+// requestIDHeader carries a request's ID: from a proxy in front of us, if it
+// has one, and back to the client.
+const requestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength limits the IDs accepted from clients.
+const maxRequestIDLength = 64
+
+// requestInfo is what the access log needs to know, which is only found out
+// while handling the request.
+type requestInfo struct {
+	id     string
+	userID int64
+}
+
+type requestInfoKey struct{}
+
+// logRequests gives each request an ID, and a logger which adds the ID to
+// everything it logs, and writes an access log record when the request is
+// done.
+func logRequests(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		start := time.Now()
+
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+
+		info := &requestInfo{id: id}
+		logger := logging.Default().With("request_id", id)
+
+		ctx := context.WithValue(r.Context(), requestInfoKey{}, info)
+		ctx = logging.NewContext(ctx, logger)
+
+		sw := &statusWriter{ResponseWriter: w}
+		handler.ServeHTTP(sw, r.WithContext(ctx))
+
+		fields := []interface{}{
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", sw.statusCode(),
+			"bytes", sw.bytes,
+			"duration_ms", float64(time.Since(start).Microseconds()) / 1000,
+		}
+		if info.userID != 0 {
+			fields = append(fields, "user_id", info.userID)
+		}
+
+		logger.Info("request", fields...)
+	})
+}
+
+// RequestID returns the ID of the request being handled, or "" if there is
+// none.
+func RequestID(ctx context.Context) string {
+
+	if info, ok := ctx.Value(requestInfoKey{}).(*requestInfo); ok {
+		return info.id
+	}
+
+	return ""
+}
+
+// noteUser records who made the request, for the access log.
+func noteUser(r *http.Request, user model.User) {
+
+	if info, ok := r.Context().Value(requestInfoKey{}).(*requestInfo); ok {
+		info.userID = user.ID
+	}
+}
+
+// validRequestID accepts IDs of letters, digits, '-', '_' and '.', so that
+// whatever a client sends cannot mangle the logs.
+func validRequestID(id string) bool {
+
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_', c == '.':
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+func newRequestID() string {
+
+	b := make([]byte, 8)
+	rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,122 @@
+package srv
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/pdk/forum/metrics"
+)
+
+// instrument counts the requests to a route, by method and status, and how
+// long they take. Without Metrics, the handler is returned as it is.
+func (s Server) instrument(route string, handler http.HandlerFunc) http.HandlerFunc {
+
+	if s.Metrics == nil {
+		return handler
+	}
+
+	requests := s.Metrics.Counter("forum_http_requests_total",
+		"HTTP requests, by route, method and status code.", "route", "method", "code")
+	durations := s.Metrics.Histogram("forum_http_request_duration_seconds",
+		"How long HTTP requests took, by route. Live update streams last as long as the browser watches.",
+		metrics.DefaultBuckets, "route")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w}
+
+		handler(sw, r)
+
+		requests.Inc(route, methodLabel(r.Method), strconv.Itoa(sw.statusCode()))
+		durations.Observe(time.Since(start).Seconds(), route)
+	}
+}
+
+// OnlyMetricsToken refuses requests without MetricsToken as their bearer
+// token.
+func (s Server) OnlyMetricsToken(handler http.HandlerFunc) http.HandlerFunc {
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		token := getBearerToken(r)
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.MetricsToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="forum metrics"`)
+			http.Error(w, "metrics need the metrics token", http.StatusUnauthorized)
+			return
+		}
+
+		handler(w, r)
+	}
+}
+
+// methodLabel returns the method, or "other" for anything unusual, so that
+// clients cannot add labels at will.
+func methodLabel(method string) string {
+
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodOptions:
+		return method
+	}
+
+	return "other"
+}
+
+// observeRender records how long a template took to render.
+func (s Server) observeRender(name string, elapsed time.Duration) {
+	s.Metrics.Histogram("forum_template_render_seconds",
+		"How long page templates took to render, by template.", metrics.DefaultBuckets, "template").
+		Observe(elapsed.Seconds(), name)
+}
+
+// statusWriter notes the status and size of a response.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (sw *statusWriter) WriteHeader(status int) {
+
+	if sw.status == 0 {
+		sw.status = status
+	}
+
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+func (sw *statusWriter) Write(p []byte) (int, error) {
+
+	if sw.status == 0 {
+		sw.status = http.StatusOK
+	}
+
+	n, err := sw.ResponseWriter.Write(p)
+	sw.bytes += int64(n)
+
+	return n, err
+}
+
+// Flush passes on flushes, for live update streams.
+func (sw *statusWriter) Flush() {
+
+	if flusher, ok := sw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying ResponseWriter.
+func (sw *statusWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
+
+func (sw *statusWriter) statusCode() int {
+
+	if sw.status == 0 {
+		return http.StatusOK
+	}
+
+	return sw.status
+}
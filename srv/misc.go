@@ -1,6 +1,7 @@
 package srv
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"html/template"
@@ -9,15 +10,31 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
+
+	"github.com/pdk/forum/action"
+	"github.com/pdk/forum/model"
 )
 
-// OnlySignedIn will redirect to front page if the user is not signed in.
+// OnlySignedIn will redirect to front page if the user is not signed in. Requests
+// with an API token are refused if the token is not valid for the request.
 func (s Server) OnlySignedIn(handler http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
+		if getBearerToken(r) != "" {
+			_, err := CurrentUser(s.Store, r)
+			if tokenRefused(w, r, err) {
+				return
+			}
+
+			handler(w, r)
+			return
+		}
+
 		userName, err := getSignedInUserName(r)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
 
 		if userName == "" {
@@ -30,6 +47,45 @@ func (s Server) OnlySignedIn(handler http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
+// BySuffix routes requests for paths ending in one of the suffixes (eg
+// "/feed.atom") to that handler, and all others to the page handler.
+func BySuffix(page http.HandlerFunc, suffixes map[string]http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		for suffix, handler := range suffixes {
+			if strings.HasSuffix(r.URL.Path, suffix) {
+				handler(w, r)
+				return
+			}
+		}
+
+		page(w, r)
+	}
+}
+
+// withTimeout cancels each request's context after timeout, so that its
+// database work does not outlive it. Live update streams are left alone. A
+// timeout of 0 means no limit.
+func withTimeout(timeout time.Duration, handler http.Handler) http.Handler {
+
+	if timeout <= 0 {
+		return handler
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if strings.HasSuffix(r.URL.Path, eventsSuffix) {
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+
+		handler.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
 func getPathID(url *url.URL) (int64, error) {
 
 	uriParts := strings.Split(url.Path, "/")
@@ -46,21 +102,53 @@ func getPathID(url *url.URL) (int64, error) {
 }
 
 // UserError returns the error page with a message for the user.
-func (s Server) UserError(w io.Writer, message string) {
+func (s Server) UserError(w io.Writer, r *http.Request, message string) {
 
-	s.WritePage(w, "user-error.html", map[string]interface{}{
+	s.WritePage(w, r, "user-error.html", map[string]interface{}{
 		"message": message,
 	})
 }
 
 // MaybeUserError returns an error page if the condition is true.
-func (s Server) MaybeUserError(w io.Writer, condition bool, message string, args ...interface{}) bool {
+func (s Server) MaybeUserError(w io.Writer, r *http.Request, condition bool, message string, args ...interface{}) bool {
 
 	if !condition {
 		return false
 	}
 
-	s.UserError(w, fmt.Sprintf(message, args...))
+	s.UserError(w, r, fmt.Sprintf(message, args...))
+
+	return true
+}
+
+// MaybeValidationError returns an error page if the error is a
+// model.ValidationError, ie something the user can correct.
+func (s Server) MaybeValidationError(w io.Writer, r *http.Request, err error) bool {
+
+	var validationErr model.ValidationError
+	if !errors.As(err, &validationErr) {
+		return false
+	}
+
+	s.UserError(w, r, validationErr.Message)
+
+	return true
+}
+
+// MaybeForbidden returns an error page if the error is action.ErrForbidden, eg
+// the user is banned, or the thread is locked.
+func (s Server) MaybeForbidden(w io.Writer, r *http.Request, err error) bool {
+
+	switch {
+	case errors.Is(err, action.ErrBanned):
+		s.UserError(w, r, "you have been banned, and cannot make changes")
+	case errors.Is(err, action.ErrThreadLocked):
+		s.UserError(w, r, "this thread is locked")
+	case errors.Is(err, action.ErrForbidden):
+		s.UserError(w, r, "you are not permitted to do that")
+	default:
+		return false
+	}
 
 	return true
 }
@@ -1,6 +1,8 @@
 package srv
 
 import (
+	"context"
+	"fmt"
 	"html/template"
 	"net/http"
 	"strconv"
@@ -22,7 +24,7 @@ func (s Server) HomePage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	s.WritePage(w, "home.html", nil)
+	s.WritePage(w, r, "home.html", nil)
 }
 
 // SignIn handles new user sign in.
@@ -30,14 +32,14 @@ func (s Server) SignIn(w http.ResponseWriter, r *http.Request) {
 
 	name := r.FormValue("name")
 
-	user, err := store.GetOrCreateUserByName(s.DB, name)
-	if handleError(w, "cannot find/create user %s: %w", name, err) {
+	user, err := s.Actions.SignIn(r.Context(), name)
+	if s.MaybeValidationError(w, r, err) || handleError(w, r, "cannot find/create user %s: %w", name, err) {
 		return
 	}
 
 	setSignedInUserName(w, user.Name)
 
-	s.WritePage(w, "welcome.html", map[string]string{
+	s.WritePage(w, r, "welcome.html", map[string]string{
 		"name": name,
 	})
 }
@@ -45,12 +47,26 @@ func (s Server) SignIn(w http.ResponseWriter, r *http.Request) {
 // TopicsPage shows the list of available topics.
 func (s Server) TopicsPage(w http.ResponseWriter, r *http.Request) {
 
-	topicList, err := store.QueryTopics(s.DB)
-	if handleError(w, "cannot get list of topics: %w", err) {
+	user, err := CurrentUser(s.Store, r)
+	if notSignedIn(w, r, err) || handleError(w, r, "cannot get current user: %w", err) {
 		return
 	}
 
-	s.WritePage(w, "topics.html", map[string]interface{}{
+	stamp, err := s.Store.TopicsStamp(r.Context())
+	if handleError(w, r, "cannot check topics: %w", err) {
+		return
+	}
+
+	if s.notModified(w, r, s.pageETag(user, stamp)) {
+		return
+	}
+
+	topicList, err := s.Store.ListTopics(r.Context(), store.AllRows)
+	if handleError(w, r, "cannot get list of topics: %w", err) {
+		return
+	}
+
+	s.WritePage(w, r, "topics.html", map[string]interface{}{
 		"topics": topicList,
 	})
 }
@@ -59,21 +75,35 @@ func (s Server) TopicsPage(w http.ResponseWriter, r *http.Request) {
 func (s Server) OneTopicPage(w http.ResponseWriter, r *http.Request) {
 
 	topicID, err := getPathID(r.URL)
-	if handleError(w, "cannot identify topic id: %w", err) {
+	if handleError(w, r, "cannot identify topic id: %w", err) {
 		return
 	}
 
-	topic, err := store.GetTopicByID(s.DB, topicID)
-	if errorNotFound(w, r, err) || handleError(w, "cannot get topic %d: %w", topicID, err) {
+	topic, err := s.Store.GetTopicByID(r.Context(), topicID)
+	if errorNotFound(w, r, err) || handleError(w, r, "cannot get topic %d: %w", topicID, err) {
 		return
 	}
 
-	threads, err := store.QueryThreadsByTopicID(s.DB, topic.ID)
-	if handleError(w, "cannot get threads for topic %d: %w", topic.ID, err) {
+	user, err := CurrentUser(s.Store, r)
+	if notSignedIn(w, r, err) || handleError(w, r, "cannot get current user: %w", err) {
 		return
 	}
 
-	s.WritePage(w, "threads.html", map[string]interface{}{
+	stamp, err := s.Store.ThreadsStamp(r.Context(), topic.ID)
+	if handleError(w, r, "cannot check threads for topic %d: %w", topic.ID, err) {
+		return
+	}
+
+	if s.notModified(w, r, s.pageETag(user, topic, stamp)) {
+		return
+	}
+
+	threads, err := s.Store.ListThreadsByTopicID(r.Context(), topic.ID, store.AllRows)
+	if handleError(w, r, "cannot get threads for topic %d: %w", topic.ID, err) {
+		return
+	}
+
+	s.WritePage(w, r, "threads.html", map[string]interface{}{
 		"topic":   topic,
 		"threads": threads,
 	})
@@ -83,22 +113,20 @@ func (s Server) OneTopicPage(w http.ResponseWriter, r *http.Request) {
 func (s Server) AddTopic(w http.ResponseWriter, r *http.Request) {
 
 	topicName := strings.TrimSpace(r.FormValue("name"))
-	if s.MaybeUserError(w, len(topicName) == 0, "new topic name must not be blank") {
-		return
-	}
 
-	user, err := CurrentUser(s.DB, r)
-	if handleError(w, "cannot get current user", err) {
+	user, err := CurrentUser(s.Store, r)
+	if handleError(w, r, "cannot get current user: %w", err) {
 		return
 	}
 
-	topic := model.NewTopic(user.ID, topicName)
-	topic, err = store.CreateTopic(s.DB, topic)
-	if handleError(w, "cannot create topic: %w", err) {
+	topic, err := s.CreateTopic(r.Context(), user, topicName)
+	if s.MaybeValidationError(w, r, err) || s.MaybeForbidden(w, r, err) ||
+		s.MaybeUserError(w, r, store.IsDuplicate(err), "a topic named %s already exists", topicName) ||
+		handleError(w, r, "cannot create topic: %w", err) {
 		return
 	}
 
-	s.WritePage(w, "new-topic.html", map[string]interface{}{
+	s.WritePage(w, r, "new-topic.html", map[string]interface{}{
 		"topic": topic,
 	})
 }
@@ -106,34 +134,23 @@ func (s Server) AddTopic(w http.ResponseWriter, r *http.Request) {
 // AddPost adds a post to a threed.
 func (s Server) AddPost(w http.ResponseWriter, r *http.Request) {
 
-	user, err := CurrentUser(s.DB, r)
-	if handleError(w, "cannot get current user", err) {
-		return
-	}
-
-	body := strings.TrimSpace(r.FormValue("body"))
-	if s.MaybeUserError(w, body == "", "Cannot post with blank comment.") {
+	user, err := CurrentUser(s.Store, r)
+	if handleError(w, r, "cannot get current user: %w", err) {
 		return
 	}
 
 	threadIDString := r.FormValue("threadID")
 	threadID, err := strconv.ParseInt(threadIDString, 10, 64)
-	if handleError(w, "cannot parse thread id %s: %w", threadIDString, err) {
+	if handleError(w, r, "cannot parse thread id %s: %w", threadIDString, err) {
 		return
 	}
 
-	thread, err := store.GetThreadByID(s.DB, threadID)
-	if handleError(w, "cannot get thread %d: %w", threadID, err) {
+	thread, post, err := s.CreatePost(r.Context(), user, threadID, r.FormValue("body"))
+	if s.MaybeValidationError(w, r, err) || s.MaybeForbidden(w, r, err) || handleError(w, r, "cannot add post to thread %d: %w", threadID, err) {
 		return
 	}
 
-	post := model.NewPost(threadID, user.ID, body)
-	post, err = store.CreatePost(s.DB, post)
-	if handleError(w, "cannot save new post: %w", err) {
-		return
-	}
-
-	s.WritePage(w, "new-post.html", map[string]interface{}{
+	s.WritePage(w, r, "new-post.html", map[string]interface{}{
 		"thread": thread,
 		"post":   post,
 	})
@@ -142,95 +159,117 @@ func (s Server) AddPost(w http.ResponseWriter, r *http.Request) {
 // AddThread adds a new topic.
 func (s Server) AddThread(w http.ResponseWriter, r *http.Request) {
 
-	user, err := CurrentUser(s.DB, r)
-	if handleError(w, "cannot get current user", err) {
+	user, err := CurrentUser(s.Store, r)
+	if handleError(w, r, "cannot get current user: %w", err) {
 		return
 	}
 
 	topicIDString := r.FormValue("topicID")
 	topicID, err := strconv.ParseInt(topicIDString, 10, 64)
-	if handleError(w, "cannot parse topic id %s: %w", topicIDString, err) {
-		return
-	}
-
-	subject := strings.TrimSpace(r.FormValue("subject"))
-	body := strings.TrimSpace(r.FormValue("body"))
-	if s.MaybeUserError(w, subject == "" || body == "", "To create a thread, both subject and comments must be non-blank.") {
+	if handleError(w, r, "cannot parse topic id %s: %w", topicIDString, err) {
 		return
 	}
 
-	topic, err := store.GetTopicByID(s.DB, topicID)
-	if handleError(w, "cannot get topic to create new thread: %w", err) {
+	thread, post, err := s.CreateThread(r.Context(), user, topicID, r.FormValue("subject"), r.FormValue("body"))
+	if s.MaybeValidationError(w, r, err) || s.MaybeForbidden(w, r, err) || handleError(w, r, "cannot create thread: %w", err) {
 		return
 	}
 
-	thread := model.NewThread(topic.ID, user.ID, subject)
-	thread, err = store.CreateThread(s.DB, thread)
-	if handleError(w, "cannot save new thread: %w", err) {
-		return
-	}
-
-	post := model.NewPost(thread.ID, user.ID, body)
-	post, err = store.CreatePost(s.DB, post)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	s.WritePage(w, "new-thread.html", map[string]interface{}{
+	s.WritePage(w, r, "new-thread.html", map[string]interface{}{
 		"thread": thread,
 		"post":   post,
 	})
 }
 
 type displayPost struct {
+	ID       int64
 	Body     template.HTML
 	UserName string
 	PostedAt time.Time
 }
 
+// displayPosts prepares posts for the post.html template.
+func (s Server) displayPosts(ctx context.Context, posts []model.Post) ([]displayPost, error) {
+
+	displayPosts := []displayPost{}
+	for _, post := range posts {
+
+		user, err := s.Store.GetUserByID(ctx, post.PostedByID)
+		if err != nil {
+			return displayPosts, fmt.Errorf("cannot get user %d: %w", post.PostedByID, err)
+		}
+
+		displayPosts = append(displayPosts,
+			displayPost{
+				ID:       post.ID,
+				Body:     bodyAsHTML(post.Body),
+				UserName: user.Name,
+				PostedAt: post.PostedAt.Round(0), // drop monotonic clock reading from display
+			})
+	}
+
+	return displayPosts, nil
+}
+
 // OneThreadPage shows the comments within one thread.
 func (s Server) OneThreadPage(w http.ResponseWriter, r *http.Request) {
 
 	threadID, err := getPathID(r.URL)
-	if handleError(w, "cannot get thread id: %w", err) {
+	if handleError(w, r, "cannot get thread id: %w", err) {
 		return
 	}
 
-	thread, err := store.GetThreadByID(s.DB, threadID)
-	if handleError(w, "cannot query thread %d: %w", threadID, err) {
+	thread, err := s.Store.GetThreadByID(r.Context(), threadID)
+	if handleError(w, r, "cannot query thread %d: %w", threadID, err) {
 		return
 	}
 
-	topic, err := store.GetTopicByID(s.DB, thread.TopicID)
-	if handleError(w, "cannot query topic %d: %w", thread.TopicID, err) {
+	topic, err := s.Store.GetTopicByID(r.Context(), thread.TopicID)
+	if handleError(w, r, "cannot query topic %d: %w", thread.TopicID, err) {
 		return
 	}
 
-	posts, err := store.QueryPostsByThreadID(s.DB, thread.ID)
-	if handleError(w, "cannot query posts for thread %d: %w", thread.ID, err) {
+	user, err := CurrentUser(s.Store, r)
+	if notSignedIn(w, r, err) || handleError(w, r, "cannot get current user: %w", err) {
 		return
 	}
 
-	displayPosts := []displayPost{}
-	for _, post := range posts {
+	stamp, err := s.Store.PostsStamp(r.Context(), thread.ID)
+	if handleError(w, r, "cannot check posts for thread %d: %w", thread.ID, err) {
+		return
+	}
 
-		user, err := store.GetUserByID(s.DB, post.PostedByID)
-		if handleError(w, "cannot get user %d: %w", post.PostedByID, err) {
-			return
-		}
+	if s.notModified(w, r, s.pageETag(user, topic, thread, stamp)) {
+		return
+	}
 
-		displayPosts = append(displayPosts,
-			displayPost{
-				Body:     bodyAsHTML(post.Body),
-				UserName: user.Name,
-				PostedAt: post.PostedAt,
-			})
+	posts, err := s.Store.ListPostsByThreadID(r.Context(), thread.ID, store.AllRows)
+	if handleError(w, r, "cannot query posts for thread %d: %w", thread.ID, err) {
+		return
 	}
 
-	s.WritePage(w, "one-thread.html", map[string]interface{}{
-		"topic":  topic,
-		"thread": thread,
-		"posts":  displayPosts,
+	displayPosts, err := s.displayPosts(r.Context(), posts)
+	if handleError(w, r, "cannot display posts for thread %d: %w", thread.ID, err) {
+		return
+	}
+
+	lastPostID := int64(0)
+	lastModified := time.Time{}
+	for _, post := range posts {
+		if post.ID > lastPostID {
+			lastPostID = post.ID
+		}
+		if post.PostedAt.After(lastModified) {
+			lastModified = post.PostedAt
+		}
+	}
+
+	s.lastModified(w, lastModified)
+
+	s.WritePage(w, r, "one-thread.html", map[string]interface{}{
+		"topic":      topic,
+		"thread":     thread,
+		"posts":      displayPosts,
+		"lastPostID": lastPostID,
 	})
 }
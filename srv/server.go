@@ -1,82 +1,276 @@
 package srv
 
 import (
-	"database/sql"
+	"bytes"
+	"context"
 	"fmt"
 	"html/template"
 	"io"
-	"log"
+	"io/fs"
 	"net/http"
+	"time"
+
+	"github.com/pdk/forum/action"
+	"github.com/pdk/forum/logging"
+	"github.com/pdk/forum/store"
 )
 
 // Server handles incoming HTTP requests.
 type Server struct {
-	DB        *sql.DB
+	action.Actions
+
 	AssetsDir string
+	Assets    fs.FS
 	Template  *template.Template
+	TLS       TLSOptions
+
+	// BaseURL is where the forum is served, for links and IDs in feeds. If
+	// blank, each request's scheme and host are used.
+	BaseURL string
+
+	// MetricsToken must be given as a bearer token to read /metrics, which is
+	// not served if it is blank.
+	MetricsToken string
+
+	// Cache is the store's cache, if it has one, for its stats.
+	Cache *store.CachedStore
+
+	// RequestTimeout limits each request, apart from live update streams. 0
+	// means no limit.
+	RequestTimeout time.Duration
+
+	// DevMode re-parses the templates when they change in AssetsDir, and
+	// shows template errors in the browser.
+	DevMode      bool
+	devTemplates *devTemplates
+
+	versions         *assetVersions
+	templatesVersion string
 }
 
-// NewServer construct and return a new Server.
-func NewServer(db *sql.DB, assetsDir string) (Server, error) {
+// NewServer construct and return a new Server. Templates and static files are
+// built in, but may be overridden by files in assetsDir, if it is not blank.
+func NewServer(st store.Store, assetsDir string) (Server, error) {
+
+	assets := AssetsFS(assetsDir)
 
-	templateGlob := assetsDir + "/templates/*.html"
-	log.Printf("reading & parsing templates in %s", templateGlob)
+	if assetsDir != "" {
+		logging.Default().Info("reading & parsing templates, and built in", "templates", assetsDir+"/"+templateGlob)
+	} else {
+		logging.Default().Info("reading & parsing built in templates")
+	}
+
+	versions := newAssetVersions(assets)
 
-	tmpl, err := template.ParseGlob(templateGlob)
+	tmpl, err := parseTemplates(assets, versions)
 	if err != nil {
-		return Server{}, fmt.Errorf("failed to compile templates from %s: %w", templateGlob, err)
+		return Server{}, err
 	}
 
 	for _, t := range tmpl.Templates() {
-		log.Printf("template ready: %s", t.Name())
+		if t.Tree == nil {
+			// the root, which only holds the template functions
+			continue
+		}
+		logging.Default().Debug("template ready", "template", t.Name())
 	}
 
 	return Server{
-		DB:        db,
+		Actions:   action.Actions{Store: st},
 		AssetsDir: assetsDir,
+		Assets:    assets,
 		Template:  tmpl,
+
+		devTemplates:     newDevTemplates(assets, assetsDir, tmpl, versions),
+		versions:         versions,
+		templatesVersion: templatesVersion(assets),
 	}, nil
 }
 
-// ListenAndServe sets up routes and kicks off HTTP listener.
-func (s Server) ListenAndServe(listenAddress string) {
+// Timeouts for the http.Server. Streaming responses (eg live updates) lift the
+// write timeout for themselves.
+const (
+	readHeaderTimeout = 10 * time.Second
+	readTimeout       = 30 * time.Second
+	writeTimeout      = 60 * time.Second
+	idleTimeout       = 120 * time.Second
+	shutdownTimeout   = 30 * time.Second
+)
+
+// Handler sets up the routes, and returns the handler for all requests.
+func (s Server) Handler() (http.Handler, error) {
+
+	mux := http.NewServeMux()
+
+	staticFS, err := fs.Sub(s.Assets, "static")
+	if err != nil {
+		return nil, fmt.Errorf("cannot find static files: %w", err)
+	}
 
-	static := http.FileServer(http.Dir(s.AssetsDir + "/static/"))
-	log.Printf("routing /css/, /js/, /img/ => %v", static)
-	http.Handle("/css/", static)
-	http.Handle("/js/", static)
-	http.Handle("/img/", static)
+	static := s.versions.cacheStatic(precompressed(staticFS, http.FileServer(http.FS(staticFS))))
+	logging.Default().Debug("routing", "path", "/css/, /js/, /img/")
+	mux.HandleFunc("/css/", s.instrument("/css/", static.ServeHTTP))
+	mux.HandleFunc("/js/", s.instrument("/js/", static.ServeHTTP))
+	mux.HandleFunc("/img/", s.instrument("/img/", static.ServeHTTP))
+
+	// feeds are public, since feed readers cannot sign in. These are
+	// instrumented by suffix, to tell pages, feeds and streams apart.
+	topicRoutes := BySuffix(s.instrument("/topics/{id}", s.OnlySignedIn(s.OneTopicPage)), map[string]http.HandlerFunc{
+		feedSuffix: s.instrument("/topics/{id}"+feedSuffix, s.TopicFeed),
+	})
+	threadRoutes := BySuffix(s.instrument("/threads/{id}", s.OnlySignedIn(s.OneThreadPage)), map[string]http.HandlerFunc{
+		feedSuffix:   s.instrument("/threads/{id}"+feedSuffix, s.ThreadFeed),
+		eventsSuffix: s.instrument("/threads/{id}"+eventsSuffix, s.OnlySignedIn(s.ThreadEvents)),
+	})
+	mux.HandleFunc("/topics/", topicRoutes)
+	mux.HandleFunc("/threads/", threadRoutes)
 
 	routes := map[string]http.HandlerFunc{
 		// just using map to make formatting easier to read
-		"/":           s.HomePage,
-		"/sign-in":    s.SignIn,
-		"/topics":     s.OnlySignedIn(s.TopicsPage),
-		"/add-topic":  s.OnlySignedIn(s.AddTopic),
-		"/topics/":    s.OnlySignedIn(s.OneTopicPage),
-		"/add-thread": s.OnlySignedIn(s.AddThread),
-		"/threads/":   s.OnlySignedIn(s.OneThreadPage),
-		"/add-post":   s.OnlySignedIn(s.AddPost),
+		"/":              s.HomePage,
+		"/sign-in":       s.SignIn,
+		"/topics":        s.OnlySignedIn(s.TopicsPage),
+		"/add-topic":     s.OnlySignedIn(s.AddTopic),
+		"/add-thread":    s.OnlySignedIn(s.AddThread),
+		"/add-post":      s.OnlySignedIn(s.AddPost),
+		"/feed.atom":     s.RecentPostsFeed,
+		"/tokens":        s.OnlySignedIn(s.OnlyCookie(s.TokensPage)),
+		"/tokens/add":    s.OnlySignedIn(s.OnlyCookie(s.AddToken)),
+		"/tokens/revoke": s.OnlySignedIn(s.OnlyCookie(s.RevokeToken)),
+		APIPrefix:        s.API,
+
+		"/admin/webhooks":        s.OnlyAdmin(s.WebhooksPage),
+		"/admin/webhooks/replay": s.OnlyAdmin(s.ReplayDelivery),
+		"/admin/cache":           s.OnlyAdmin(s.CachePage),
+	}
+
+	if s.Metrics != nil && s.MetricsToken != "" {
+		routes["/metrics"] = s.OnlyMetricsToken(s.Metrics.ServeHTTP)
 	}
 
 	for path, handler := range routes {
-		log.Printf("routing %s => %v", path, handler)
-		http.HandleFunc(path, handler)
+		logging.Default().Debug("routing", "path", path)
+		mux.HandleFunc(path, s.instrument(path, handler))
+	}
+
+	return logRequests(compress(withTimeout(s.RequestTimeout, mux))), nil
+}
+
+// ListenAndServe sets up routes and kicks off HTTP listener (HTTPS, if s.TLS
+// has a certificate, plus the optional redirect listener). It returns when ctx
+// is cancelled, after in-flight requests have finished (or the shutdown timeout
+// has passed). Live update streams are ended as the shutdown begins.
+func (s Server) ListenAndServe(ctx context.Context, listenAddress string) error {
+
+	handler, err := s.Handler()
+	if err != nil {
+		return err
+	}
+
+	httpServer := &http.Server{
+		Addr:              listenAddress,
+		Handler:           handler,
+		ReadHeaderTimeout: readHeaderTimeout,
+		ReadTimeout:       readTimeout,
+		WriteTimeout:      writeTimeout,
+		IdleTimeout:       idleTimeout,
 	}
+	httpServer.RegisterOnShutdown(s.Events.Close)
+
+	servers := []*http.Server{httpServer}
+	failed := make(chan error, 2)
+
+	if s.TLS.Enabled() {
+		certs, err := newCertReloader(s.TLS.CertFile, s.TLS.KeyFile)
+		if err != nil {
+			return err
+		}
+		go certs.watch(ctx)
+
+		httpServer.TLSConfig, err = s.TLS.config(certs)
+		if err != nil {
+			return err
+		}
+		httpServer.Handler = strictTransportSecurity(handler)
+
+		go func() {
+			logging.Default().Info("listening for HTTPS", "address", listenAddress)
+			// the certificate comes from TLSConfig.GetCertificate
+			failed <- httpServer.ListenAndServeTLS("", "")
+		}()
+
+		if s.TLS.RedirectAddress != "" {
+			redirectServer := &http.Server{
+				Addr:              s.TLS.RedirectAddress,
+				Handler:           redirectToHTTPS(listenAddress),
+				ReadHeaderTimeout: readHeaderTimeout,
+				ReadTimeout:       readTimeout,
+				WriteTimeout:      writeTimeout,
+				IdleTimeout:       idleTimeout,
+			}
+			servers = append(servers, redirectServer)
 
-	log.Printf("listening at %s", listenAddress)
-	log.Fatalf("server failed: %w",
-		http.ListenAndServe(listenAddress, nil))
+			go func() {
+				logging.Default().Info("redirecting HTTP to HTTPS", "address", s.TLS.RedirectAddress)
+				failed <- redirectServer.ListenAndServe()
+			}()
+		}
+	} else {
+		go func() {
+			logging.Default().Info("listening", "address", listenAddress)
+			failed <- httpServer.ListenAndServe()
+		}()
+	}
+
+	select {
+	case err = <-failed:
+		err = fmt.Errorf("server failed: %w", err)
+	case <-ctx.Done():
+	}
+
+	logging.Default().Info("shutting down, waiting for requests to finish", "timeout", shutdownTimeout)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	for _, server := range servers {
+		shutdownErr := server.Shutdown(shutdownCtx)
+		if shutdownErr != nil && err == nil {
+			err = fmt.Errorf("server shutdown failed: %w", shutdownErr)
+		}
+	}
+
+	return err
 }
 
 // WritePage executes a named template with the given data. This is meant to be
 // called by a page handler, and as the last thing done by page handlers,
-// there's nowhere to send an error, so we just log any errors here.
-func (s Server) WritePage(w io.Writer, name string, data interface{}) {
+// there's nowhere to send an error, so we just log any errors here, with the
+// request's logger. In dev mode, errors are also shown in the browser.
+func (s Server) WritePage(w io.Writer, r *http.Request, name string, data interface{}) {
 
-	err := s.Template.ExecuteTemplate(w, name, data)
+	start := time.Now()
+	defer func() {
+		s.observeRender(name, time.Since(start))
+	}()
+
+	tmpl, err := s.templates()
+	if err == nil && s.DevMode {
+		// render to a buffer, so a failure can replace the page.
+		buf := bytes.Buffer{}
+		err = tmpl.ExecuteTemplate(&buf, name, data)
+		if err == nil {
+			w.Write(buf.Bytes())
+		}
+	} else if err == nil {
+		err = tmpl.ExecuteTemplate(w, name, data)
+	}
 
 	if err != nil {
-		log.Printf("error executing template %s: %w", name, err)
+		logging.FromContext(r.Context()).Error("cannot execute template", "template", name, "error", err)
+
+		if s.DevMode {
+			s.writeTemplateError(w, err)
+		}
 	}
 }
@@ -0,0 +1,719 @@
+package srv_test
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/pdk/forum/broker"
+	"github.com/pdk/forum/logging"
+	"github.com/pdk/forum/metrics"
+	"github.com/pdk/forum/model"
+	"github.com/pdk/forum/srv"
+	"github.com/pdk/forum/store"
+)
+
+// newTestStore returns an empty sqlite database in memory.
+func newTestStore(t *testing.T) *store.SQLStore {
+
+	db, _ := store.Open(":memory:")
+	t.Cleanup(func() { db.Close() })
+
+	_, err := db.MigrateUp(context.Background())
+	if err != nil {
+		t.Fatalf("expected to migrate database, but failed: %v", err)
+	}
+
+	return db
+}
+
+func newTestServer(t *testing.T) (*httptest.Server, *http.Client) {
+
+	server, err := srv.NewServer(newTestStore(t), "")
+	if err != nil {
+		t.Fatalf("expected to create server, but failed: %v", err)
+	}
+	server.Events = broker.NewBroker(10)
+
+	handler, err := server.Handler()
+	if err != nil {
+		t.Fatalf("expected to get handler, but failed: %v", err)
+	}
+
+	ts := httptest.NewServer(handler)
+	t.Cleanup(ts.Close)
+
+	jar, _ := cookiejar.New(nil)
+
+	return ts, &http.Client{Jar: jar}
+}
+
+func get(t *testing.T, client *http.Client, url string) (int, string) {
+
+	resp, err := client.Get(url)
+	if err != nil {
+		t.Fatalf("expected to GET %s, but failed: %v", url, err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := ioutil.ReadAll(resp.Body)
+
+	return resp.StatusCode, string(body)
+}
+
+func post(t *testing.T, client *http.Client, url string, form url.Values) (int, string) {
+
+	resp, err := client.PostForm(url, form)
+	if err != nil {
+		t.Fatalf("expected to POST %s, but failed: %v", url, err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := ioutil.ReadAll(resp.Body)
+
+	return resp.StatusCode, string(body)
+}
+
+func TestSignInAndPost(t *testing.T) {
+
+	ts, client := newTestServer(t)
+
+	status, body := get(t, client, ts.URL+"/css/forum.css")
+	if status != http.StatusOK || !strings.Contains(body, ".main") {
+		t.Errorf("expected built in stylesheet, but got %d", status)
+	}
+
+	status, _ = get(t, client, ts.URL+"/no-such-page")
+	if status != http.StatusNotFound {
+		t.Errorf("expected 404, but got %d", status)
+	}
+
+	_, body = post(t, client, ts.URL+"/sign-in", url.Values{"name": {"pdk"}})
+	if !strings.Contains(body, "welcome, pdk!") {
+		t.Errorf("expected welcome page, but got %s", body)
+	}
+
+	_, body = post(t, client, ts.URL+"/add-topic", url.Values{"name": {"golang"}})
+	if !strings.Contains(body, `<a href="/topics/1">golang</a>`) {
+		t.Errorf("expected new topic page, but got %s", body)
+	}
+
+	_, body = post(t, client, ts.URL+"/add-topic", url.Values{"name": {"  "}})
+	if !strings.Contains(body, "new topic name must not be blank") {
+		t.Errorf("expected blank topic to be refused, but got %s", body)
+	}
+
+	_, body = post(t, client, ts.URL+"/add-thread", url.Values{
+		"topicID": {"1"},
+		"subject": {"hello"},
+		"body":    {"first post"},
+	})
+	if !strings.Contains(body, `<a href="/threads/1">hello</a>`) {
+		t.Errorf("expected new thread page, but got %s", body)
+	}
+
+	_, body = post(t, client, ts.URL+"/add-post", url.Values{
+		"threadID": {"1"},
+		"body":     {"second <post>"},
+	})
+	if !strings.Contains(body, "your comment was added") {
+		t.Errorf("expected new post page, but got %s", body)
+	}
+
+	_, body = get(t, client, ts.URL+"/threads/1")
+	if !strings.Contains(body, "first post") || !strings.Contains(body, "second &lt;post&gt;") {
+		t.Errorf("expected thread page to show both posts, but got %s", body)
+	}
+
+	status, body = get(t, client, ts.URL+"/api/v1/threads/1/posts")
+	if status != http.StatusOK || !strings.Contains(body, `"body":"second \u003cpost\u003e"`) {
+		t.Errorf("expected API to list posts, but got %d %s", status, body)
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+
+	server, err := srv.NewServer(newTestStore(t), "")
+	if err != nil {
+		t.Fatalf("expected to create server, but failed: %v", err)
+	}
+	server.RequestTimeout = time.Nanosecond
+
+	handler, err := server.Handler()
+	if err != nil {
+		t.Fatalf("expected to get handler, but failed: %v", err)
+	}
+
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/api/v1/topics", nil)
+	req.Header.Set("Authorization", "Bearer some-token")
+
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatalf("expected to GET topics, but failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := ioutil.ReadAll(resp.Body)
+
+	if status := resp.StatusCode; status != http.StatusServiceUnavailable || !strings.Contains(string(body), `"code":"timeout"`) {
+		t.Errorf("expected API to time out, but got %d %s", status, body)
+	}
+}
+
+func TestConditionalRequests(t *testing.T) {
+
+	ts, client := newTestServer(t)
+
+	post(t, client, ts.URL+"/sign-in", url.Values{"name": {"pdk"}})
+	post(t, client, ts.URL+"/add-topic", url.Values{"name": {"golang"}})
+
+	getIfNoneMatch := func(path, etag string) *http.Response {
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+path, nil)
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("expected to GET %s, but failed: %v", path, err)
+		}
+		resp.Body.Close()
+
+		return resp
+	}
+
+	resp := getIfNoneMatch("/topics", "")
+	etag := resp.Header.Get("ETag")
+	if resp.StatusCode != http.StatusOK || etag == "" {
+		t.Fatalf("expected topics page with an ETag, but got %d %q", resp.StatusCode, etag)
+	}
+
+	resp = getIfNoneMatch("/topics", etag)
+	if resp.StatusCode != http.StatusNotModified {
+		t.Errorf("expected 304 for the same ETag, but got %d", resp.StatusCode)
+	}
+
+	post(t, client, ts.URL+"/add-topic", url.Values{"name": {"rust"}})
+
+	resp = getIfNoneMatch("/topics", etag)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("ETag") == etag {
+		t.Errorf("expected a new topics page after adding a topic, but got %d %q", resp.StatusCode, resp.Header.Get("ETag"))
+	}
+
+	_, body := get(t, client, ts.URL+"/topics")
+	start := strings.Index(body, "/css/forum.css?v=")
+	if start < 0 {
+		t.Fatalf("expected a versioned stylesheet URL, but got %s", body)
+	}
+	versioned := body[start : start+strings.Index(body[start:], `"`)]
+
+	resp = getIfNoneMatch(versioned, "")
+	if cc := resp.Header.Get("Cache-Control"); !strings.Contains(cc, "immutable") {
+		t.Errorf("expected versioned stylesheet to be immutable, but got %q", cc)
+	}
+
+	resp = getIfNoneMatch("/css/forum.css", resp.Header.Get("ETag"))
+	if resp.StatusCode != http.StatusNotModified || strings.Contains(resp.Header.Get("Cache-Control"), "immutable") {
+		t.Errorf("expected 304 without immutable for plain stylesheet URL, but got %d %q", resp.StatusCode, resp.Header.Get("Cache-Control"))
+	}
+}
+
+func TestThreadValidatorAfterEdit(t *testing.T) {
+
+	ts, client := newTestServer(t)
+
+	post(t, client, ts.URL+"/sign-in", url.Values{"name": {"pdk"}})
+	post(t, client, ts.URL+"/add-topic", url.Values{"name": {"golang"}})
+	post(t, client, ts.URL+"/add-thread", url.Values{"topicID": {"1"}, "subject": {"hello"}, "body": {"first post"}})
+
+	resp, err := client.Get(ts.URL + "/threads/1")
+	if err != nil {
+		t.Fatalf("expected to GET thread, but failed: %v", err)
+	}
+	resp.Body.Close()
+	etag := resp.Header.Get("ETag")
+
+	req, _ := http.NewRequest(http.MethodPatch, ts.URL+"/api/v1/posts/1", strings.NewReader(`{"body":"edited post"}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatalf("expected to edit post, but failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected to edit post, but got %d", resp.StatusCode)
+	}
+
+	req, _ = http.NewRequest(http.MethodGet, ts.URL+"/threads/1", nil)
+	req.Header.Set("If-None-Match", etag)
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatalf("expected to GET thread, but failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), "edited post") {
+		t.Errorf("expected the edited thread page, but got %d %s", resp.StatusCode, body)
+	}
+}
+
+func TestPageForMissingUser(t *testing.T) {
+
+	ts, client := newTestServer(t)
+
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/topics", nil)
+	req.AddCookie(&http.Cookie{Name: "name", Value: "nobody"})
+
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("expected to GET topics, but failed: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusTemporaryRedirect || resp.Header.Get("Location") != "/" {
+		t.Errorf("expected a redirect to the front page, but got %d %q", resp.StatusCode, resp.Header.Get("Location"))
+	}
+}
+
+func TestFeed(t *testing.T) {
+
+	server, err := srv.NewServer(newTestStore(t), "")
+	if err != nil {
+		t.Fatalf("expected to create server, but failed: %v", err)
+	}
+	server.BaseURL = "https://forum.example.com/"
+
+	handler, err := server.Handler()
+	if err != nil {
+		t.Fatalf("expected to get handler, but failed: %v", err)
+	}
+
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	jar, _ := cookiejar.New(nil)
+	client := &http.Client{Jar: jar}
+
+	post(t, client, ts.URL+"/sign-in", url.Values{"name": {"pdk"}})
+	post(t, client, ts.URL+"/add-topic", url.Values{"name": {"golang"}})
+	post(t, client, ts.URL+"/add-thread", url.Values{"topicID": {"1"}, "subject": {"hello"}, "body": {"first post"}})
+
+	getFeed := func(etag string) (*http.Response, string) {
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+"/threads/1/feed.atom", nil)
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("expected to GET feed, but failed: %v", err)
+		}
+		defer resp.Body.Close()
+
+		body, _ := ioutil.ReadAll(resp.Body)
+
+		return resp, string(body)
+	}
+
+	resp, body := getFeed("")
+	etag := resp.Header.Get("ETag")
+	if resp.StatusCode != http.StatusOK || etag == "" {
+		t.Fatalf("expected a feed with an ETag, but got %d %q", resp.StatusCode, etag)
+	}
+	if !strings.Contains(body, "<id>https://forum.example.com/threads/1#post-1</id>") {
+		t.Errorf("expected entry IDs from the base URL, but got %s", body)
+	}
+
+	resp, _ = getFeed(etag)
+	if resp.StatusCode != http.StatusNotModified {
+		t.Errorf("expected 304 for the same ETag, but got %d", resp.StatusCode)
+	}
+
+	post(t, client, ts.URL+"/add-post", url.Values{"threadID": {"1"}, "body": {"second post"}})
+
+	resp, body = getFeed(etag)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(body, "second post") {
+		t.Errorf("expected the feed with the new post, but got %d %s", resp.StatusCode, body)
+	}
+	etag = resp.Header.Get("ETag")
+
+	status, _ := apiCall(t, client, http.MethodPatch, ts.URL+"/api/v1/posts/1", "application/json", `{"body":"edited post"}`)
+	if status != http.StatusOK {
+		t.Fatalf("expected to edit post, but got %d", status)
+	}
+
+	resp, body = getFeed(etag)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(body, "edited post") {
+		t.Errorf("expected the feed with the edited post, but got %d %s", resp.StatusCode, body)
+	}
+	if modified := resp.Header.Get("Last-Modified"); modified != "" {
+		t.Errorf("expected no Last-Modified, which edits would not change, but got %s", modified)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/threads/1/feed.atom", nil)
+	req.Header.Set("If-Modified-Since", time.Now().Add(time.Hour).UTC().Format(http.TimeFormat))
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("expected to GET feed, but failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected If-Modified-Since alone not to be answered 304, but got %d", resp.StatusCode)
+	}
+}
+
+func TestCompression(t *testing.T) {
+
+	assetsDir := t.TempDir()
+	os.MkdirAll(filepath.Join(assetsDir, "static", "css"), 0755)
+	os.WriteFile(filepath.Join(assetsDir, "static", "css", "forum.css.gz"), gzipped(t, "precompressed"), 0644)
+
+	server, err := srv.NewServer(newTestStore(t), assetsDir)
+	if err != nil {
+		t.Fatalf("expected to create server, but failed: %v", err)
+	}
+
+	handler, err := server.Handler()
+	if err != nil {
+		t.Fatalf("expected to get handler, but failed: %v", err)
+	}
+
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	getEncoded := func(path, acceptEncoding string) (string, string) {
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+path, nil)
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+
+		resp, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatalf("expected to GET %s, but failed: %v", path, err)
+		}
+		defer resp.Body.Close()
+
+		var body io.Reader = resp.Body
+		switch resp.Header.Get("Content-Encoding") {
+		case "br":
+			body = brotli.NewReader(resp.Body)
+		case "gzip":
+			body, err = gzip.NewReader(resp.Body)
+			if err != nil {
+				t.Fatalf("expected gzip from %s, but failed: %v", path, err)
+			}
+		}
+
+		content, err := ioutil.ReadAll(body)
+		if err != nil {
+			t.Fatalf("expected to read %s, but failed: %v", path, err)
+		}
+
+		return resp.Header.Get("Content-Encoding"), string(content)
+	}
+
+	_, plain := getEncoded("/css/main.css", "identity")
+
+	tests := []struct {
+		acceptEncoding string
+		expected       string
+	}{
+		{"identity", ""},
+		{"gzip", "gzip"},
+		{"gzip, deflate, br", "br"},
+		{"br;q=0.5, gzip", "gzip"},
+		{"*", "br"},
+		{"br;q=0, gzip;q=0", ""},
+	}
+
+	for _, test := range tests {
+		encoding, content := getEncoded("/css/main.css", test.acceptEncoding)
+		if encoding != test.expected || content != plain {
+			t.Errorf("expected %q encoding for %q, but got %q (content same: %t)", test.expected, test.acceptEncoding, encoding, content == plain)
+		}
+	}
+
+	encoding, content := getEncoded("/css/forum.css", "gzip")
+	if encoding != "gzip" || content != "precompressed" {
+		t.Errorf("expected the precompressed stylesheet, but got %q %q", encoding, content)
+	}
+
+	encoding, content = getEncoded("/css/forum.css", "br")
+	if encoding != "" || !strings.Contains(content, ".main") {
+		t.Errorf("expected the small stylesheet as it is, but got %q %q", encoding, content)
+	}
+}
+
+func gzipped(t *testing.T, content string) []byte {
+
+	buf := bytes.Buffer{}
+	gw := gzip.NewWriter(&buf)
+	gw.Write([]byte(content))
+	gw.Close()
+
+	return buf.Bytes()
+}
+
+func TestMetrics(t *testing.T) {
+
+	server, err := srv.NewServer(newTestStore(t), "")
+	if err != nil {
+		t.Fatalf("expected to create server, but failed: %v", err)
+	}
+	server.Metrics = metrics.NewRegistry()
+	server.MetricsToken = "scraper"
+
+	handler, err := server.Handler()
+	if err != nil {
+		t.Fatalf("expected to get handler, but failed: %v", err)
+	}
+
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	jar, _ := cookiejar.New(nil)
+	client := &http.Client{Jar: jar}
+
+	post(t, client, ts.URL+"/sign-in", url.Values{"name": {"pdk"}})
+	get(t, client, ts.URL+"/threads/1/feed.atom")
+
+	status, _ := get(t, client, ts.URL+"/metrics")
+	if status != http.StatusUnauthorized {
+		t.Errorf("expected metrics to need the token, but got %d", status)
+	}
+
+	resp := bearerCall(t, http.MethodGet, ts.URL+"/metrics", "wrong", "")
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected metrics to refuse the wrong token, but got %d", resp.StatusCode)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/metrics", nil)
+	req.Header.Set("Authorization", "Bearer scraper")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("expected to get metrics, but failed: %v", err)
+	}
+	defer resp.Body.Close()
+	content, _ := ioutil.ReadAll(resp.Body)
+	body := string(content)
+
+	for _, expected := range []string{
+		`forum_http_requests_total{route="/sign-in",method="POST",code="200"} 1`,
+		`forum_http_requests_total{route="/threads/{id}/feed.atom",method="GET",code="404"} 1`,
+		`forum_http_request_duration_seconds_count{route="/sign-in"} 1`,
+		`forum_template_render_seconds_count{template="welcome.html"} 1`,
+		`forum_sign_ins_total 1`,
+		`forum_users_joined_total 1`,
+	} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("expected metrics to include %s, but got\n%s", expected, body)
+		}
+	}
+}
+
+func TestMetricsWithoutToken(t *testing.T) {
+
+	server, err := srv.NewServer(newTestStore(t), "")
+	if err != nil {
+		t.Fatalf("expected to create server, but failed: %v", err)
+	}
+	server.Metrics = metrics.NewRegistry()
+
+	handler, err := server.Handler()
+	if err != nil {
+		t.Fatalf("expected to get handler, but failed: %v", err)
+	}
+
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	status, _ := get(t, ts.Client(), ts.URL+"/metrics")
+	if status != http.StatusNotFound {
+		t.Errorf("expected no metrics without a token, but got %d", status)
+	}
+}
+
+// brokenTopicsStore fails to list topics.
+type brokenTopicsStore struct {
+	store.Store
+}
+
+func (brokenTopicsStore) ListTopics(ctx context.Context, page store.Page) ([]model.Topic, error) {
+	return nil, errors.New("disk on fire")
+}
+
+func TestRequestID(t *testing.T) {
+
+	server, err := srv.NewServer(brokenTopicsStore{newTestStore(t)}, "")
+	if err != nil {
+		t.Fatalf("expected to create server, but failed: %v", err)
+	}
+
+	handler, err := server.Handler()
+	if err != nil {
+		t.Fatalf("expected to get handler, but failed: %v", err)
+	}
+
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	jar, _ := cookiejar.New(nil)
+	client := &http.Client{Jar: jar}
+
+	post(t, client, ts.URL+"/sign-in", url.Values{"name": {"pdk"}})
+
+	getWithID := func(id string) (*http.Response, string) {
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+"/topics", nil)
+		if id != "" {
+			req.Header.Set("X-Request-ID", id)
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("expected to GET topics, but failed: %v", err)
+		}
+		defer resp.Body.Close()
+
+		body, _ := ioutil.ReadAll(resp.Body)
+
+		return resp, string(body)
+	}
+
+	resp, body := getWithID("proxy-1234")
+	if id := resp.Header.Get("X-Request-ID"); id != "proxy-1234" {
+		t.Errorf("expected the request ID to be passed on, but got %q", id)
+	}
+	if resp.StatusCode != http.StatusInternalServerError || !strings.Contains(body, "request ID: proxy-1234") {
+		t.Errorf("expected the error page to show the request ID, but got %d %s", resp.StatusCode, body)
+	}
+
+	resp, _ = getWithID("not\tvalid")
+	if id := resp.Header.Get("X-Request-ID"); id == "" || id == "not\tvalid" {
+		t.Errorf("expected a new request ID, but got %q", id)
+	}
+}
+
+// syncBuffer is a bytes.Buffer which may be written by the server while the
+// test reads it.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.String()
+}
+
+func TestTemplateErrorLogged(t *testing.T) {
+
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"templates/home.html": "{{ template \"missing.html\" }}",
+	})
+
+	server, err := srv.NewServer(newTestStore(t), dir)
+	if err != nil {
+		t.Fatalf("expected to create server, but failed: %v", err)
+	}
+
+	handler, err := server.Handler()
+	if err != nil {
+		t.Fatalf("expected to get handler, but failed: %v", err)
+	}
+
+	logs := &syncBuffer{}
+	defer logging.SetDefault(logging.Default())
+	logging.SetDefault(logging.New(logs, logging.LogFmt, logging.Info))
+
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/", nil)
+	req.Header.Set("X-Request-ID", "page-1")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("expected to GET the home page, but failed: %v", err)
+	}
+	resp.Body.Close()
+
+	for _, line := range strings.Split(logs.String(), "\n") {
+		if strings.Contains(line, "cannot execute template") {
+			if !strings.Contains(line, "request_id=page-1") {
+				t.Errorf("expected the template error to have the request ID, but got %s", line)
+			}
+			return
+		}
+	}
+
+	t.Errorf("expected the template error to be logged, but got %s", logs.String())
+}
+
+func TestThreadEventsCatchUp(t *testing.T) {
+
+	ts, client := newTestServer(t)
+
+	post(t, client, ts.URL+"/sign-in", url.Values{"name": {"pdk"}})
+	post(t, client, ts.URL+"/add-topic", url.Values{"name": {"golang"}})
+	post(t, client, ts.URL+"/add-thread", url.Values{"topicID": {"1"}, "subject": {"hello"}, "body": {"first post"}})
+
+	_, body := get(t, client, ts.URL+"/threads/1")
+	if !strings.Contains(body, `data-events="/threads/1/events?lastEventId=1"`) {
+		t.Errorf("expected the stream to start after the last post shown, but got %s", body)
+	}
+
+	// posted after the page was shown, before the stream was opened.
+	post(t, client, ts.URL+"/add-post", url.Values{"threadID": {"1"}, "body": {"missed post"}})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+"/threads/1/events?lastEventId=1", nil)
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("expected to open the event stream, but failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		if scanner.Text() == "id: 2" {
+			return
+		}
+	}
+
+	t.Errorf("expected the missed post to be sent, but it was not: %v", scanner.Err())
+}
@@ -0,0 +1,181 @@
+package srv
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"html/template"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pdk/forum/logging"
+)
+
+// templateGlob matches the page templates within the assets.
+const templateGlob = "templates/*.html"
+
+// parseTemplates parses all the page templates. Templates may use {{ asset
+// "/css/forum.css" }} for the URL of a static file with its version.
+func parseTemplates(assets fs.FS, versions *assetVersions) (*template.Template, error) {
+
+	funcs := template.FuncMap{
+		"asset": versions.url,
+	}
+
+	tmpl, err := template.New("").Funcs(funcs).ParseFS(assets, templateGlob)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile templates from %s: %w", templateGlob, err)
+	}
+
+	return tmpl, nil
+}
+
+// devTemplates re-parses the templates whenever the files in the templates
+// directory change. This is only for development: it checks the files on every
+// page.
+type devTemplates struct {
+	assets   fs.FS
+	versions *assetVersions
+	dir      string
+
+	mu    sync.Mutex
+	stamp string
+	tmpl  *template.Template
+	err   error
+}
+
+func newDevTemplates(assets fs.FS, assetsDir string, tmpl *template.Template, versions *assetVersions) *devTemplates {
+
+	d := &devTemplates{
+		assets:   assets,
+		versions: versions,
+		tmpl:     tmpl,
+	}
+
+	if assetsDir != "" {
+		d.dir = filepath.Join(assetsDir, "templates")
+		d.stamp = d.currentStamp()
+	}
+
+	return d
+}
+
+// current returns the templates, re-parsing them first if any file has
+// changed. A parse error is returned until the files are fixed.
+func (d *devTemplates) current() (*template.Template, error) {
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.dir == "" {
+		return d.tmpl, nil
+	}
+
+	stamp := d.currentStamp()
+	if stamp == d.stamp {
+		return d.tmpl, d.err
+	}
+
+	d.stamp = stamp
+	logging.Default().Info("templates changed, re-parsing", "dir", d.dir)
+
+	tmpl, err := parseTemplates(d.assets, d.versions)
+	if err != nil {
+		d.err = err
+		return nil, err
+	}
+
+	d.tmpl, d.err = tmpl, nil
+
+	return d.tmpl, nil
+}
+
+// currentStamp summarizes the names, sizes and modification times of the
+// template files, so that any change can be noticed.
+func (d *devTemplates) currentStamp() string {
+
+	entries, err := os.ReadDir(d.dir)
+	if err != nil {
+		return err.Error()
+	}
+
+	sb := strings.Builder{}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		fmt.Fprintf(&sb, "%s:%d:%d;", entry.Name(), info.Size(), info.ModTime().UnixNano())
+	}
+
+	return sb.String()
+}
+
+// templates returns the page templates: in dev mode, the latest.
+func (s Server) templates() (*template.Template, error) {
+
+	if !s.DevMode || s.devTemplates == nil {
+		return s.Template, nil
+	}
+
+	return s.devTemplates.current()
+}
+
+// templateErrorLocation finds the template file name and line number in errors
+// from html/template, eg "template: one-thread.html:12: ...".
+var templateErrorLocation = regexp.MustCompile(`template: ([^:\s]+):(\d+)`)
+
+// writeTemplateError shows a template error in the browser, with the lines of
+// the template around where it happened. Only for dev mode.
+func (s Server) writeTemplateError(w io.Writer, err error) {
+
+	if rw, ok := w.(http.ResponseWriter); ok {
+		rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+		rw.WriteHeader(http.StatusInternalServerError)
+	}
+
+	fmt.Fprintf(w, "<!doctype html>\n<title>template error</title>\n")
+	fmt.Fprintf(w, "<h1>template error</h1>\n<pre style=\"color: red\">%s</pre>\n", html.EscapeString(err.Error()))
+
+	match := templateErrorLocation.FindStringSubmatch(err.Error())
+	if match == nil {
+		return
+	}
+
+	name := match[1]
+	line, _ := strconv.Atoi(match[2])
+
+	source, readErr := fs.ReadFile(s.Assets, "templates/"+name)
+	if readErr != nil {
+		return
+	}
+
+	lines := strings.Split(string(source), "\n")
+	first, last := line-5, line+5
+	if first < 1 {
+		first = 1
+	}
+	if last > len(lines) {
+		last = len(lines)
+	}
+
+	buf := bytes.Buffer{}
+	fmt.Fprintf(&buf, "<h2>%s, line %d</h2>\n<pre>", html.EscapeString(name), line)
+	for n := first; n <= last; n++ {
+		text := fmt.Sprintf("%4d  %s", n, html.EscapeString(lines[n-1]))
+		if n == line {
+			text = "<b style=\"background: #fdd\">" + text + "</b>"
+		}
+		buf.WriteString(text + "\n")
+	}
+	buf.WriteString("</pre>\n")
+
+	w.Write(buf.Bytes())
+}
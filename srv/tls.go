@@ -0,0 +1,206 @@
+package srv
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pdk/forum/logging"
+)
+
+// certCheckInterval is how often the certificate files are checked for
+// changes.
+const certCheckInterval = time.Minute
+
+// hstsHeader is sent on every HTTPS response, so browsers stay on HTTPS.
+const hstsHeader = "max-age=31536000"
+
+// TLSOptions configures HTTPS. TLS is on when CertFile is set.
+type TLSOptions struct {
+	CertFile string
+	KeyFile  string
+
+	// MinVersion is the oldest TLS version accepted, eg "1.2" (the default).
+	MinVersion string
+
+	// RedirectAddress, if set, is where to listen for plain HTTP requests,
+	// which are redirected to HTTPS.
+	RedirectAddress string
+}
+
+// Enabled returns true if HTTPS is configured.
+func (o TLSOptions) Enabled() bool {
+	return o.CertFile != ""
+}
+
+// ParseTLSVersion converts a version like "1.2" to the crypto/tls constant.
+// Blank means TLS 1.2.
+func ParseTLSVersion(version string) (uint16, error) {
+
+	switch version {
+	case "", "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "1.0":
+		return tls.VersionTLS10, nil
+	}
+
+	return 0, fmt.Errorf("unknown TLS version %s, expected 1.0, 1.1, 1.2 or 1.3", version)
+}
+
+func (o TLSOptions) config(certs *certReloader) (*tls.Config, error) {
+
+	minVersion, err := ParseTLSVersion(o.MinVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{
+		MinVersion:     minVersion,
+		GetCertificate: certs.GetCertificate,
+	}, nil
+}
+
+// certReloader holds the current certificate, and reloads it when the files
+// change or on SIGHUP, so that a renewed certificate is used without a
+// restart.
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu    sync.RWMutex
+	cert  *tls.Certificate
+	stamp string
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+
+	c := &certReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+
+	err := c.reload()
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// GetCertificate is for tls.Config.
+func (c *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.cert, nil
+}
+
+// reload loads the certificate and key. On failure, the previous certificate
+// remains in use.
+func (c *certReloader) reload() error {
+
+	stamp := c.currentStamp()
+
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("cannot load certificate %s and key %s: %w", c.certFile, c.keyFile, err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.cert = &cert
+	c.stamp = stamp
+
+	return nil
+}
+
+// currentStamp summarizes the modification times and sizes of the files.
+func (c *certReloader) currentStamp() string {
+
+	stamp := ""
+	for _, name := range []string{c.certFile, c.keyFile} {
+		info, err := os.Stat(name)
+		if err != nil {
+			return err.Error()
+		}
+		stamp += fmt.Sprintf("%d:%d;", info.Size(), info.ModTime().UnixNano())
+	}
+
+	return stamp
+}
+
+// watch reloads the certificate on SIGHUP, or when the files change, until ctx
+// is cancelled.
+func (c *certReloader) watch(ctx context.Context) {
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	ticker := time.NewTicker(certCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			logging.Default().Info("SIGHUP: reloading certificate", "file", c.certFile)
+		case <-ticker.C:
+			c.mu.RLock()
+			unchanged := c.stamp == c.currentStamp()
+			c.mu.RUnlock()
+			if unchanged {
+				continue
+			}
+			logging.Default().Info("certificate changed, reloading", "file", c.certFile)
+		}
+
+		err := c.reload()
+		if err != nil {
+			logging.Default().Warn("keeping the current certificate", "error", err)
+		}
+	}
+}
+
+// strictTransportSecurity sets the HSTS header on every response.
+func strictTransportSecurity(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Strict-Transport-Security", hstsHeader)
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// redirectToHTTPS redirects every request to the same URL on the HTTPS
+// listener.
+func redirectToHTTPS(httpsAddress string) http.Handler {
+
+	_, httpsPort, _ := net.SplitHostPort(httpsAddress)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		host := r.Host
+		if h, _, err := net.SplitHostPort(r.Host); err == nil {
+			host = h
+		}
+
+		if httpsPort != "" && httpsPort != "443" {
+			host = net.JoinHostPort(host, httpsPort)
+		}
+
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
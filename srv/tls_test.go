@@ -0,0 +1,191 @@
+package srv_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/pdk/forum/broker"
+	"github.com/pdk/forum/srv"
+)
+
+// writeCert writes a self signed certificate for 127.0.0.1, with the given
+// serial number, and its key, to cert.pem and key.pem in dir.
+func writeCert(t *testing.T, dir string, serial int64) (string, string) {
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("expected to generate key, but failed: %v", err)
+	}
+
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "forum test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("expected to create certificate, but failed: %v", err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("expected to marshal key, but failed: %v", err)
+	}
+
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+
+	// write the key last: the certificate alone does not match the old key.
+	err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	if err == nil {
+		err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	}
+	if err != nil {
+		t.Fatalf("expected to write certificate, but failed: %v", err)
+	}
+
+	return certFile, keyFile
+}
+
+// freeAddress returns a local address with a port which is not in use.
+func freeAddress(t *testing.T) string {
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("expected to find a free port, but failed: %v", err)
+	}
+	defer l.Close()
+
+	return l.Addr().String()
+}
+
+// getUntil repeats a GET until ok accepts the response, or a few seconds
+// pass, and returns the last response.
+func getUntil(t *testing.T, client *http.Client, url string, ok func(*http.Response) bool) *http.Response {
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		resp, err := client.Get(url)
+		if err == nil {
+			resp.Body.Close()
+			if ok(resp) {
+				return resp
+			}
+		}
+
+		if time.Now().After(deadline) {
+			if err != nil {
+				t.Fatalf("expected to GET %s, but failed: %v", url, err)
+			}
+			return resp
+		}
+
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestTLS(t *testing.T) {
+
+	// SIGHUP reloads the certificate. Catch it here too, so that it cannot
+	// stop the test before the server is watching for it.
+	hup := make(chan os.Signal, 10)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	dir := t.TempDir()
+	certFile, keyFile := writeCert(t, dir, 1)
+
+	httpsAddress := freeAddress(t)
+	redirectAddress := freeAddress(t)
+
+	server, err := srv.NewServer(newTestStore(t), "")
+	if err != nil {
+		t.Fatalf("expected to create server, but failed: %v", err)
+	}
+	server.Events = broker.NewBroker(10)
+	server.TLS = srv.TLSOptions{
+		CertFile:        certFile,
+		KeyFile:         keyFile,
+		RedirectAddress: redirectAddress,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- server.ListenAndServe(ctx, httpsAddress)
+	}()
+	defer func() {
+		cancel()
+		<-stopped
+	}()
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			// a new handshake for each request, to see the current certificate.
+			DisableKeepAlives: true,
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	serial := func(resp *http.Response) int64 {
+		if resp.TLS == nil || len(resp.TLS.PeerCertificates) == 0 {
+			return 0
+		}
+		return resp.TLS.PeerCertificates[0].SerialNumber.Int64()
+	}
+
+	resp := getUntil(t, client, "https://"+httpsAddress+"/", func(*http.Response) bool { return true })
+	if resp.StatusCode != http.StatusOK || serial(resp) != 1 {
+		t.Errorf("expected the first certificate, but got %d with serial %d", resp.StatusCode, serial(resp))
+	}
+	if hsts := resp.Header.Get("Strict-Transport-Security"); hsts == "" {
+		t.Errorf("expected an HSTS header on HTTPS responses")
+	}
+
+	resp = getUntil(t, client, "http://"+redirectAddress+"/topics?page=2", func(*http.Response) bool { return true })
+	_, httpsPort, _ := net.SplitHostPort(httpsAddress)
+	expected := "https://127.0.0.1:" + httpsPort + "/topics?page=2"
+	if resp.StatusCode != http.StatusMovedPermanently || resp.Header.Get("Location") != expected {
+		t.Errorf("expected a redirect to %s, but got %d %q", expected, resp.StatusCode, resp.Header.Get("Location"))
+	}
+	if hsts := resp.Header.Get("Strict-Transport-Security"); hsts != "" {
+		t.Errorf("expected no HSTS header over plain HTTP, but got %q", hsts)
+	}
+
+	writeCert(t, dir, 2)
+
+	process, _ := os.FindProcess(os.Getpid())
+	resp = getUntil(t, client, "https://"+httpsAddress+"/", func(resp *http.Response) bool {
+		if serial(resp) == 2 {
+			return true
+		}
+		process.Signal(syscall.SIGHUP)
+		return false
+	})
+	if serial(resp) != 2 {
+		t.Errorf("expected the renewed certificate after SIGHUP, but got serial %d", serial(resp))
+	}
+}
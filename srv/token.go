@@ -0,0 +1,220 @@
+package srv
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pdk/forum/action"
+	"github.com/pdk/forum/logging"
+	"github.com/pdk/forum/model"
+	"github.com/pdk/forum/store"
+)
+
+// tokenPrefix marks forum API tokens, so that they are easy to recognize (eg
+// by secret scanners).
+const tokenPrefix = "forum_"
+
+var (
+	// ErrInvalidToken indicates a bearer token which is unknown or expired.
+	ErrInvalidToken = fmt.Errorf("%w: invalid or expired token", ErrNotSignedIn)
+	// ErrInsufficientScope indicates a bearer token without the scope needed
+	// for the request.
+	ErrInsufficientScope = fmt.Errorf("%w: token lacks the required scope", action.ErrForbidden)
+)
+
+// newTokenSecret returns a new random token, and the hash which is stored.
+func newTokenSecret() (string, string, error) {
+
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", "", fmt.Errorf("cannot generate token: %w", err)
+	}
+
+	secret := tokenPrefix + base64.RawURLEncoding.EncodeToString(b)
+
+	return secret, hashToken(secret), nil
+}
+
+func hashToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// getBearerToken returns the token from an "Authorization: Bearer" header, or
+// "" if there is none.
+func getBearerToken(r *http.Request) string {
+
+	auth := r.Header.Get("Authorization")
+	if len(auth) < 7 || !strings.EqualFold(auth[:7], "bearer ") {
+		return ""
+	}
+
+	return strings.TrimSpace(auth[7:])
+}
+
+// requiredScope is the scope a token needs to make the request. Reading is
+// safe, anything else is a write.
+func requiredScope(r *http.Request) string {
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return model.ScopeRead
+	}
+
+	return model.ScopeWrite
+}
+
+// tokenUser looks up the user owning a bearer token, and checks that the token
+// may be used for the request.
+func tokenUser(st store.Store, r *http.Request, secret string) (model.User, error) {
+
+	token, err := st.GetAPITokenByHash(r.Context(), hashToken(secret))
+	if errors.Is(err, sql.ErrNoRows) {
+		return model.User{}, ErrInvalidToken
+	}
+
+	if err != nil {
+		return model.User{}, fmt.Errorf("cannot check token: %w", err)
+	}
+
+	now := time.Now()
+	if token.Expired(now) {
+		return model.User{}, ErrInvalidToken
+	}
+
+	if !token.HasScope(requiredScope(r)) {
+		return model.User{}, ErrInsufficientScope
+	}
+
+	err = st.TouchAPIToken(r.Context(), token.ID, now)
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("cannot record use of token", "token_id", token.ID, "error", err)
+	}
+
+	user, err := st.GetUserByID(r.Context(), token.UserID)
+	if err != nil {
+		return model.User{}, fmt.Errorf("cannot get user %d for token: %w", token.UserID, err)
+	}
+
+	return user, nil
+}
+
+// OnlyCookie will refuse requests authorized by a bearer token, eg so that a
+// token cannot be used to create more tokens.
+func (s Server) OnlyCookie(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		if getBearerToken(r) != "" {
+			http.Error(w, "not available to API tokens", http.StatusForbidden)
+			return
+		}
+
+		handler(w, r)
+	}
+}
+
+// TokensPage lists the current user's API tokens.
+func (s Server) TokensPage(w http.ResponseWriter, r *http.Request) {
+
+	user, err := CurrentUser(s.Store, r)
+	if handleError(w, r, "cannot get current user: %w", err) {
+		return
+	}
+
+	tokens, err := s.Store.QueryAPITokensByUserID(r.Context(), user.ID)
+	if handleError(w, r, "cannot get tokens: %w", err) {
+		return
+	}
+
+	s.WritePage(w, r, "tokens.html", map[string]interface{}{
+		"tokens": tokens,
+		"now":    time.Now(),
+	})
+}
+
+// AddToken creates an API token, and shows it to the user (once).
+func (s Server) AddToken(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user, err := CurrentUser(s.Store, r)
+	if handleError(w, r, "cannot get current user: %w", err) {
+		return
+	}
+
+	err = r.ParseForm()
+	if handleError(w, r, "cannot parse form: %w", err) {
+		return
+	}
+
+	var expiresAt time.Time
+	days := strings.TrimSpace(r.FormValue("expiresInDays"))
+	if days != "" {
+		n, err := strconv.Atoi(days)
+		if s.MaybeUserError(w, r, err != nil || n < 1, "expiry must be a whole number of days") {
+			return
+		}
+		expiresAt = time.Now().AddDate(0, 0, n)
+	}
+
+	secret, hash, err := newTokenSecret()
+	if handleError(w, r, "cannot create token: %w", err) {
+		return
+	}
+
+	token := model.NewAPIToken(user.ID, strings.TrimSpace(r.FormValue("name")), hash, r.Form["scopes"], expiresAt)
+	err = token.Validate()
+	if s.MaybeValidationError(w, r, err) {
+		return
+	}
+
+	token, err = s.Store.CreateAPIToken(r.Context(), token)
+	if handleError(w, r, "cannot save token: %w", err) {
+		return
+	}
+
+	s.WritePage(w, r, "new-token.html", map[string]interface{}{
+		"token":  token,
+		"secret": secret,
+	})
+}
+
+// RevokeToken deletes one of the current user's API tokens.
+func (s Server) RevokeToken(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user, err := CurrentUser(s.Store, r)
+	if handleError(w, r, "cannot get current user: %w", err) {
+		return
+	}
+
+	tokenIDString := r.FormValue("tokenID")
+	tokenID, err := strconv.ParseInt(tokenIDString, 10, 64)
+	if handleError(w, r, "cannot parse token id %s: %w", tokenIDString, err) {
+		return
+	}
+
+	err = s.Store.DeleteAPIToken(r.Context(), user.ID, tokenID)
+	if errorNotFound(w, r, err) || handleError(w, r, "cannot revoke token %d: %w", tokenID, err) {
+		return
+	}
+
+	http.Redirect(w, r, "/tokens", http.StatusSeeOther)
+}
@@ -0,0 +1,136 @@
+package srv_test
+
+import (
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// tokenSecret matches a new token on the new-token page.
+var tokenSecret = regexp.MustCompile(`<code>(forum_[A-Za-z0-9_-]+)</code>`)
+
+// addToken creates an API token with the given scopes, and returns its secret.
+func addToken(t *testing.T, client *http.Client, baseURL, name string, scopes ...string) string {
+
+	status, body := post(t, client, baseURL+"/tokens/add", url.Values{"name": {name}, "scopes": scopes})
+
+	match := tokenSecret.FindStringSubmatch(body)
+	if status != http.StatusOK || match == nil {
+		t.Fatalf("expected a new token, but got %d %s", status, body)
+	}
+
+	return match[1]
+}
+
+// bearerCall sends a request with a bearer token (and no cookie), with body as
+// JSON if it is not blank, and returns the response.
+func bearerCall(t *testing.T, method, url, token, body string) *http.Response {
+
+	req, _ := http.NewRequest(method, url, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("expected to %s %s, but failed: %v", method, url, err)
+	}
+	resp.Body.Close()
+
+	return resp
+}
+
+func TestTokenScopes(t *testing.T) {
+
+	ts, client := newTestServer(t)
+	api := ts.URL + "/api/v1"
+
+	post(t, client, ts.URL+"/sign-in", url.Values{"name": {"pdk"}})
+
+	reader := addToken(t, client, ts.URL, "reader", "read")
+	writer := addToken(t, client, ts.URL, "writer", "read", "write")
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		token  string
+		body   string
+		status int
+	}{
+		{"read with read token", http.MethodGet, "/api/v1/topics", reader, "", http.StatusOK},
+		{"write with read token", http.MethodPost, "/api/v1/topics", reader, `{"name":"golang"}`, http.StatusForbidden},
+		{"write with write token", http.MethodPost, "/api/v1/topics", writer, `{"name":"golang"}`, http.StatusCreated},
+		{"page with read token", http.MethodGet, "/topics", reader, "", http.StatusOK},
+		{"unknown token", http.MethodGet, "/api/v1/topics", "forum_nonsense", "", http.StatusUnauthorized},
+		{"tokens page with token", http.MethodGet, "/tokens", writer, "", http.StatusForbidden},
+		{"new token with token", http.MethodPost, "/tokens/add", writer, "", http.StatusForbidden},
+	}
+
+	for _, test := range tests {
+		resp := bearerCall(t, test.method, ts.URL+test.path, test.token, test.body)
+		if resp.StatusCode != test.status {
+			t.Errorf("expected %s to give %d, but got %d", test.name, test.status, resp.StatusCode)
+		}
+	}
+
+	resp := bearerCall(t, http.MethodGet, api+"/topics", "forum_nonsense", "")
+	if auth := resp.Header.Get("WWW-Authenticate"); !strings.HasPrefix(auth, "Bearer") {
+		t.Errorf("expected a Bearer challenge for an unknown token, but got %q", auth)
+	}
+
+	status, _ := get(t, client, ts.URL+"/tokens/add?name=prefetched&scopes=read")
+	if status != http.StatusMethodNotAllowed {
+		t.Errorf("expected a GET not to create a token, but got %d", status)
+	}
+
+	_, body := get(t, client, ts.URL+"/tokens")
+	if !strings.Contains(body, "reader") || !strings.Contains(body, "writer") || strings.Contains(body, reader) {
+		t.Errorf("expected both tokens listed without their secrets, but got %s", body)
+	}
+	if strings.Contains(body, "prefetched") {
+		t.Errorf("expected no token from a GET, but got %s", body)
+	}
+}
+
+func TestTokenRevoke(t *testing.T) {
+
+	ts, client := newTestServer(t)
+	api := ts.URL + "/api/v1"
+
+	post(t, client, ts.URL+"/sign-in", url.Values{"name": {"pdk"}})
+	token := addToken(t, client, ts.URL, "bot", "read")
+
+	resp := bearerCall(t, http.MethodGet, api+"/users/me", token, "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected the token to work, but got %d", resp.StatusCode)
+	}
+
+	jar, _ := cookiejar.New(nil)
+	other := &http.Client{Jar: jar}
+	post(t, other, ts.URL+"/sign-in", url.Values{"name": {"mallory"}})
+
+	status, _ := post(t, other, ts.URL+"/tokens/revoke", url.Values{"tokenID": {"1"}})
+	if status != http.StatusNotFound {
+		t.Errorf("expected another user to be unable to revoke the token, but got %d", status)
+	}
+
+	resp = bearerCall(t, http.MethodGet, api+"/users/me", token, "")
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected the token to still work, but got %d", resp.StatusCode)
+	}
+
+	status, _ = post(t, client, ts.URL+"/tokens/revoke", url.Values{"tokenID": {"1"}})
+	if status != http.StatusOK {
+		t.Errorf("expected to revoke the token, but got %d", status)
+	}
+
+	resp = bearerCall(t, http.MethodGet, api+"/users/me", token, "")
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected the revoked token to be refused, but got %d", resp.StatusCode)
+	}
+}
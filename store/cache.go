@@ -0,0 +1,460 @@
+package store
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pdk/forum/model"
+)
+
+// The kinds of results a CachedStore keeps. A write drops every result of the
+// kinds it may change.
+const (
+	cacheTopics  = "topics"
+	cacheThreads = "threads"
+	cachePosts   = "posts"
+	cacheUsers   = "users"
+)
+
+// CachedStore is a Store which keeps the results of the lookups made on every
+// page view: the list of topics, the threads of a topic, the posts of a
+// thread, and users by ID. Results are kept for up to ttl, and at most size
+// of them. Writes through the CachedStore drop the results they may change.
+// Writes made elsewhere (eg by another forum command) are seen once the ttl
+// has passed, or once a stamp (eg TopicsStamp) shows they have happened.
+type CachedStore struct {
+	Store
+	cache *cache
+
+	// written is the kinds written by a transaction, which are dropped when
+	// it is done. It is nil outside a transaction.
+	written map[string]bool
+}
+
+var _ Store = (*CachedStore)(nil)
+
+// CacheStats counts the lookups a CachedStore has answered, and what it
+// holds.
+type CacheStats struct {
+	Hits      int64
+	Misses    int64
+	Evictions int64
+	Entries   int
+	Size      int
+	TTL       time.Duration
+}
+
+// NewCachedStore returns a CachedStore in front of s, keeping up to size
+// results for up to ttl each.
+func NewCachedStore(s Store, size int, ttl time.Duration) *CachedStore {
+	return &CachedStore{
+		Store: s,
+		cache: &cache{
+			size:        size,
+			ttl:         ttl,
+			entries:     map[string]*list.Element{},
+			lru:         list.New(),
+			generations: map[string]int64{},
+			stamps:      map[string]string{},
+		},
+	}
+}
+
+// Stats returns the cache's counts so far.
+func (c *CachedStore) Stats() CacheStats {
+	return c.cache.stats()
+}
+
+// lookup returns the result for key from the cache, or else from load, which
+// is then kept. Inside a transaction, the cache is not used, since the
+// transaction may see its own writes.
+func (c *CachedStore) lookup(kind, key string, load func() (interface{}, error)) (interface{}, error) {
+
+	if c.written != nil {
+		return load()
+	}
+
+	value, ok := c.cache.get(key)
+	if ok {
+		return value, nil
+	}
+
+	generation := c.cache.generation(kind)
+
+	value, err := load()
+	if err != nil {
+		return nil, err
+	}
+
+	c.cache.put(kind, key, value, generation)
+
+	return value, nil
+}
+
+// drop drops the results of the kinds, or, in a transaction, notes them to be
+// dropped when it is done.
+func (c *CachedStore) drop(kinds ...string) {
+
+	if c.written != nil {
+		for _, kind := range kinds {
+			c.written[kind] = true
+		}
+		return
+	}
+
+	c.cache.invalidate(kinds...)
+}
+
+// checkStamp drops the results of the kinds if stamp is not the one last seen
+// for key (or none has been seen), so that a page whose validator is the new
+// stamp is not built from results cached before the change.
+func (c *CachedStore) checkStamp(key, stamp string, kinds ...string) {
+
+	if c.written != nil {
+		return
+	}
+
+	if c.cache.swapStamp(key, stamp) {
+		c.cache.invalidate(kinds...)
+	}
+}
+
+// WithTx runs fn in a transaction of the underlying store. Lookups in the
+// transaction are not cached, and what it writes is dropped from the cache
+// when it is done.
+func (c *CachedStore) WithTx(ctx context.Context, fn func(tx Store) error) error {
+
+	if c.written != nil {
+		return fn(c)
+	}
+
+	written := map[string]bool{}
+
+	err := c.Store.WithTx(ctx, func(tx Store) error {
+		return fn(&CachedStore{Store: tx, cache: c.cache, written: written})
+	})
+
+	for kind := range written {
+		c.cache.invalidate(kind)
+	}
+
+	return err
+}
+
+// ReadTx runs fn in a read only transaction of the underlying store, which
+// does not use the cache, since it may hold newer results than the
+// transaction sees.
+func (c *CachedStore) ReadTx(ctx context.Context, fn func(tx Store) error) error {
+	return c.Store.ReadTx(ctx, fn)
+}
+
+// GetUserByID returns the user, from the cache if possible.
+func (c *CachedStore) GetUserByID(ctx context.Context, userID int64) (model.User, error) {
+
+	value, err := c.lookup(cacheUsers, fmt.Sprintf("user:%d", userID), func() (interface{}, error) {
+		return c.Store.GetUserByID(ctx, userID)
+	})
+	if err != nil {
+		return model.User{}, err
+	}
+
+	return value.(model.User), nil
+}
+
+// UpdateUser updates the user, and drops the cached users.
+func (c *CachedStore) UpdateUser(ctx context.Context, user model.User) error {
+	defer c.drop(cacheUsers)
+	return c.Store.UpdateUser(ctx, user)
+}
+
+// ListTopics returns a page of topics, from the cache if possible.
+func (c *CachedStore) ListTopics(ctx context.Context, page Page) ([]model.Topic, error) {
+
+	value, err := c.lookup(cacheTopics, fmt.Sprintf("topics:%d:%d", page.Limit, page.Offset), func() (interface{}, error) {
+		return c.Store.ListTopics(ctx, page)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// a copy, so that callers cannot change what is cached.
+	return append([]model.Topic(nil), value.([]model.Topic)...), nil
+}
+
+// CreateTopic creates the topic, and drops the cached topics.
+func (c *CachedStore) CreateTopic(ctx context.Context, topic model.Topic) (model.Topic, error) {
+	defer c.drop(cacheTopics)
+	return c.Store.CreateTopic(ctx, topic)
+}
+
+// UpdateTopic updates the topic, and drops the cached topics.
+func (c *CachedStore) UpdateTopic(ctx context.Context, topic model.Topic) error {
+	defer c.drop(cacheTopics)
+	return c.Store.UpdateTopic(ctx, topic)
+}
+
+// DeleteTopic deletes the topic, and drops the cached topics and threads.
+func (c *CachedStore) DeleteTopic(ctx context.Context, topicID int64) error {
+	defer c.drop(cacheTopics, cacheThreads)
+	return c.Store.DeleteTopic(ctx, topicID)
+}
+
+// TopicsStamp returns the stamp of the topics, and drops the cached topics if
+// it has changed.
+func (c *CachedStore) TopicsStamp(ctx context.Context) (string, error) {
+
+	stamp, err := c.Store.TopicsStamp(ctx)
+	if err == nil {
+		c.checkStamp("topics", stamp, cacheTopics)
+	}
+
+	return stamp, err
+}
+
+// ListThreadsByTopicID returns a page of a topic's threads, from the cache if
+// possible.
+func (c *CachedStore) ListThreadsByTopicID(ctx context.Context, topicID int64, page Page) ([]model.Thread, error) {
+
+	value, err := c.lookup(cacheThreads, fmt.Sprintf("threads:%d:%d:%d", topicID, page.Limit, page.Offset), func() (interface{}, error) {
+		return c.Store.ListThreadsByTopicID(ctx, topicID, page)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]model.Thread(nil), value.([]model.Thread)...), nil
+}
+
+// ThreadsStamp returns the stamp of a topic's threads, and drops the cached
+// threads if it has changed.
+func (c *CachedStore) ThreadsStamp(ctx context.Context, topicID int64) (string, error) {
+
+	stamp, err := c.Store.ThreadsStamp(ctx, topicID)
+	if err == nil {
+		c.checkStamp(fmt.Sprintf("threads:%d", topicID), stamp, cacheThreads)
+	}
+
+	return stamp, err
+}
+
+// CreateThread creates the thread, and drops the cached threads.
+func (c *CachedStore) CreateThread(ctx context.Context, thread model.Thread) (model.Thread, error) {
+	defer c.drop(cacheThreads)
+	return c.Store.CreateThread(ctx, thread)
+}
+
+// UpdateThread updates the thread, and drops the cached threads.
+func (c *CachedStore) UpdateThread(ctx context.Context, thread model.Thread) error {
+	defer c.drop(cacheThreads)
+	return c.Store.UpdateThread(ctx, thread)
+}
+
+// ListPostsByThreadID returns a page of a thread's posts, from the cache if
+// possible.
+func (c *CachedStore) ListPostsByThreadID(ctx context.Context, threadID int64, page Page) ([]model.Post, error) {
+
+	value, err := c.lookup(cachePosts, fmt.Sprintf("posts:%d:%d:%d", threadID, page.Limit, page.Offset), func() (interface{}, error) {
+		return c.Store.ListPostsByThreadID(ctx, threadID, page)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]model.Post(nil), value.([]model.Post)...), nil
+}
+
+// PostsStamp returns the stamp of a thread's posts and their authors, and drops
+// the cached posts and users if it has changed.
+func (c *CachedStore) PostsStamp(ctx context.Context, threadID int64) (string, error) {
+
+	stamp, err := c.Store.PostsStamp(ctx, threadID)
+	if err == nil {
+		c.checkStamp(fmt.Sprintf("posts:%d", threadID), stamp, cachePosts, cacheUsers)
+	}
+
+	return stamp, err
+}
+
+// RecentPostsStamp returns the stamp of the recent posts, and drops the cached
+// users (whose names a feed shows) if it has changed.
+func (c *CachedStore) RecentPostsStamp(ctx context.Context, limit int) (string, error) {
+
+	stamp, err := c.Store.RecentPostsStamp(ctx, limit)
+	if err == nil {
+		c.checkStamp(fmt.Sprintf("recent:%d", limit), stamp, cacheUsers)
+	}
+
+	return stamp, err
+}
+
+// RecentPostsStampByTopicID returns the stamp of a topic's recent posts, and
+// drops the cached users if it has changed.
+func (c *CachedStore) RecentPostsStampByTopicID(ctx context.Context, topicID int64, limit int) (string, error) {
+
+	stamp, err := c.Store.RecentPostsStampByTopicID(ctx, topicID, limit)
+	if err == nil {
+		c.checkStamp(fmt.Sprintf("recent:%d:%d", topicID, limit), stamp, cacheUsers)
+	}
+
+	return stamp, err
+}
+
+// CreatePost creates the post, and drops the cached posts.
+func (c *CachedStore) CreatePost(ctx context.Context, post model.Post) (model.Post, error) {
+	defer c.drop(cachePosts)
+	return c.Store.CreatePost(ctx, post)
+}
+
+// UpdatePost updates the post, and drops the cached posts.
+func (c *CachedStore) UpdatePost(ctx context.Context, post model.Post) error {
+	defer c.drop(cachePosts)
+	return c.Store.UpdatePost(ctx, post)
+}
+
+// DeletePost deletes the post, and drops the cached posts.
+func (c *CachedStore) DeletePost(ctx context.Context, postID int64) error {
+	defer c.drop(cachePosts)
+	return c.Store.DeletePost(ctx, postID)
+}
+
+// cache is a least recently used cache, whose entries expire after ttl.
+type cache struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	entries map[string]*list.Element
+	lru     *list.List // of *cacheEntry, most recently used first
+
+	// generations counts the invalidations of each kind, so that a result
+	// loaded before an invalidation is not kept after it.
+	generations map[string]int64
+
+	// stamps is the stamp last seen for each list, by CachedStore.checkStamp.
+	stamps map[string]string
+
+	hits, misses, evictions int64
+}
+
+type cacheEntry struct {
+	key     string
+	kind    string
+	value   interface{}
+	expires time.Time
+}
+
+func (c *cache) get(key string) (interface{}, bool) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if ok && time.Now().After(elem.Value.(*cacheEntry).expires) {
+		c.remove(elem)
+		ok = false
+	}
+
+	if !ok {
+		c.misses++
+		return nil, false
+	}
+
+	c.hits++
+	c.lru.MoveToFront(elem)
+
+	return elem.Value.(*cacheEntry).value, true
+}
+
+func (c *cache) generation(kind string) int64 {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.generations[kind]
+}
+
+// put keeps a value loaded at the given generation of its kind, unless the
+// kind has been invalidated since.
+func (c *cache) put(kind, key string, value interface{}, generation int64) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.size < 1 || c.generations[kind] != generation {
+		return
+	}
+
+	if elem, ok := c.entries[key]; ok {
+		c.remove(elem)
+	}
+
+	c.entries[key] = c.lru.PushFront(&cacheEntry{
+		key:     key,
+		kind:    kind,
+		value:   value,
+		expires: time.Now().Add(c.ttl),
+	})
+
+	for c.lru.Len() > c.size {
+		c.remove(c.lru.Back())
+		c.evictions++
+	}
+}
+
+// swapStamp notes the stamp for key, and returns true if it is not the one
+// noted before.
+func (c *cache) swapStamp(key, stamp string) bool {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	previous, ok := c.stamps[key]
+	c.stamps[key] = stamp
+
+	return !ok || previous != stamp
+}
+
+// invalidate removes every entry of the kinds.
+func (c *cache) invalidate(kinds ...string) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, kind := range kinds {
+		c.generations[kind]++
+	}
+
+	for elem := c.lru.Front(); elem != nil; {
+		next := elem.Next()
+		for _, kind := range kinds {
+			if elem.Value.(*cacheEntry).kind == kind {
+				c.remove(elem)
+				break
+			}
+		}
+		elem = next
+	}
+}
+
+// remove removes an entry. The caller holds the lock.
+func (c *cache) remove(elem *list.Element) {
+	c.lru.Remove(elem)
+	delete(c.entries, elem.Value.(*cacheEntry).key)
+}
+
+func (c *cache) stats() CacheStats {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return CacheStats{
+		Hits:      c.hits,
+		Misses:    c.misses,
+		Evictions: c.evictions,
+		Entries:   c.lru.Len(),
+		Size:      c.size,
+		TTL:       c.ttl,
+	}
+}
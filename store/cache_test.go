@@ -0,0 +1,105 @@
+package store_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pdk/forum/model"
+	"github.com/pdk/forum/store"
+)
+
+func TestCachedStore(t *testing.T) {
+
+	ctx := context.Background()
+
+	mem := store.NewMemoryStore()
+	s := store.NewCachedStore(mem, 2, time.Minute)
+
+	pdk, _ := s.CreateUser(ctx, model.NewUser("pdk"))
+	s.CreateTopic(ctx, model.NewTopic(pdk.ID, "golang"))
+
+	s.ListTopics(ctx, store.AllRows)
+	topics, _ := s.ListTopics(ctx, store.AllRows)
+	if len(topics) != 1 {
+		t.Errorf("expected 1 topic, but got %v", topics)
+	}
+
+	stats := s.Stats()
+	if stats.Hits != 1 || stats.Misses != 1 || stats.Entries != 1 {
+		t.Errorf("expected 1 hit and 1 miss, but got %+v", stats)
+	}
+
+	// a write elsewhere is not seen until the ttl has passed.
+	mem.CreateTopic(ctx, model.NewTopic(pdk.ID, "rust"))
+	topics, _ = s.ListTopics(ctx, store.AllRows)
+	if len(topics) != 1 {
+		t.Errorf("expected the cached topic, but got %v", topics)
+	}
+
+	// a write through the cache is seen at once.
+	s.CreateTopic(ctx, model.NewTopic(pdk.ID, "zig"))
+	topics, _ = s.ListTopics(ctx, store.AllRows)
+	if len(topics) != 3 {
+		t.Errorf("expected 3 topics, but got %v", topics)
+	}
+
+	s.GetUserByID(ctx, pdk.ID)
+	s.ListThreadsByTopicID(ctx, topics[0].ID, store.AllRows)
+
+	stats = s.Stats()
+	if stats.Entries != 2 || stats.Evictions != 1 {
+		t.Errorf("expected 2 entries after 1 eviction, but got %+v", stats)
+	}
+}
+
+func TestCachedStoreStamps(t *testing.T) {
+
+	ctx := context.Background()
+
+	mem := store.NewMemoryStore()
+	s := store.NewCachedStore(mem, 10, time.Minute)
+
+	pdk, _ := s.CreateUser(ctx, model.NewUser("pdk"))
+	topic, _ := s.CreateTopic(ctx, model.NewTopic(pdk.ID, "golang"))
+	thread, _ := s.CreateThread(ctx, model.NewThread(topic.ID, pdk.ID, "hello"))
+	s.CreatePost(ctx, model.NewPost(thread.ID, pdk.ID, "first post"))
+
+	s.TopicsStamp(ctx)
+	s.ThreadsStamp(ctx, topic.ID)
+	s.PostsStamp(ctx, thread.ID)
+	s.ListTopics(ctx, store.AllRows)
+	s.ListThreadsByTopicID(ctx, topic.ID, store.AllRows)
+	s.ListPostsByThreadID(ctx, thread.ID, store.AllRows)
+
+	// writes elsewhere, seen in the stamps at once.
+	mem.CreateTopic(ctx, model.NewTopic(pdk.ID, "rust"))
+	mem.CreateThread(ctx, model.NewThread(topic.ID, pdk.ID, "again"))
+	mem.CreatePost(ctx, model.NewPost(thread.ID, pdk.ID, "second post"))
+
+	s.TopicsStamp(ctx)
+	topics, _ := s.ListTopics(ctx, store.AllRows)
+	if len(topics) != 2 {
+		t.Errorf("expected a new topics stamp to drop the cached topics, but got %v", topics)
+	}
+
+	s.ThreadsStamp(ctx, topic.ID)
+	threads, _ := s.ListThreadsByTopicID(ctx, topic.ID, store.AllRows)
+	if len(threads) != 2 {
+		t.Errorf("expected a new threads stamp to drop the cached threads, but got %v", threads)
+	}
+
+	s.PostsStamp(ctx, thread.ID)
+	posts, _ := s.ListPostsByThreadID(ctx, thread.ID, store.AllRows)
+	if len(posts) != 2 {
+		t.Errorf("expected a new posts stamp to drop the cached posts, but got %v", posts)
+	}
+
+	// an unchanged stamp keeps them.
+	before := s.Stats()
+	s.PostsStamp(ctx, thread.ID)
+	s.ListPostsByThreadID(ctx, thread.ID, store.AllRows)
+	if after := s.Stats(); after.Hits != before.Hits+1 {
+		t.Errorf("expected the cached posts after an unchanged stamp, but got %+v", after)
+	}
+}
@@ -1,14 +1,17 @@
 package store
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 
-	// Load the sqlite3 package so we can connect.
+	// Load the database drivers so we can connect.
+	_ "github.com/lib/pq"
 	_ "github.com/mattn/go-sqlite3"
 )
 
-// NewConnection returns a new sqlite3 database connection.
+// NewConnection returns a new sqlite3 database connection. See Open for a
+// Store.
 func NewConnection(sqliteConnectString string) (*sql.DB, error) {
 
 	db, err := sql.Open("sqlite3", sqliteConnectString)
@@ -18,3 +21,11 @@ func NewConnection(sqliteConnectString string) (*sql.DB, error) {
 
 	return db, nil
 }
+
+// Querier is satisfied by both *sql.DB and *sql.Tx, so that functions which
+// take one can be used inside or outside of a transaction.
+type Querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
@@ -0,0 +1,112 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pdk/forum/model"
+)
+
+// CreateDelivery will insert a Delivery into the database and return a modified Delivery (ie with a new ID).
+func (s *SQLStore) CreateDelivery(ctx context.Context, delivery model.Delivery) (model.Delivery, error) {
+
+	var err error
+	delivery.ID, err = s.insert(ctx, `insert into webhook_deliveries (event, hook, url, payload, status, attempts, response_code, last_error, created_at, updated_at) values (?,?,?,?,?,?,?,?,?,?)`,
+		delivery.Event, delivery.Hook, delivery.URL, delivery.Payload, delivery.Status, delivery.Attempts,
+		delivery.ResponseCode, delivery.LastError, delivery.CreatedAt, delivery.UpdatedAt)
+	if err != nil {
+		return delivery, fmt.Errorf("failed to save delivery of %s to %s: %w", delivery.Event, delivery.URL, err)
+	}
+
+	return delivery, nil
+}
+
+// UpdateDelivery saves the status, attempts and response details of a Delivery.
+func (s *SQLStore) UpdateDelivery(ctx context.Context, delivery model.Delivery) error {
+
+	_, err := s.exec(ctx, `update webhook_deliveries set status = ?, attempts = ?, response_code = ?, last_error = ?, updated_at = ? where id = ?`,
+		delivery.Status, delivery.Attempts, delivery.ResponseCode, delivery.LastError, delivery.UpdatedAt, delivery.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update delivery %d: %w", delivery.ID, err)
+	}
+
+	return nil
+}
+
+// GetDeliveryByID gets one delivery or returns sql.ErrNoRows
+func (s *SQLStore) GetDeliveryByID(ctx context.Context, deliveryID int64) (model.Delivery, error) {
+
+	d := model.Delivery{}
+	err := s.queryRow(ctx, `select id, event, hook, url, payload, status, attempts, response_code, last_error, created_at, updated_at from webhook_deliveries where id = ?`, deliveryID).
+		Scan(&d.ID, &d.Event, &d.Hook, &d.URL, &d.Payload, &d.Status, &d.Attempts, &d.ResponseCode, &d.LastError, &d.CreatedAt, &d.UpdatedAt)
+
+	if err != nil {
+		return d, fmt.Errorf("cannot get delivery %d: %w", deliveryID, err)
+	}
+
+	return d, nil
+}
+
+// QueryRecentDeliveries returns the most recent deliveries, newest first.
+func (s *SQLStore) QueryRecentDeliveries(ctx context.Context, limit int) ([]model.Delivery, error) {
+	return s.queryDeliveries(ctx, `select id, event, hook, url, payload, status, attempts, response_code, last_error, created_at, updated_at from webhook_deliveries order by id desc limit ?`, limit)
+}
+
+// QueryPendingDeliveries returns the deliveries which have not yet succeeded
+// or failed (pending, or being sent), oldest first.
+func (s *SQLStore) QueryPendingDeliveries(ctx context.Context) ([]model.Delivery, error) {
+	return s.queryDeliveries(ctx, `select id, event, hook, url, payload, status, attempts, response_code, last_error, created_at, updated_at from webhook_deliveries where status in (?, ?) order by id asc`, model.DeliveryPending, model.DeliverySending)
+}
+
+// SetDeliveryStatus changes the status of a delivery, only if it is one of
+// from. Returns false if it was not, eg because another worker got there
+// first.
+func (s *SQLStore) SetDeliveryStatus(ctx context.Context, deliveryID int64, status string, from ...string) (bool, error) {
+
+	args := []interface{}{status, time.Now(), deliveryID}
+	for _, f := range from {
+		args = append(args, f)
+	}
+
+	result, err := s.exec(ctx, `update webhook_deliveries set status = ?, updated_at = ? where id = ? and status in (`+placeholders(len(from))+`)`, args...)
+	if err != nil {
+		return false, fmt.Errorf("failed to set status of delivery %d: %w", deliveryID, err)
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to set status of delivery %d: %w", deliveryID, err)
+	}
+
+	return n == 1, nil
+}
+
+func (s *SQLStore) queryDeliveries(ctx context.Context, query string, args ...interface{}) ([]model.Delivery, error) {
+
+	deliveryList := []model.Delivery{}
+
+	rows, err := s.query(ctx, query, args...)
+	if err != nil {
+		return deliveryList, fmt.Errorf("failed to query deliveries: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		d := model.Delivery{}
+		err := rows.Scan(&d.ID, &d.Event, &d.Hook, &d.URL, &d.Payload, &d.Status, &d.Attempts, &d.ResponseCode, &d.LastError, &d.CreatedAt, &d.UpdatedAt)
+		if err != nil {
+			return deliveryList, fmt.Errorf("failed to scan a delivery: %w", err)
+		}
+
+		deliveryList = append(deliveryList, d)
+	}
+
+	return deliveryList, nil
+}
+
+// placeholders returns n comma separated placeholders, eg "?,?,?".
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?,", n), ",")
+}
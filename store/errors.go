@@ -0,0 +1,29 @@
+package store
+
+import (
+	"errors"
+
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+)
+
+// IsDuplicate returns true if the error is caused by a uniqueness constraint,
+// eg creating a second topic with the same name.
+func IsDuplicate(err error) bool {
+
+	if errors.Is(err, ErrDuplicate) {
+		return true
+	}
+
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "23505" // unique_violation
+	}
+
+	return false
+}
@@ -0,0 +1,179 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/pdk/forum/model"
+)
+
+// The kinds of record which can be imported.
+const (
+	KindUser   = "user"
+	KindTopic  = "topic"
+	KindThread = "thread"
+	KindPost   = "post"
+)
+
+// ImportBatch is a transaction for importing records from another forum. It
+// records the mapping from the source's IDs to the IDs given here, so that an
+// interrupted import can resume where it left off.
+type ImportBatch struct {
+	tx     *sql.Tx
+	s      *SQLStore
+	source string
+}
+
+// BeginImport starts a batch of records from the named source.
+func (s *SQLStore) BeginImport(ctx context.Context, source string) (*ImportBatch, error) {
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("cannot begin import: %w", err)
+	}
+
+	return &ImportBatch{
+		tx:     tx,
+		s:      s.inTx(tx),
+		source: source,
+	}, nil
+}
+
+// Commit saves the batch.
+func (b *ImportBatch) Commit() error {
+	return b.tx.Commit()
+}
+
+// Rollback abandons the batch.
+func (b *ImportBatch) Rollback() error {
+	return b.tx.Rollback()
+}
+
+// ImportedID returns the ID given to a record from the source, and false if it
+// has not been imported.
+func (b *ImportBatch) ImportedID(ctx context.Context, kind string, oldID int64) (int64, bool, error) {
+
+	var newID int64
+	err := b.s.queryRow(ctx, `select new_id from import_ids where source = ? and kind = ? and old_id = ?`, b.source, kind, oldID).
+		Scan(&newID)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, false, nil
+	}
+
+	if err != nil {
+		return 0, false, fmt.Errorf("cannot get imported id of %s %d: %w", kind, oldID, err)
+	}
+
+	return newID, true, nil
+}
+
+func (b *ImportBatch) recordID(ctx context.Context, kind string, oldID, newID int64) error {
+
+	_, err := b.s.exec(ctx, `insert into import_ids (source, kind, old_id, new_id) values (?,?,?,?)`, b.source, kind, oldID, newID)
+	if err != nil {
+		return fmt.Errorf("cannot record imported id of %s %d: %w", kind, oldID, err)
+	}
+
+	return nil
+}
+
+// ImportUser saves a user. If a user of the same name exists, and merge is
+// true, that user is used instead. If merge is false, the user is saved under
+// the first free name with a suffix, eg "bob (2)", so that an import never
+// takes over an existing account. The bool result is true if the user was
+// created.
+func (b *ImportBatch) ImportUser(ctx context.Context, oldID int64, user model.User, merge bool) (model.User, bool, error) {
+
+	existing, err := b.s.GetUserByName(ctx, user.Name)
+	if err == nil && merge {
+		return existing, false, b.recordID(ctx, KindUser, oldID, existing.ID)
+	}
+
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return user, false, fmt.Errorf("cannot get user %s: %w", user.Name, err)
+	}
+
+	if err == nil {
+		user.Name, err = b.freeName(ctx, user.Name)
+		if err != nil {
+			return user, false, err
+		}
+	}
+
+	user, err = b.s.CreateUser(ctx, user)
+	if err != nil {
+		return user, false, err
+	}
+
+	return user, true, b.recordID(ctx, KindUser, oldID, user.ID)
+}
+
+// freeName returns the first of name (2), name (3) ... which no user has,
+// shortening name if need be.
+func (b *ImportBatch) freeName(ctx context.Context, name string) (string, error) {
+
+	for n := 2; ; n++ {
+
+		suffix := fmt.Sprintf(" (%d)", n)
+		candidate := name
+		if utf8.RuneCountInString(name)+len(suffix) > model.MaxNameLength {
+			candidate = string([]rune(name)[:model.MaxNameLength-len(suffix)])
+		}
+		candidate += suffix
+
+		_, err := b.s.GetUserByName(ctx, candidate)
+		if errors.Is(err, sql.ErrNoRows) {
+			return candidate, nil
+		}
+		if err != nil {
+			return "", fmt.Errorf("cannot get user %s: %w", candidate, err)
+		}
+	}
+}
+
+// ImportTopic saves a topic, or uses the existing topic with the same name. The
+// bool result is true if the topic was created.
+func (b *ImportBatch) ImportTopic(ctx context.Context, oldID int64, topic model.Topic) (model.Topic, bool, error) {
+
+	existing, err := b.s.GetTopicByName(ctx, topic.Name)
+	if err == nil {
+		return existing, false, b.recordID(ctx, KindTopic, oldID, existing.ID)
+	}
+
+	if !errors.Is(err, sql.ErrNoRows) {
+		return topic, false, err
+	}
+
+	topic, err = b.s.CreateTopic(ctx, topic)
+	if err != nil {
+		return topic, false, err
+	}
+
+	return topic, true, b.recordID(ctx, KindTopic, oldID, topic.ID)
+}
+
+// ImportThread saves a thread.
+func (b *ImportBatch) ImportThread(ctx context.Context, oldID int64, thread model.Thread) (model.Thread, error) {
+
+	thread, err := b.s.CreateThread(ctx, thread)
+	if err != nil {
+		return thread, err
+	}
+
+	return thread, b.recordID(ctx, KindThread, oldID, thread.ID)
+}
+
+// ImportPost saves a post.
+func (b *ImportBatch) ImportPost(ctx context.Context, oldID int64, post model.Post) (model.Post, error) {
+
+	post, err := b.s.CreatePost(ctx, post)
+	if err != nil {
+		return post, err
+	}
+
+	return post, b.recordID(ctx, KindPost, oldID, post.ID)
+}
@@ -0,0 +1,968 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pdk/forum/model"
+)
+
+// ErrDuplicate is returned by MemoryStore when a uniqueness rule is broken,
+// as the database would with a unique constraint.
+var ErrDuplicate = errors.New("duplicate value")
+
+// MemoryStore is a Store kept in memory, with the same rules as SQLStore:
+// user names, topic names and token hashes are unique, IDs count up from 1,
+// and lists are ordered the same way. Foreign keys are not checked, and
+// nothing is saved, so it is for tests of the Store interface.
+type MemoryStore struct {
+	mu         sync.Mutex
+	inTx       bool
+	lastID     map[string]int64
+	users      []model.User
+	topics     []model.Topic
+	threads    []model.Thread
+	posts      []model.Post
+	tokens     []model.APIToken
+	deliveries []model.Delivery
+
+	// changes counts the writes to users, topics, threads and posts, for the
+	// stamps (see TopicsStamp).
+	changes int64
+}
+
+var _ Store = (*MemoryStore)(nil)
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{lastID: map[string]int64{}}
+}
+
+// lock locks the store for one call, and returns the function to unlock it.
+// A transaction holds the lock throughout, so its calls do not lock. As with
+// a database, nothing is done once ctx is done.
+func (m *MemoryStore) lock(ctx context.Context) (func(), error) {
+
+	err := ctx.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	if m.inTx {
+		return func() {}, nil
+	}
+
+	m.mu.Lock()
+	return m.mu.Unlock, nil
+}
+
+// WithTx runs fn with a copy of the store, which replaces the store if fn
+// succeeds, and ctx is not done. Other calls wait until the transaction is
+// done.
+func (m *MemoryStore) WithTx(ctx context.Context, fn func(tx Store) error) error {
+
+	if m.inTx {
+		return fn(m)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tx := m.copyForTx()
+
+	err := fn(tx)
+	if err == nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		return err
+	}
+
+	m.lastID, m.users, m.topics, m.threads = tx.lastID, tx.users, tx.topics, tx.threads
+	m.posts, m.tokens, m.deliveries = tx.posts, tx.tokens, tx.deliveries
+	m.changes = tx.changes
+
+	return nil
+}
+
+// ReadTx runs fn with a copy of the store, which is then thrown away. Other
+// calls wait until fn is done.
+func (m *MemoryStore) ReadTx(ctx context.Context, fn func(tx Store) error) error {
+
+	if m.inTx {
+		return fn(m)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return fn(m.copyForTx())
+}
+
+// copyForTx returns a copy of the store, for a transaction. The caller holds
+// the lock.
+func (m *MemoryStore) copyForTx() *MemoryStore {
+
+	tx := &MemoryStore{
+		inTx:       true,
+		lastID:     map[string]int64{},
+		users:      append([]model.User{}, m.users...),
+		topics:     append([]model.Topic{}, m.topics...),
+		threads:    append([]model.Thread{}, m.threads...),
+		posts:      append([]model.Post{}, m.posts...),
+		tokens:     append([]model.APIToken{}, m.tokens...),
+		deliveries: append([]model.Delivery{}, m.deliveries...),
+		changes:    m.changes,
+	}
+	for table, id := range m.lastID {
+		tx.lastID[table] = id
+	}
+
+	return tx
+}
+
+func (m *MemoryStore) nextID(table string) int64 {
+	m.lastID[table]++
+	return m.lastID[table]
+}
+
+// window returns the start and end indexes of a page of n rows.
+func window(n int, page Page) (int, int) {
+
+	start := page.Offset
+	if start > n {
+		start = n
+	}
+
+	end := n
+	if page.Limit >= 0 && start+page.Limit < n {
+		end = start + page.Limit
+	}
+
+	return start, end
+}
+
+// CreateUser saves a new user, giving it an ID.
+func (m *MemoryStore) CreateUser(ctx context.Context, user model.User) (model.User, error) {
+
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return model.User{}, err
+	}
+	defer unlock()
+
+	for _, u := range m.users {
+		if u.Name == user.Name {
+			return user, fmt.Errorf("failed to save user %s: %w", user.Name, ErrDuplicate)
+		}
+	}
+
+	user.ID = m.nextID("users")
+	m.users = append(m.users, user)
+	m.changes++
+
+	return user, nil
+}
+
+// GetUserByID returns a user, or sql.ErrNoRows.
+func (m *MemoryStore) GetUserByID(ctx context.Context, userID int64) (model.User, error) {
+
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return model.User{}, err
+	}
+	defer unlock()
+
+	for _, u := range m.users {
+		if u.ID == userID {
+			return u, nil
+		}
+	}
+
+	return model.User{}, sql.ErrNoRows
+}
+
+// GetUserByName returns a user, or sql.ErrNoRows.
+func (m *MemoryStore) GetUserByName(ctx context.Context, name string) (model.User, error) {
+
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return model.User{}, err
+	}
+	defer unlock()
+
+	for _, u := range m.users {
+		if u.Name == name {
+			return u, nil
+		}
+	}
+
+	return model.User{}, sql.ErrNoRows
+}
+
+// GetOrCreateUserByName returns the user with the given name, creating it if
+// need be. The bool result is true if the user was created.
+func (m *MemoryStore) GetOrCreateUserByName(ctx context.Context, name string) (model.User, bool, error) {
+
+	user, err := m.GetUserByName(ctx, name)
+	if err == nil {
+		return user, false, nil
+	}
+
+	user, err = m.CreateUser(ctx, model.NewUser(name))
+	if err != nil {
+		return user, false, fmt.Errorf("failed to get/create user %s: %w", name, err)
+	}
+
+	return user, true, nil
+}
+
+// ListUsers returns one page of the users, in the order they joined.
+func (m *MemoryStore) ListUsers(ctx context.Context, page Page) ([]model.User, error) {
+
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	start, end := window(len(m.users), page)
+
+	return append([]model.User{}, m.users[start:end]...), nil
+}
+
+// UpdateUser saves the name, role and ban of an existing user.
+func (m *MemoryStore) UpdateUser(ctx context.Context, user model.User) error {
+
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	for _, u := range m.users {
+		if u.Name == user.Name && u.ID != user.ID {
+			return fmt.Errorf("failed to update user %d: %w", user.ID, ErrDuplicate)
+		}
+	}
+
+	for i, u := range m.users {
+		if u.ID == user.ID {
+			m.users[i].Name = user.Name
+			m.users[i].Role = user.Role
+			m.users[i].Banned = user.Banned
+		}
+	}
+	m.changes++
+
+	return nil
+}
+
+// CreateTopic saves a new topic, giving it an ID.
+func (m *MemoryStore) CreateTopic(ctx context.Context, topic model.Topic) (model.Topic, error) {
+
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return model.Topic{}, err
+	}
+	defer unlock()
+
+	for _, t := range m.topics {
+		if t.Name == topic.Name {
+			return topic, fmt.Errorf("failed to save topic %s: %w", topic.Name, ErrDuplicate)
+		}
+	}
+
+	topic.ID = m.nextID("topics")
+	m.topics = append(m.topics, topic)
+	m.changes++
+
+	return topic, nil
+}
+
+// GetTopicByID gets one topic or returns sql.ErrNoRows
+func (m *MemoryStore) GetTopicByID(ctx context.Context, topicID int64) (model.Topic, error) {
+
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return model.Topic{}, err
+	}
+	defer unlock()
+
+	for _, t := range m.topics {
+		if t.ID == topicID {
+			return t, nil
+		}
+	}
+
+	return model.Topic{}, fmt.Errorf("cannot get topic %d: %w", topicID, sql.ErrNoRows)
+}
+
+// GetTopicByName gets one topic or returns sql.ErrNoRows
+func (m *MemoryStore) GetTopicByName(ctx context.Context, name string) (model.Topic, error) {
+
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return model.Topic{}, err
+	}
+	defer unlock()
+
+	for _, t := range m.topics {
+		if t.Name == name {
+			return t, nil
+		}
+	}
+
+	return model.Topic{}, fmt.Errorf("cannot get topic %s: %w", name, sql.ErrNoRows)
+}
+
+// ListTopics returns one page of the topics, ordered by name ignoring case.
+func (m *MemoryStore) ListTopics(ctx context.Context, page Page) ([]model.Topic, error) {
+
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	topicList := append([]model.Topic{}, m.topics...)
+	sort.SliceStable(topicList, func(i, j int) bool {
+		a, b := upper(topicList[i].Name), upper(topicList[j].Name)
+		if a != b {
+			return a < b
+		}
+		return topicList[i].ID < topicList[j].ID
+	})
+
+	start, end := window(len(topicList), page)
+
+	return topicList[start:end], nil
+}
+
+// TopicsStamp returns a count of the writes to the forum, which changes
+// whenever the topics do, if not only then.
+func (m *MemoryStore) TopicsStamp(ctx context.Context) (string, error) {
+
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+
+	return strconv.FormatInt(m.changes, 10), nil
+}
+
+// upper is sqlite's upper(), which only changes ASCII letters.
+func upper(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' {
+			return r - 'a' + 'A'
+		}
+		return r
+	}, s)
+}
+
+// UpdateTopic saves the name of an existing topic.
+func (m *MemoryStore) UpdateTopic(ctx context.Context, topic model.Topic) error {
+
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	for _, t := range m.topics {
+		if t.Name == topic.Name && t.ID != topic.ID {
+			return fmt.Errorf("failed to update topic %d: %w", topic.ID, ErrDuplicate)
+		}
+	}
+
+	for i, t := range m.topics {
+		if t.ID == topic.ID {
+			m.topics[i].Name = topic.Name
+		}
+	}
+	m.changes++
+
+	return nil
+}
+
+// DeleteTopic deletes a topic, along with all of its threads and their posts.
+// Returns sql.ErrNoRows if there is no such topic.
+func (m *MemoryStore) DeleteTopic(ctx context.Context, topicID int64) error {
+
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	found := false
+	topics := m.topics[:0]
+	for _, t := range m.topics {
+		if t.ID == topicID {
+			found = true
+			continue
+		}
+		topics = append(topics, t)
+	}
+
+	if !found {
+		return fmt.Errorf("cannot delete topic %d: %w", topicID, sql.ErrNoRows)
+	}
+	m.topics = topics
+
+	deleted := map[int64]bool{}
+	threads := m.threads[:0]
+	for _, t := range m.threads {
+		if t.TopicID == topicID {
+			deleted[t.ID] = true
+			continue
+		}
+		threads = append(threads, t)
+	}
+	m.threads = threads
+
+	posts := m.posts[:0]
+	for _, p := range m.posts {
+		if !deleted[p.ThreadID] {
+			posts = append(posts, p)
+		}
+	}
+	m.posts = posts
+	m.changes++
+
+	return nil
+}
+
+// CreateThread saves a new thread, giving it an ID.
+func (m *MemoryStore) CreateThread(ctx context.Context, thread model.Thread) (model.Thread, error) {
+
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return model.Thread{}, err
+	}
+	defer unlock()
+
+	thread.ID = m.nextID("threads")
+	m.threads = append(m.threads, thread)
+	m.changes++
+
+	return thread, nil
+}
+
+// GetThreadByID gets one thread or returns sql.ErrNoRows
+func (m *MemoryStore) GetThreadByID(ctx context.Context, threadID int64) (model.Thread, error) {
+
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return model.Thread{}, err
+	}
+	defer unlock()
+
+	for _, t := range m.threads {
+		if t.ID == threadID {
+			return t, nil
+		}
+	}
+
+	return model.Thread{}, fmt.Errorf("cannot get thread %d: %w", threadID, sql.ErrNoRows)
+}
+
+// GetThreadForUpdate gets one thread or returns sql.ErrNoRows. A transaction
+// holds the lock throughout, so nothing else can change the thread meanwhile.
+func (m *MemoryStore) GetThreadForUpdate(ctx context.Context, threadID int64) (model.Thread, error) {
+	return m.GetThreadByID(ctx, threadID)
+}
+
+// ListThreadsByTopicID returns one page of the threads for a topic, newest
+// first.
+func (m *MemoryStore) ListThreadsByTopicID(ctx context.Context, topicID int64, page Page) ([]model.Thread, error) {
+
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	threadList := []model.Thread{}
+	for i := len(m.threads) - 1; i >= 0; i-- {
+		if m.threads[i].TopicID == topicID {
+			threadList = append(threadList, m.threads[i])
+		}
+	}
+
+	start, end := window(len(threadList), page)
+
+	return threadList[start:end], nil
+}
+
+// ThreadsStamp returns a count of the writes to the forum, which changes
+// whenever the threads of a topic do, if not only then.
+func (m *MemoryStore) ThreadsStamp(ctx context.Context, topicID int64) (string, error) {
+
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+
+	return strconv.FormatInt(m.changes, 10), nil
+}
+
+// UpdateThread saves the topic, subject and lock of an existing thread.
+func (m *MemoryStore) UpdateThread(ctx context.Context, thread model.Thread) error {
+
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	for i, t := range m.threads {
+		if t.ID == thread.ID {
+			m.threads[i].TopicID = thread.TopicID
+			m.threads[i].Subject = thread.Subject
+			m.threads[i].Locked = thread.Locked
+		}
+	}
+	m.changes++
+
+	return nil
+}
+
+// CreatePost saves a new post, giving it an ID.
+func (m *MemoryStore) CreatePost(ctx context.Context, post model.Post) (model.Post, error) {
+
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return model.Post{}, err
+	}
+	defer unlock()
+
+	post.ID = m.nextID("posts")
+	m.posts = append(m.posts, post)
+	m.changes++
+
+	return post, nil
+}
+
+// GetPostByID gets one post or returns sql.ErrNoRows
+func (m *MemoryStore) GetPostByID(ctx context.Context, postID int64) (model.Post, error) {
+
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return model.Post{}, err
+	}
+	defer unlock()
+
+	for _, p := range m.posts {
+		if p.ID == postID {
+			return p, nil
+		}
+	}
+
+	return model.Post{}, fmt.Errorf("cannot get post %d: %w", postID, sql.ErrNoRows)
+}
+
+// filterPosts returns the posts matching keep, oldest first, or newest first
+// if reversed.
+func (m *MemoryStore) filterPosts(keep func(p model.Post) bool, reversed bool) []model.Post {
+
+	postList := []model.Post{}
+	for i := range m.posts {
+		p := m.posts[i]
+		if reversed {
+			p = m.posts[len(m.posts)-1-i]
+		}
+		if keep(p) {
+			postList = append(postList, p)
+		}
+	}
+
+	return postList
+}
+
+// limited returns up to limit posts. A negative limit means all of them, as
+// with sqlite.
+func limited(postList []model.Post, limit int) []model.Post {
+
+	_, end := window(len(postList), Page{Limit: limit})
+
+	return postList[:end]
+}
+
+// ListPostsByThreadID returns one page of the posts for a given thread, oldest
+// first.
+func (m *MemoryStore) ListPostsByThreadID(ctx context.Context, threadID int64, page Page) ([]model.Post, error) {
+
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	postList := m.filterPosts(func(p model.Post) bool { return p.ThreadID == threadID }, false)
+	start, end := window(len(postList), page)
+
+	return postList[start:end], nil
+}
+
+// QueryRecentPosts returns the most recent posts in the whole forum, newest
+// first.
+func (m *MemoryStore) QueryRecentPosts(ctx context.Context, limit int) ([]model.Post, error) {
+
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	return limited(m.filterPosts(func(p model.Post) bool { return true }, true), limit), nil
+}
+
+// QueryRecentPostsByTopicID returns the most recent posts in any thread of a
+// topic, newest first.
+func (m *MemoryStore) QueryRecentPostsByTopicID(ctx context.Context, topicID int64, limit int) ([]model.Post, error) {
+
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	inTopic := map[int64]bool{}
+	for _, t := range m.threads {
+		if t.TopicID == topicID {
+			inTopic[t.ID] = true
+		}
+	}
+
+	return limited(m.filterPosts(func(p model.Post) bool { return inTopic[p.ThreadID] }, true), limit), nil
+}
+
+// QueryRecentPostsByThreadID returns the most recent posts in a thread, newest
+// first.
+func (m *MemoryStore) QueryRecentPostsByThreadID(ctx context.Context, threadID int64, limit int) ([]model.Post, error) {
+
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	return limited(m.filterPosts(func(p model.Post) bool { return p.ThreadID == threadID }, true), limit), nil
+}
+
+// QueryPostsAfterID returns the posts in a thread which are newer than the
+// given post ID, oldest first.
+func (m *MemoryStore) QueryPostsAfterID(ctx context.Context, threadID, afterID int64, limit int) ([]model.Post, error) {
+
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	return limited(m.filterPosts(func(p model.Post) bool { return p.ThreadID == threadID && p.ID > afterID }, false), limit), nil
+}
+
+// PostsStamp returns a count of the writes to the forum, which changes
+// whenever the posts of a thread, or their posters, do, if not only then.
+func (m *MemoryStore) PostsStamp(ctx context.Context, threadID int64) (string, error) {
+
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+
+	return strconv.FormatInt(m.changes, 10), nil
+}
+
+// RecentPostsStamp returns a count of the writes to the forum, which changes
+// whenever the recent posts do, if not only then.
+func (m *MemoryStore) RecentPostsStamp(ctx context.Context, limit int) (string, error) {
+
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+
+	return strconv.FormatInt(m.changes, 10), nil
+}
+
+// RecentPostsStampByTopicID returns a count of the writes to the forum, which
+// changes whenever the recent posts of a topic do, if not only then.
+func (m *MemoryStore) RecentPostsStampByTopicID(ctx context.Context, topicID int64, limit int) (string, error) {
+
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+
+	return strconv.FormatInt(m.changes, 10), nil
+}
+
+// UpdatePost saves the body of an existing post.
+func (m *MemoryStore) UpdatePost(ctx context.Context, post model.Post) error {
+
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	for i, p := range m.posts {
+		if p.ID == post.ID {
+			m.posts[i].Body = post.Body
+		}
+	}
+	m.changes++
+
+	return nil
+}
+
+// DeletePost deletes one post. Returns sql.ErrNoRows if there is no such post.
+func (m *MemoryStore) DeletePost(ctx context.Context, postID int64) error {
+
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	for i, p := range m.posts {
+		if p.ID == postID {
+			m.posts = append(m.posts[:i], m.posts[i+1:]...)
+			m.changes++
+			return nil
+		}
+	}
+
+	return fmt.Errorf("cannot delete post %d: %w", postID, sql.ErrNoRows)
+}
+
+// CreateAPIToken saves a new token, giving it an ID.
+func (m *MemoryStore) CreateAPIToken(ctx context.Context, token model.APIToken) (model.APIToken, error) {
+
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return model.APIToken{}, err
+	}
+	defer unlock()
+
+	for _, t := range m.tokens {
+		if t.Hash == token.Hash {
+			return token, fmt.Errorf("failed to save token %s: %w", token.Name, ErrDuplicate)
+		}
+	}
+
+	token.ID = m.nextID("api_tokens")
+	m.tokens = append(m.tokens, token)
+
+	return token, nil
+}
+
+// GetAPITokenByHash gets the token with the given hash, or returns
+// sql.ErrNoRows.
+func (m *MemoryStore) GetAPITokenByHash(ctx context.Context, hash string) (model.APIToken, error) {
+
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return model.APIToken{}, err
+	}
+	defer unlock()
+
+	for _, t := range m.tokens {
+		if t.Hash == hash {
+			return t, nil
+		}
+	}
+
+	return model.APIToken{}, sql.ErrNoRows
+}
+
+// QueryAPITokensByUserID returns the tokens belonging to a user, newest first.
+func (m *MemoryStore) QueryAPITokensByUserID(ctx context.Context, userID int64) ([]model.APIToken, error) {
+
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	tokenList := []model.APIToken{}
+	for i := len(m.tokens) - 1; i >= 0; i-- {
+		if m.tokens[i].UserID == userID {
+			tokenList = append(tokenList, m.tokens[i])
+		}
+	}
+
+	return tokenList, nil
+}
+
+// DeleteAPIToken revokes one of a user's tokens. Returns sql.ErrNoRows if the
+// user has no such token.
+func (m *MemoryStore) DeleteAPIToken(ctx context.Context, userID, tokenID int64) error {
+
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	for i, t := range m.tokens {
+		if t.ID == tokenID && t.UserID == userID {
+			m.tokens = append(m.tokens[:i], m.tokens[i+1:]...)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("cannot delete token %d: %w", tokenID, sql.ErrNoRows)
+}
+
+// TouchAPIToken records that a token has been used.
+func (m *MemoryStore) TouchAPIToken(ctx context.Context, tokenID int64, usedAt time.Time) error {
+
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	for i, t := range m.tokens {
+		if t.ID == tokenID {
+			m.tokens[i].LastUsedAt = usedAt
+		}
+	}
+
+	return nil
+}
+
+// CreateDelivery saves a new delivery, giving it an ID.
+func (m *MemoryStore) CreateDelivery(ctx context.Context, delivery model.Delivery) (model.Delivery, error) {
+
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return model.Delivery{}, err
+	}
+	defer unlock()
+
+	delivery.ID = m.nextID("webhook_deliveries")
+	m.deliveries = append(m.deliveries, delivery)
+
+	return delivery, nil
+}
+
+// UpdateDelivery saves the status, attempts and response details of a Delivery.
+func (m *MemoryStore) UpdateDelivery(ctx context.Context, delivery model.Delivery) error {
+
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	for i, d := range m.deliveries {
+		if d.ID == delivery.ID {
+			m.deliveries[i].Status = delivery.Status
+			m.deliveries[i].Attempts = delivery.Attempts
+			m.deliveries[i].ResponseCode = delivery.ResponseCode
+			m.deliveries[i].LastError = delivery.LastError
+			m.deliveries[i].UpdatedAt = delivery.UpdatedAt
+		}
+	}
+
+	return nil
+}
+
+// GetDeliveryByID gets one delivery or returns sql.ErrNoRows
+func (m *MemoryStore) GetDeliveryByID(ctx context.Context, deliveryID int64) (model.Delivery, error) {
+
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return model.Delivery{}, err
+	}
+	defer unlock()
+
+	for _, d := range m.deliveries {
+		if d.ID == deliveryID {
+			return d, nil
+		}
+	}
+
+	return model.Delivery{}, fmt.Errorf("cannot get delivery %d: %w", deliveryID, sql.ErrNoRows)
+}
+
+// QueryRecentDeliveries returns the most recent deliveries, newest first.
+func (m *MemoryStore) QueryRecentDeliveries(ctx context.Context, limit int) ([]model.Delivery, error) {
+
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	deliveryList := []model.Delivery{}
+	for i := len(m.deliveries) - 1; i >= 0 && (limit < 0 || len(deliveryList) < limit); i-- {
+		deliveryList = append(deliveryList, m.deliveries[i])
+	}
+
+	return deliveryList, nil
+}
+
+// QueryPendingDeliveries returns the deliveries which have not yet succeeded
+// or failed (pending, or being sent), oldest first.
+func (m *MemoryStore) QueryPendingDeliveries(ctx context.Context) ([]model.Delivery, error) {
+
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	deliveryList := []model.Delivery{}
+	for _, d := range m.deliveries {
+		if d.Status == model.DeliveryPending || d.Status == model.DeliverySending {
+			deliveryList = append(deliveryList, d)
+		}
+	}
+
+	return deliveryList, nil
+}
+
+// SetDeliveryStatus changes the status of a delivery, only if it is one of
+// from. Returns false if it was not.
+func (m *MemoryStore) SetDeliveryStatus(ctx context.Context, deliveryID int64, status string, from ...string) (bool, error) {
+
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	for i, d := range m.deliveries {
+		if d.ID != deliveryID {
+			continue
+		}
+		for _, f := range from {
+			if d.Status == f {
+				m.deliveries[i].Status = status
+				m.deliveries[i].UpdatedAt = time.Now()
+				return true, nil
+			}
+		}
+	}
+
+	return false, nil
+}
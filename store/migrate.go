@@ -0,0 +1,375 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Migrations are files in the migrations directory for the driver (eg
+// migrations/sqlite) named NNNN_description.up.sql and
+// NNNN_description.down.sql, where NNNN is the schema version the migration
+// brings the database to. Versions must be consecutive, starting at 1, and
+// each driver must have the same versions.
+
+//go:embed migrations/sqlite/*.sql migrations/postgres/*.sql
+var migrationFiles embed.FS
+
+// migrationDirs are the migration directories for each driver.
+var migrationDirs = map[string]string{
+	SQLite:   "migrations/sqlite",
+	Postgres: "migrations/postgres",
+}
+
+// ErrSchemaTooNew indicates the database has migrations applied which this
+// binary does not know about, ie it was migrated by a newer version.
+var ErrSchemaTooNew = errors.New("database schema is newer than this program")
+
+// Migration is one step in the evolution of the schema.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationState reports whether a migration has been applied.
+type MigrationState struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// Migrations returns all the known migrations for the database, in order.
+func (s *SQLStore) Migrations() ([]Migration, error) {
+
+	dir := migrationDirs[s.driver]
+
+	entries, err := migrationFiles.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read migrations: %w", err)
+	}
+
+	byVersion := map[int]*Migration{}
+
+	for _, entry := range entries {
+
+		fileName := entry.Name()
+		base := strings.TrimSuffix(fileName, ".sql")
+		direction := path.Ext(base)
+		base = strings.TrimSuffix(base, direction)
+
+		parts := strings.SplitN(base, "_", 2)
+		version, err := strconv.Atoi(parts[0])
+		if err != nil || len(parts) != 2 || (direction != ".up" && direction != ".down") {
+			return nil, fmt.Errorf("badly named migration file %s", fileName)
+		}
+
+		body, err := migrationFiles.ReadFile(dir + "/" + fileName)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read migration %s: %w", fileName, err)
+		}
+
+		m := byVersion[version]
+		if m == nil {
+			m = &Migration{Version: version, Name: parts[1]}
+			byVersion[version] = m
+		}
+
+		if direction == ".up" {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+
+	migrations := []Migration{}
+	for _, m := range byVersion {
+		migrations = append(migrations, *m)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			return nil, fmt.Errorf("migration versions are not consecutive: expected %d, got %d", i+1, m.Version)
+		}
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s must have both up and down files", m.Version, m.Name)
+		}
+	}
+
+	return migrations, nil
+}
+
+// LatestSchemaVersion is the version the database will be at when all known
+// migrations are applied.
+func (s *SQLStore) LatestSchemaVersion() (int, error) {
+
+	migrations, err := s.Migrations()
+	if err != nil {
+		return 0, err
+	}
+
+	return len(migrations), nil
+}
+
+func (s *SQLStore) createMigrationsTable(ctx context.Context) error {
+
+	_, err := s.exec(ctx, `create table if not exists schema_migrations (
+		version integer primary key,
+		applied_at timestamp not null
+	)`)
+	if err != nil {
+		return fmt.Errorf("cannot create schema_migrations: %w", err)
+	}
+
+	return nil
+}
+
+// appliedMigrations returns the time each applied migration version was
+// applied.
+func (s *SQLStore) appliedMigrations(ctx context.Context) (map[int]time.Time, error) {
+
+	err := s.createMigrationsTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.query(ctx, `select version, applied_at from schema_migrations`)
+	if err != nil {
+		return nil, fmt.Errorf("cannot query schema_migrations: %w", err)
+	}
+	defer rows.Close()
+
+	applied := map[int]time.Time{}
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		err := rows.Scan(&version, &appliedAt)
+		if err != nil {
+			return nil, fmt.Errorf("cannot scan schema_migrations: %w", err)
+		}
+		applied[version] = appliedAt
+	}
+
+	return applied, nil
+}
+
+// SchemaVersion returns the highest migration version applied to the database.
+func (s *SQLStore) SchemaVersion(ctx context.Context) (int, error) {
+
+	applied, err := s.appliedMigrations(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	version := 0
+	for v := range applied {
+		if v > version {
+			version = v
+		}
+	}
+
+	return version, nil
+}
+
+// CheckSchemaVersion returns ErrSchemaTooNew if the database has been migrated
+// beyond what this program knows.
+func (s *SQLStore) CheckSchemaVersion(ctx context.Context) error {
+
+	current, err := s.SchemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+
+	latest, err := s.LatestSchemaVersion()
+	if err != nil {
+		return err
+	}
+
+	if current > latest {
+		return fmt.Errorf("%w: database is at version %d, this program knows up to %d", ErrSchemaTooNew, current, latest)
+	}
+
+	return nil
+}
+
+// MigrationStatus returns every known migration, and whether it is applied.
+func (s *SQLStore) MigrationStatus(ctx context.Context) ([]MigrationState, error) {
+
+	migrations, err := s.Migrations()
+	if err != nil {
+		return nil, err
+	}
+
+	applied, err := s.appliedMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	states := []MigrationState{}
+	for _, m := range migrations {
+		appliedAt, ok := applied[m.Version]
+		states = append(states, MigrationState{
+			Migration: m,
+			Applied:   ok,
+			AppliedAt: appliedAt,
+		})
+	}
+
+	return states, nil
+}
+
+// MigrateUp applies all pending migrations, in order, each in its own
+// transaction. It returns the migrations applied.
+func (s *SQLStore) MigrateUp(ctx context.Context) ([]Migration, error) {
+
+	err := s.CheckSchemaVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	states, err := s.MigrationStatus(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	done := []Migration{}
+	for _, state := range states {
+		if state.Applied {
+			continue
+		}
+
+		err := s.applyMigration(ctx, state.Migration, state.Up, func(tx *sql.Tx) error {
+			_, err := tx.ExecContext(ctx, s.rebind(`insert into schema_migrations (version, applied_at) values (?,?)`), state.Version, time.Now())
+			return err
+		})
+		if err != nil {
+			return done, err
+		}
+
+		done = append(done, state.Migration)
+	}
+
+	return done, nil
+}
+
+// MigrateDown reverts the most recently applied migration, returning it.
+// Returns sql.ErrNoRows if no migrations are applied.
+func (s *SQLStore) MigrateDown(ctx context.Context) (Migration, error) {
+
+	err := s.CheckSchemaVersion(ctx)
+	if err != nil {
+		return Migration{}, err
+	}
+
+	current, err := s.SchemaVersion(ctx)
+	if err != nil {
+		return Migration{}, err
+	}
+
+	if current == 0 {
+		return Migration{}, fmt.Errorf("no migrations to revert: %w", sql.ErrNoRows)
+	}
+
+	migrations, err := s.Migrations()
+	if err != nil {
+		return Migration{}, err
+	}
+
+	m := migrations[current-1]
+	err = s.applyMigration(ctx, m, m.Down, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, s.rebind(`delete from schema_migrations where version = ?`), m.Version)
+		return err
+	})
+
+	return m, err
+}
+
+// applyMigration runs the script, and records the change, in one transaction.
+//
+// For sqlite, foreign keys are not checked while the script runs, as
+// migrations rebuild tables (sqlite cannot alter most things in place), and
+// dropping a table which others refer to would fail. They are checked as a
+// whole before committing instead.
+func (s *SQLStore) applyMigration(ctx context.Context, m Migration, script string, record func(*sql.Tx) error) error {
+
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("cannot begin migration %d_%s: %w", m.Version, m.Name, err)
+	}
+	defer conn.Close()
+
+	if s.driver == SQLite {
+		var foreignKeys int
+		err = conn.QueryRowContext(ctx, `pragma foreign_keys`).Scan(&foreignKeys)
+		if err == nil {
+			_, err = conn.ExecContext(ctx, `pragma foreign_keys = off`)
+		}
+		if err != nil {
+			return fmt.Errorf("cannot begin migration %d_%s: %w", m.Version, m.Name, err)
+		}
+		// restore it even if ctx is done, as the connection goes back to the pool.
+		defer conn.ExecContext(context.Background(), fmt.Sprintf(`pragma foreign_keys = %d`, foreignKeys))
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("cannot begin migration %d_%s: %w", m.Version, m.Name, err)
+	}
+
+	_, err = tx.ExecContext(ctx, script)
+	if err == nil {
+		err = record(tx)
+	}
+	if err == nil && s.driver == SQLite {
+		err = checkForeignKeys(ctx, tx)
+	}
+
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("migration %d_%s failed: %w", m.Version, m.Name, err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("cannot commit migration %d_%s: %w", m.Version, m.Name, err)
+	}
+
+	return nil
+}
+
+// checkForeignKeys returns an error describing the first few rows of a sqlite
+// database which refer to rows which do not exist.
+func checkForeignKeys(ctx context.Context, tx *sql.Tx) error {
+
+	rows, err := tx.QueryContext(ctx, `pragma foreign_key_check`)
+	if err != nil {
+		return fmt.Errorf("cannot check foreign keys: %w", err)
+	}
+	defer rows.Close()
+
+	problems := []error{}
+	for rows.Next() && len(problems) < 10 {
+		var table, parent string
+		var rowID sql.NullInt64
+		var fk int
+		err := rows.Scan(&table, &rowID, &parent, &fk)
+		if err != nil {
+			return fmt.Errorf("cannot check foreign keys: %w", err)
+		}
+		problems = append(problems, fmt.Errorf("%s row %d refers to a missing row of %s", table, rowID.Int64, parent))
+	}
+
+	return errors.Join(problems...)
+}
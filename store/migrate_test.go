@@ -0,0 +1,61 @@
+package store_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/pdk/forum/store"
+)
+
+func TestMigrateUpDown(t *testing.T) {
+
+	ctx := context.Background()
+
+	db, _ := store.Open(":memory:")
+	defer db.Close()
+
+	latest, err := db.LatestSchemaVersion()
+	if err != nil {
+		t.Fatalf("expected to read migrations, but failed: %v", err)
+	}
+
+	applied, err := db.MigrateUp(ctx)
+	if err != nil {
+		t.Fatalf("expected to migrate up, but failed: %v", err)
+	}
+
+	if len(applied) != latest {
+		t.Errorf("expected %d migrations applied, but got %d", latest, len(applied))
+	}
+
+	applied, err = db.MigrateUp(ctx)
+	if err != nil || len(applied) != 0 {
+		t.Errorf("expected nothing to apply, but got %d, %v", len(applied), err)
+	}
+
+	for version := latest; version > 0; version-- {
+		m, err := db.MigrateDown(ctx)
+		if err != nil {
+			t.Fatalf("expected to revert migration %d, but failed: %v", version, err)
+		}
+		if m.Version != version {
+			t.Errorf("expected to revert migration %d, but reverted %d", version, m.Version)
+		}
+	}
+
+	applied, err = db.MigrateUp(ctx)
+	if err != nil || len(applied) != latest {
+		t.Errorf("expected to re-apply %d migrations, but got %d, %v", latest, len(applied), err)
+	}
+
+	_, err = db.DB().Exec(`insert into schema_migrations (version, applied_at) values (?, current_timestamp)`, latest+1)
+	if err != nil {
+		t.Fatalf("expected to insert future migration, but failed: %v", err)
+	}
+
+	_, err = db.MigrateUp(ctx)
+	if !errors.Is(err, store.ErrSchemaTooNew) {
+		t.Errorf("expected ErrSchemaTooNew, but got %v", err)
+	}
+}
@@ -1,8 +1,3 @@
--- drop-tables.sql
-
--- clean up script for sqlite3
--- use: .read drop-tables.sql
-
 drop table if exists posts;
 drop table if exists threads;
 drop table if exists topics;
@@ -0,0 +1,28 @@
+-- the original schema, for PostgreSQL.
+
+create table if not exists users (
+    id bigserial primary key,
+    joined_at timestamp with time zone not null,
+    name varchar not null unique
+);
+
+create table if not exists topics (
+    id bigserial primary key,
+    created_by_id bigint not null references users(id),
+    name varchar not null unique
+);
+
+create table if not exists threads (
+    id bigserial primary key,
+    topic_id bigint not null references topics(id),
+    created_by_id bigint not null references users(id),
+    subject varchar not null
+);
+
+create table if not exists posts (
+    id bigserial primary key,
+    thread_id bigint not null references threads(id),
+    posted_by_id bigint not null references users(id),
+    posted_at timestamp with time zone not null,
+    body varchar not null
+);
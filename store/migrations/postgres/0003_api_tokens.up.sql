@@ -0,0 +1,10 @@
+create table if not exists api_tokens (
+    id bigserial primary key,
+    user_id bigint not null references users(id),
+    name varchar not null,
+    hash varchar not null unique,
+    scopes varchar not null,
+    created_at timestamp with time zone not null,
+    expires_at timestamp with time zone,
+    last_used_at timestamp with time zone
+);
@@ -0,0 +1,4 @@
+alter table users drop column role;
+alter table users drop column banned;
+
+alter table threads drop column locked;
@@ -0,0 +1,6 @@
+-- user roles and bans, and locked threads, for moderation.
+
+alter table users add column role varchar not null default 'member';
+alter table users add column banned boolean not null default false;
+
+alter table threads add column locked boolean not null default false;
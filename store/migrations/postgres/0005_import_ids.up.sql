@@ -0,0 +1,10 @@
+-- maps the IDs in an imported dump to the IDs they were given here, so that an
+-- interrupted import can be resumed. source identifies the dump.
+
+create table if not exists import_ids (
+    source varchar not null,
+    kind varchar not null,
+    old_id bigint not null,
+    new_id bigint not null,
+    primary key (source, kind, old_id)
+);
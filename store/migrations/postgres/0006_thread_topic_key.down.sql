@@ -0,0 +1,2 @@
+-- nothing to do: this fixes a foreign key in the sqlite schema, which was
+-- always right here.
@@ -0,0 +1 @@
+alter table webhook_deliveries drop column hook;
@@ -0,0 +1,4 @@
+alter table users drop column edits;
+alter table topics drop column edits;
+alter table threads drop column edits;
+alter table posts drop column edits;
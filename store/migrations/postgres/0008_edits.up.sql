@@ -0,0 +1,7 @@
+-- counts the edits to each row, so that a page's validator can be made from a
+-- cheap query (see TopicsStamp etc) and still notice an edit.
+
+alter table users add column edits int not null default 0;
+alter table topics add column edits int not null default 0;
+alter table threads add column edits int not null default 0;
+alter table posts add column edits int not null default 0;
@@ -0,0 +1,4 @@
+drop table if exists posts;
+drop table if exists threads;
+drop table if exists topics;
+drop table if exists users;
@@ -1,7 +1,5 @@
--- create-tables.sql
-
--- init script for sqlite3.
--- use: .read create-tables.sql
+-- the original schema. "if not exists" so that databases created by hand with
+-- the old sql/create-tables.sql can be brought under migration.
 
 create table if not exists users (
     id integer primary key autoincrement,
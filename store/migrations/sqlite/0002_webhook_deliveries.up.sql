@@ -0,0 +1,12 @@
+create table if not exists webhook_deliveries (
+    id integer primary key autoincrement,
+    event varchar not null,
+    url varchar not null,
+    payload varchar not null,
+    status varchar not null,
+    attempts int not null default 0,
+    response_code int not null default 0,
+    last_error varchar not null default '',
+    created_at timestamp not null,
+    updated_at timestamp not null
+);
@@ -0,0 +1,10 @@
+create table if not exists api_tokens (
+    id integer primary key autoincrement,
+    user_id int not null references users(id),
+    name varchar not null,
+    hash varchar not null unique,
+    scopes varchar not null,
+    created_at timestamp not null,
+    expires_at timestamp,
+    last_used_at timestamp
+);
@@ -0,0 +1,20 @@
+-- sqlite cannot drop columns, so rebuild the tables without them.
+
+create table users_old (
+    id integer primary key autoincrement,
+    joined_at timestamp not null,
+    name varchar not null unique
+);
+insert into users_old (id, joined_at, name) select id, joined_at, name from users;
+drop table users;
+alter table users_old rename to users;
+
+create table threads_old (
+    id integer primary key autoincrement,
+    topic_id int not null references topic(id),
+    created_by_id int not null references users(id),
+    subject varchar not null
+);
+insert into threads_old (id, topic_id, created_by_id, subject) select id, topic_id, created_by_id, subject from threads;
+drop table threads;
+alter table threads_old rename to threads;
@@ -0,0 +1,6 @@
+-- user roles and bans, and locked threads, for moderation.
+
+alter table users add column role varchar not null default 'member';
+alter table users add column banned int not null default 0;
+
+alter table threads add column locked int not null default 0;
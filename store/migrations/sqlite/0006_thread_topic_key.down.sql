@@ -0,0 +1,10 @@
+create table threads_old (
+    id integer primary key autoincrement,
+    topic_id int not null references topic(id),
+    created_by_id int not null references users(id),
+    subject varchar not null,
+    locked int not null default 0
+);
+insert into threads_old (id, topic_id, created_by_id, subject, locked) select id, topic_id, created_by_id, subject, locked from threads;
+drop table threads;
+alter table threads_old rename to threads;
@@ -0,0 +1,13 @@
+-- threads.topic_id referred to a table "topic", which does not exist, so it
+-- could not be checked. rebuild the table to refer to topics.
+
+create table threads_new (
+    id integer primary key autoincrement,
+    topic_id int not null references topics(id),
+    created_by_id int not null references users(id),
+    subject varchar not null,
+    locked int not null default 0
+);
+insert into threads_new (id, topic_id, created_by_id, subject, locked) select id, topic_id, created_by_id, subject, locked from threads;
+drop table threads;
+alter table threads_new rename to threads;
@@ -0,0 +1,18 @@
+-- sqlite cannot drop columns, so rebuild the table without it.
+
+create table webhook_deliveries_old (
+    id integer primary key autoincrement,
+    event varchar not null,
+    url varchar not null,
+    payload varchar not null,
+    status varchar not null,
+    attempts int not null default 0,
+    response_code int not null default 0,
+    last_error varchar not null default '',
+    created_at timestamp not null,
+    updated_at timestamp not null
+);
+insert into webhook_deliveries_old (id, event, url, payload, status, attempts, response_code, last_error, created_at, updated_at)
+    select id, event, url, payload, status, attempts, response_code, last_error, created_at, updated_at from webhook_deliveries;
+drop table webhook_deliveries;
+alter table webhook_deliveries_old rename to webhook_deliveries;
@@ -0,0 +1,4 @@
+-- which configured webhook a delivery is for, by its place in the list, since
+-- two webhooks may share a URL. -1 for deliveries recorded before this.
+
+alter table webhook_deliveries add column hook int not null default -1;
@@ -0,0 +1,43 @@
+-- sqlite cannot drop columns, so rebuild the tables without them.
+
+create table users_old (
+    id integer primary key autoincrement,
+    joined_at timestamp not null,
+    name varchar not null unique,
+    role varchar not null default 'member',
+    banned int not null default 0
+);
+insert into users_old (id, joined_at, name, role, banned) select id, joined_at, name, role, banned from users;
+drop table users;
+alter table users_old rename to users;
+
+create table topics_old (
+    id integer primary key autoincrement,
+    created_by_id int not null references users(id),
+    name varchar not null unique
+);
+insert into topics_old (id, created_by_id, name) select id, created_by_id, name from topics;
+drop table topics;
+alter table topics_old rename to topics;
+
+create table threads_old (
+    id integer primary key autoincrement,
+    topic_id int not null references topics(id),
+    created_by_id int not null references users(id),
+    subject varchar not null,
+    locked int not null default 0
+);
+insert into threads_old (id, topic_id, created_by_id, subject, locked) select id, topic_id, created_by_id, subject, locked from threads;
+drop table threads;
+alter table threads_old rename to threads;
+
+create table posts_old (
+    id integer primary key autoincrement,
+    thread_id int not null references threads(id),
+    posted_by_id int not null references users(id),
+    posted_at timestamp not null,
+    body varchar not null
+);
+insert into posts_old (id, thread_id, posted_by_id, posted_at, body) select id, thread_id, posted_by_id, posted_at, body from posts;
+drop table posts;
+alter table posts_old rename to posts;
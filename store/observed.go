@@ -0,0 +1,256 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"github.com/pdk/forum/model"
+)
+
+// Observer is told of each call to a Store: which function, how long it took,
+// and the error, if any.
+type Observer func(function string, elapsed time.Duration, err error)
+
+// ObservedStore is a Store which reports every call to an Observer, eg to
+// count queries and their durations.
+type ObservedStore struct {
+	Store
+	observer Observer
+}
+
+var _ Store = (*ObservedStore)(nil)
+
+// NewObservedStore returns an ObservedStore in front of s.
+func NewObservedStore(s Store, observer Observer) *ObservedStore {
+	return &ObservedStore{Store: s, observer: observer}
+}
+
+func (o *ObservedStore) observe(function string, start time.Time, err *error) {
+	o.observer(function, time.Since(start), *err)
+}
+
+// WithTx runs fn in a transaction, whose calls are observed too.
+func (o *ObservedStore) WithTx(ctx context.Context, fn func(tx Store) error) (err error) {
+	defer o.observe("WithTx", time.Now(), &err)
+	return o.Store.WithTx(ctx, func(tx Store) error {
+		return fn(&ObservedStore{Store: tx, observer: o.observer})
+	})
+}
+
+// ReadTx runs fn in a read only transaction, whose calls are observed too.
+func (o *ObservedStore) ReadTx(ctx context.Context, fn func(tx Store) error) (err error) {
+	defer o.observe("ReadTx", time.Now(), &err)
+	return o.Store.ReadTx(ctx, func(tx Store) error {
+		return fn(&ObservedStore{Store: tx, observer: o.observer})
+	})
+}
+
+func (o *ObservedStore) CreateUser(ctx context.Context, user model.User) (_ model.User, err error) {
+	defer o.observe("CreateUser", time.Now(), &err)
+	return o.Store.CreateUser(ctx, user)
+}
+
+func (o *ObservedStore) GetUserByID(ctx context.Context, userID int64) (_ model.User, err error) {
+	defer o.observe("GetUserByID", time.Now(), &err)
+	return o.Store.GetUserByID(ctx, userID)
+}
+
+func (o *ObservedStore) GetUserByName(ctx context.Context, name string) (_ model.User, err error) {
+	defer o.observe("GetUserByName", time.Now(), &err)
+	return o.Store.GetUserByName(ctx, name)
+}
+
+func (o *ObservedStore) GetOrCreateUserByName(ctx context.Context, name string) (_ model.User, _ bool, err error) {
+	defer o.observe("GetOrCreateUserByName", time.Now(), &err)
+	return o.Store.GetOrCreateUserByName(ctx, name)
+}
+
+func (o *ObservedStore) ListUsers(ctx context.Context, page Page) (_ []model.User, err error) {
+	defer o.observe("ListUsers", time.Now(), &err)
+	return o.Store.ListUsers(ctx, page)
+}
+
+func (o *ObservedStore) UpdateUser(ctx context.Context, user model.User) (err error) {
+	defer o.observe("UpdateUser", time.Now(), &err)
+	return o.Store.UpdateUser(ctx, user)
+}
+
+func (o *ObservedStore) CreateTopic(ctx context.Context, topic model.Topic) (_ model.Topic, err error) {
+	defer o.observe("CreateTopic", time.Now(), &err)
+	return o.Store.CreateTopic(ctx, topic)
+}
+
+func (o *ObservedStore) GetTopicByID(ctx context.Context, topicID int64) (_ model.Topic, err error) {
+	defer o.observe("GetTopicByID", time.Now(), &err)
+	return o.Store.GetTopicByID(ctx, topicID)
+}
+
+func (o *ObservedStore) GetTopicByName(ctx context.Context, name string) (_ model.Topic, err error) {
+	defer o.observe("GetTopicByName", time.Now(), &err)
+	return o.Store.GetTopicByName(ctx, name)
+}
+
+func (o *ObservedStore) ListTopics(ctx context.Context, page Page) (_ []model.Topic, err error) {
+	defer o.observe("ListTopics", time.Now(), &err)
+	return o.Store.ListTopics(ctx, page)
+}
+
+func (o *ObservedStore) TopicsStamp(ctx context.Context) (_ string, err error) {
+	defer o.observe("TopicsStamp", time.Now(), &err)
+	return o.Store.TopicsStamp(ctx)
+}
+
+func (o *ObservedStore) UpdateTopic(ctx context.Context, topic model.Topic) (err error) {
+	defer o.observe("UpdateTopic", time.Now(), &err)
+	return o.Store.UpdateTopic(ctx, topic)
+}
+
+func (o *ObservedStore) DeleteTopic(ctx context.Context, topicID int64) (err error) {
+	defer o.observe("DeleteTopic", time.Now(), &err)
+	return o.Store.DeleteTopic(ctx, topicID)
+}
+
+func (o *ObservedStore) CreateThread(ctx context.Context, thread model.Thread) (_ model.Thread, err error) {
+	defer o.observe("CreateThread", time.Now(), &err)
+	return o.Store.CreateThread(ctx, thread)
+}
+
+func (o *ObservedStore) GetThreadByID(ctx context.Context, threadID int64) (_ model.Thread, err error) {
+	defer o.observe("GetThreadByID", time.Now(), &err)
+	return o.Store.GetThreadByID(ctx, threadID)
+}
+
+func (o *ObservedStore) GetThreadForUpdate(ctx context.Context, threadID int64) (_ model.Thread, err error) {
+	defer o.observe("GetThreadForUpdate", time.Now(), &err)
+	return o.Store.GetThreadForUpdate(ctx, threadID)
+}
+
+func (o *ObservedStore) ListThreadsByTopicID(ctx context.Context, topicID int64, page Page) (_ []model.Thread, err error) {
+	defer o.observe("ListThreadsByTopicID", time.Now(), &err)
+	return o.Store.ListThreadsByTopicID(ctx, topicID, page)
+}
+
+func (o *ObservedStore) ThreadsStamp(ctx context.Context, topicID int64) (_ string, err error) {
+	defer o.observe("ThreadsStamp", time.Now(), &err)
+	return o.Store.ThreadsStamp(ctx, topicID)
+}
+
+func (o *ObservedStore) UpdateThread(ctx context.Context, thread model.Thread) (err error) {
+	defer o.observe("UpdateThread", time.Now(), &err)
+	return o.Store.UpdateThread(ctx, thread)
+}
+
+func (o *ObservedStore) CreatePost(ctx context.Context, post model.Post) (_ model.Post, err error) {
+	defer o.observe("CreatePost", time.Now(), &err)
+	return o.Store.CreatePost(ctx, post)
+}
+
+func (o *ObservedStore) GetPostByID(ctx context.Context, postID int64) (_ model.Post, err error) {
+	defer o.observe("GetPostByID", time.Now(), &err)
+	return o.Store.GetPostByID(ctx, postID)
+}
+
+func (o *ObservedStore) ListPostsByThreadID(ctx context.Context, threadID int64, page Page) (_ []model.Post, err error) {
+	defer o.observe("ListPostsByThreadID", time.Now(), &err)
+	return o.Store.ListPostsByThreadID(ctx, threadID, page)
+}
+
+func (o *ObservedStore) QueryRecentPosts(ctx context.Context, limit int) (_ []model.Post, err error) {
+	defer o.observe("QueryRecentPosts", time.Now(), &err)
+	return o.Store.QueryRecentPosts(ctx, limit)
+}
+
+func (o *ObservedStore) QueryRecentPostsByTopicID(ctx context.Context, topicID int64, limit int) (_ []model.Post, err error) {
+	defer o.observe("QueryRecentPostsByTopicID", time.Now(), &err)
+	return o.Store.QueryRecentPostsByTopicID(ctx, topicID, limit)
+}
+
+func (o *ObservedStore) QueryRecentPostsByThreadID(ctx context.Context, threadID int64, limit int) (_ []model.Post, err error) {
+	defer o.observe("QueryRecentPostsByThreadID", time.Now(), &err)
+	return o.Store.QueryRecentPostsByThreadID(ctx, threadID, limit)
+}
+
+func (o *ObservedStore) QueryPostsAfterID(ctx context.Context, threadID, afterID int64, limit int) (_ []model.Post, err error) {
+	defer o.observe("QueryPostsAfterID", time.Now(), &err)
+	return o.Store.QueryPostsAfterID(ctx, threadID, afterID, limit)
+}
+
+func (o *ObservedStore) PostsStamp(ctx context.Context, threadID int64) (_ string, err error) {
+	defer o.observe("PostsStamp", time.Now(), &err)
+	return o.Store.PostsStamp(ctx, threadID)
+}
+
+func (o *ObservedStore) RecentPostsStamp(ctx context.Context, limit int) (_ string, err error) {
+	defer o.observe("RecentPostsStamp", time.Now(), &err)
+	return o.Store.RecentPostsStamp(ctx, limit)
+}
+
+func (o *ObservedStore) RecentPostsStampByTopicID(ctx context.Context, topicID int64, limit int) (_ string, err error) {
+	defer o.observe("RecentPostsStampByTopicID", time.Now(), &err)
+	return o.Store.RecentPostsStampByTopicID(ctx, topicID, limit)
+}
+
+func (o *ObservedStore) UpdatePost(ctx context.Context, post model.Post) (err error) {
+	defer o.observe("UpdatePost", time.Now(), &err)
+	return o.Store.UpdatePost(ctx, post)
+}
+
+func (o *ObservedStore) DeletePost(ctx context.Context, postID int64) (err error) {
+	defer o.observe("DeletePost", time.Now(), &err)
+	return o.Store.DeletePost(ctx, postID)
+}
+
+func (o *ObservedStore) CreateAPIToken(ctx context.Context, token model.APIToken) (_ model.APIToken, err error) {
+	defer o.observe("CreateAPIToken", time.Now(), &err)
+	return o.Store.CreateAPIToken(ctx, token)
+}
+
+func (o *ObservedStore) GetAPITokenByHash(ctx context.Context, hash string) (_ model.APIToken, err error) {
+	defer o.observe("GetAPITokenByHash", time.Now(), &err)
+	return o.Store.GetAPITokenByHash(ctx, hash)
+}
+
+func (o *ObservedStore) QueryAPITokensByUserID(ctx context.Context, userID int64) (_ []model.APIToken, err error) {
+	defer o.observe("QueryAPITokensByUserID", time.Now(), &err)
+	return o.Store.QueryAPITokensByUserID(ctx, userID)
+}
+
+func (o *ObservedStore) DeleteAPIToken(ctx context.Context, userID, tokenID int64) (err error) {
+	defer o.observe("DeleteAPIToken", time.Now(), &err)
+	return o.Store.DeleteAPIToken(ctx, userID, tokenID)
+}
+
+func (o *ObservedStore) TouchAPIToken(ctx context.Context, tokenID int64, usedAt time.Time) (err error) {
+	defer o.observe("TouchAPIToken", time.Now(), &err)
+	return o.Store.TouchAPIToken(ctx, tokenID, usedAt)
+}
+
+func (o *ObservedStore) CreateDelivery(ctx context.Context, delivery model.Delivery) (_ model.Delivery, err error) {
+	defer o.observe("CreateDelivery", time.Now(), &err)
+	return o.Store.CreateDelivery(ctx, delivery)
+}
+
+func (o *ObservedStore) UpdateDelivery(ctx context.Context, delivery model.Delivery) (err error) {
+	defer o.observe("UpdateDelivery", time.Now(), &err)
+	return o.Store.UpdateDelivery(ctx, delivery)
+}
+
+func (o *ObservedStore) GetDeliveryByID(ctx context.Context, deliveryID int64) (_ model.Delivery, err error) {
+	defer o.observe("GetDeliveryByID", time.Now(), &err)
+	return o.Store.GetDeliveryByID(ctx, deliveryID)
+}
+
+func (o *ObservedStore) QueryRecentDeliveries(ctx context.Context, limit int) (_ []model.Delivery, err error) {
+	defer o.observe("QueryRecentDeliveries", time.Now(), &err)
+	return o.Store.QueryRecentDeliveries(ctx, limit)
+}
+
+func (o *ObservedStore) QueryPendingDeliveries(ctx context.Context) (_ []model.Delivery, err error) {
+	defer o.observe("QueryPendingDeliveries", time.Now(), &err)
+	return o.Store.QueryPendingDeliveries(ctx)
+}
+
+func (o *ObservedStore) SetDeliveryStatus(ctx context.Context, deliveryID int64, status string, from ...string) (_ bool, err error) {
+	defer o.observe("SetDeliveryStatus", time.Now(), &err)
+	return o.Store.SetDeliveryStatus(ctx, deliveryID, status, from...)
+}
@@ -0,0 +1,10 @@
+package store
+
+// Page selects a window of rows from a list query.
+type Page struct {
+	Limit  int
+	Offset int
+}
+
+// AllRows is a Page which selects every row.
+var AllRows = Page{Limit: -1}
@@ -1,6 +1,7 @@
 package store
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 
@@ -8,32 +9,109 @@ import (
 )
 
 // CreatePost will insert a Post into the database and return a modified Post (ie with a new ID).
-func CreatePost(db *sql.DB, post model.Post) (model.Post, error) {
+func (s *SQLStore) CreatePost(ctx context.Context, post model.Post) (model.Post, error) {
 
-	result, err := db.Exec(`insert into posts (thread_id, posted_by_id, posted_at, body) values (?,?,?,?)`,
+	var err error
+	post.ID, err = s.insert(ctx, `insert into posts (thread_id, posted_by_id, posted_at, body) values (?,?,?,?)`,
 		post.ThreadID, post.PostedByID, post.PostedAt, post.Body)
 	if err != nil {
 		return post, fmt.Errorf("failed to save post %s: %w", post.Body, err)
 	}
 
-	post.ID, err = result.LastInsertId()
-	if err != nil {
-		return post, fmt.Errorf("failed to get new ID for post %s: %w", post.Body, err)
-	}
-
 	return post, nil
 }
 
-// QueryPostsByThreadID selects all the posts for a given thread.
-func QueryPostsByThreadID(db *sql.DB, threadID int64) ([]model.Post, error) {
+// ListPostsByThreadID selects one page of the posts for a given thread, oldest
+// first.
+func (s *SQLStore) ListPostsByThreadID(ctx context.Context, threadID int64, page Page) ([]model.Post, error) {
 
 	postList := []model.Post{}
 
-	rows, err := db.Query(`select id, thread_id, posted_by_id, posted_at, body from posts where thread_id = ? order by id asc`, threadID)
+	rows, err := s.query(ctx, `select id, thread_id, posted_by_id, posted_at, body from posts where thread_id = ? order by id asc limit ? offset ?`, threadID, s.pageLimit(page), page.Offset)
 	if err != nil {
 		return postList, fmt.Errorf("failed to query posts by id %d: %w", threadID, err)
 	}
 
+	return scanPosts(rows)
+}
+
+// QueryRecentPosts selects the most recent posts in the whole forum, newest
+// first.
+func (s *SQLStore) QueryRecentPosts(ctx context.Context, limit int) ([]model.Post, error) {
+
+	rows, err := s.query(ctx, `select id, thread_id, posted_by_id, posted_at, body from posts order by id desc limit ?`, limit)
+	if err != nil {
+		return []model.Post{}, fmt.Errorf("failed to query recent posts: %w", err)
+	}
+
+	return scanPosts(rows)
+}
+
+// QueryRecentPostsByTopicID selects the most recent posts in any thread of a
+// topic, newest first.
+func (s *SQLStore) QueryRecentPostsByTopicID(ctx context.Context, topicID int64, limit int) ([]model.Post, error) {
+
+	rows, err := s.query(ctx, `select p.id, p.thread_id, p.posted_by_id, p.posted_at, p.body from posts p join threads t on t.id = p.thread_id where t.topic_id = ? order by p.id desc limit ?`, topicID, limit)
+	if err != nil {
+		return []model.Post{}, fmt.Errorf("failed to query recent posts for topic %d: %w", topicID, err)
+	}
+
+	return scanPosts(rows)
+}
+
+// QueryRecentPostsByThreadID selects the most recent posts in a thread, newest
+// first.
+func (s *SQLStore) QueryRecentPostsByThreadID(ctx context.Context, threadID int64, limit int) ([]model.Post, error) {
+
+	rows, err := s.query(ctx, `select id, thread_id, posted_by_id, posted_at, body from posts where thread_id = ? order by id desc limit ?`, threadID, limit)
+	if err != nil {
+		return []model.Post{}, fmt.Errorf("failed to query recent posts for thread %d: %w", threadID, err)
+	}
+
+	return scanPosts(rows)
+}
+
+// QueryPostsAfterID selects the posts in a thread which are newer than the
+// given post ID, oldest first.
+func (s *SQLStore) QueryPostsAfterID(ctx context.Context, threadID, afterID int64, limit int) ([]model.Post, error) {
+
+	rows, err := s.query(ctx, `select id, thread_id, posted_by_id, posted_at, body from posts where thread_id = ? and id > ? order by id asc limit ?`, threadID, afterID, limit)
+	if err != nil {
+		return []model.Post{}, fmt.Errorf("failed to query posts for thread %d after %d: %w", threadID, afterID, err)
+	}
+
+	return scanPosts(rows)
+}
+
+// PostsStamp returns a value which changes whenever a post of the thread is
+// added, edited or deleted, or one of their posters is renamed, without
+// reading the posts.
+func (s *SQLStore) PostsStamp(ctx context.Context, threadID int64) (string, error) {
+
+	return s.stamp(ctx, `select count(*), coalesce(max(p.id), 0), coalesce(sum(p.edits), 0) + coalesce(sum(u.edits), 0) from posts p join users u on u.id = p.posted_by_id where p.thread_id = ?`, threadID)
+}
+
+// RecentPostsStamp returns a value which changes whenever the most recent posts
+// in the whole forum change, or are edited, or their threads or posters are,
+// without reading the posts. It sums the ids, rather than taking the highest,
+// so that deleting one of the posts changes it.
+func (s *SQLStore) RecentPostsStamp(ctx context.Context, limit int) (string, error) {
+
+	return s.stamp(ctx, `select count(*), coalesce(sum(p.id), 0), coalesce(sum(p.edits + t.edits + u.edits), 0) from (select id, thread_id, posted_by_id, edits from posts order by id desc limit ?) p join threads t on t.id = p.thread_id join users u on u.id = p.posted_by_id`, limit)
+}
+
+// RecentPostsStampByTopicID returns a value which changes whenever the most
+// recent posts in any thread of a topic change, as RecentPostsStamp.
+func (s *SQLStore) RecentPostsStampByTopicID(ctx context.Context, topicID int64, limit int) (string, error) {
+
+	return s.stamp(ctx, `select count(*), coalesce(sum(p.id), 0), coalesce(sum(p.edits + t.edits + u.edits), 0) from (select p.id, p.thread_id, p.posted_by_id, p.edits from posts p join threads t on t.id = p.thread_id where t.topic_id = ? order by p.id desc limit ?) p join threads t on t.id = p.thread_id join users u on u.id = p.posted_by_id`, topicID, limit)
+}
+
+func scanPosts(rows *sql.Rows) ([]model.Post, error) {
+
+	postList := []model.Post{}
+	defer rows.Close()
+
 	for rows.Next() {
 		nextPost := model.Post{}
 		err := rows.Scan(&nextPost.ID, &nextPost.ThreadID, &nextPost.PostedByID, &nextPost.PostedAt, &nextPost.Body)
@@ -46,3 +124,48 @@ func QueryPostsByThreadID(db *sql.DB, threadID int64) ([]model.Post, error) {
 
 	return postList, nil
 }
+
+// GetPostByID gets one post or returns sql.ErrNoRows
+func (s *SQLStore) GetPostByID(ctx context.Context, postID int64) (model.Post, error) {
+
+	post := model.Post{}
+	err := s.queryRow(ctx, `select id, thread_id, posted_by_id, posted_at, body from posts where id = ?`, postID).
+		Scan(&post.ID, &post.ThreadID, &post.PostedByID, &post.PostedAt, &post.Body)
+
+	if err != nil {
+		return post, fmt.Errorf("cannot get post %d: %w", postID, err)
+	}
+
+	return post, nil
+}
+
+// UpdatePost saves the body of an existing post.
+func (s *SQLStore) UpdatePost(ctx context.Context, post model.Post) error {
+
+	_, err := s.exec(ctx, `update posts set body = ?, edits = edits + 1 where id = ?`, post.Body, post.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update post %d: %w", post.ID, err)
+	}
+
+	return nil
+}
+
+// DeletePost deletes one post. Returns sql.ErrNoRows if there is no such post.
+func (s *SQLStore) DeletePost(ctx context.Context, postID int64) error {
+
+	result, err := s.exec(ctx, `delete from posts where id = ?`, postID)
+	if err != nil {
+		return fmt.Errorf("failed to delete post %d: %w", postID, err)
+	}
+
+	count, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to delete post %d: %w", postID, err)
+	}
+
+	if count == 0 {
+		return fmt.Errorf("cannot delete post %d: %w", postID, sql.ErrNoRows)
+	}
+
+	return nil
+}
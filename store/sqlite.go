@@ -0,0 +1,186 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Options tune the connections to the database.
+type Options struct {
+	// JournalMode is the sqlite journal_mode, eg "wal" or "delete".
+	JournalMode string
+
+	// BusyTimeout is how long a sqlite connection waits for another to
+	// release a lock, before failing with SQLITE_BUSY.
+	BusyTimeout time.Duration
+
+	// ForeignKeys turns on checking of foreign keys by sqlite. PostgreSQL
+	// always checks them.
+	ForeignKeys bool
+
+	// MaxConns limits the connections reading a sqlite file (there is one
+	// more for writing), or the connections to PostgreSQL.
+	MaxConns int
+}
+
+// DefaultOptions returns the options used by Open.
+func DefaultOptions() Options {
+	return Options{
+		JournalMode: "wal",
+		BusyTimeout: 5 * time.Second,
+		ForeignKeys: true,
+		MaxConns:    8,
+	}
+}
+
+// inMemory returns true if a sqlite database is in memory, rather than a file.
+func inMemory(database string) bool {
+	return database == ":memory:" || strings.Contains(database, "mode=memory")
+}
+
+// sqliteDSN adds the options to a sqlite database name, as parameters for the
+// driver, which applies them to each new connection. Reading connections are
+// made query only. Every connection must ask for the same journal mode, as the
+// driver sets it (to "delete" if not given) on each, and changing it while
+// another connection has the file open fails.
+func sqliteDSN(database string, opts Options, writer bool) string {
+
+	params := []string{
+		fmt.Sprintf("_busy_timeout=%d", opts.BusyTimeout.Milliseconds()),
+		fmt.Sprintf("_foreign_keys=%d", boolInt(opts.ForeignKeys)),
+	}
+
+	if opts.JournalMode != "" {
+		params = append(params, "_journal_mode="+opts.JournalMode)
+	}
+
+	if !writer {
+		params = append(params, "_query_only=1")
+	}
+
+	sep := "?"
+	if strings.Contains(database, "?") {
+		sep = "&"
+	}
+
+	return database + sep + strings.Join(params, "&")
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// CheckPragmas reads the settings in effect on the sqlite connections, and
+// returns them as a report, eg "journal_mode=wal busy_timeout=5000
+// foreign_keys=1". The error describes any which differ from the options the
+// database was opened with. For PostgreSQL the report is only the pool size.
+func (s *SQLStore) CheckPragmas(ctx context.Context) (string, error) {
+
+	if s.driver != SQLite {
+		return fmt.Sprintf("max_conns=%d", s.opts.MaxConns), nil
+	}
+
+	want := map[string]string{
+		"journal_mode": strings.ToLower(s.opts.JournalMode),
+		"busy_timeout": fmt.Sprint(s.opts.BusyTimeout.Milliseconds()),
+		"foreign_keys": fmt.Sprint(boolInt(s.opts.ForeignKeys)),
+	}
+
+	if s.opts.JournalMode == "" || s.rdb == s.db {
+		// in memory, or opened without options: journal_mode is whatever
+		// sqlite chose.
+		delete(want, "journal_mode")
+	}
+
+	pools := []struct {
+		name string
+		q    Querier
+	}{{"writer", s.db}, {"reader", s.rdb}}
+
+	report := []string{}
+	problems := []error{}
+
+	for _, pool := range pools {
+		for _, pragma := range []string{"journal_mode", "busy_timeout", "foreign_keys"} {
+
+			var value string
+			err := pool.q.QueryRowContext(ctx, `pragma `+pragma).Scan(&value)
+			if err != nil {
+				return "", fmt.Errorf("cannot read pragma %s: %w", pragma, err)
+			}
+			value = strings.ToLower(value)
+
+			if pool.q == s.db {
+				report = append(report, pragma+"="+value)
+			}
+
+			if expected, ok := want[pragma]; ok && value != expected {
+				problems = append(problems, fmt.Errorf("%s %s is %s, expected %s", pool.name, pragma, value, expected))
+			}
+		}
+
+		if s.rdb == s.db {
+			break
+		}
+	}
+
+	report = append(report, fmt.Sprintf("readers=%d", s.readers()))
+
+	return strings.Join(report, " "), errors.Join(problems...)
+}
+
+// readers returns the most connections which can read at once.
+func (s *SQLStore) readers() int {
+
+	if s.rdb == s.db {
+		return 1
+	}
+
+	return s.opts.MaxConns
+}
+
+// CheckDatabase checks that a sqlite database file (or, for a new database,
+// its directory) can be written. Anything else is left to its driver.
+func CheckDatabase(database string) error {
+
+	if database == "" || DriverFor(database) != SQLite {
+		return nil
+	}
+
+	if inMemory(database) || strings.HasPrefix(database, "file:") {
+		// in memory, or a URI with options: leave it to sqlite.
+		return nil
+	}
+
+	f, err := os.OpenFile(database, os.O_WRONLY, 0)
+	if err == nil {
+		return f.Close()
+	}
+
+	if !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("cannot write %s: %w", database, err)
+	}
+
+	dir := filepath.Dir(database)
+	info, err := os.Stat(dir)
+	if err != nil || !info.IsDir() {
+		return fmt.Errorf("cannot create %s, directory %s does not exist", database, dir)
+	}
+
+	probe, err := os.CreateTemp(dir, ".forum-probe-*")
+	if err != nil {
+		return fmt.Errorf("cannot create %s, directory %s is not writable: %w", database, dir, err)
+	}
+	probe.Close()
+	os.Remove(probe.Name())
+
+	return nil
+}
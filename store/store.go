@@ -0,0 +1,362 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pdk/forum/model"
+)
+
+// UserStore saves and finds users.
+type UserStore interface {
+	CreateUser(ctx context.Context, user model.User) (model.User, error)
+	GetUserByID(ctx context.Context, userID int64) (model.User, error)
+	GetUserByName(ctx context.Context, name string) (model.User, error)
+	GetOrCreateUserByName(ctx context.Context, name string) (model.User, bool, error)
+	ListUsers(ctx context.Context, page Page) ([]model.User, error)
+	UpdateUser(ctx context.Context, user model.User) error
+}
+
+// TopicStore saves and finds topics.
+type TopicStore interface {
+	CreateTopic(ctx context.Context, topic model.Topic) (model.Topic, error)
+	GetTopicByID(ctx context.Context, topicID int64) (model.Topic, error)
+	GetTopicByName(ctx context.Context, name string) (model.Topic, error)
+	ListTopics(ctx context.Context, page Page) ([]model.Topic, error)
+	TopicsStamp(ctx context.Context) (string, error)
+	UpdateTopic(ctx context.Context, topic model.Topic) error
+	DeleteTopic(ctx context.Context, topicID int64) error
+}
+
+// ThreadStore saves and finds threads.
+type ThreadStore interface {
+	CreateThread(ctx context.Context, thread model.Thread) (model.Thread, error)
+	GetThreadByID(ctx context.Context, threadID int64) (model.Thread, error)
+	GetThreadForUpdate(ctx context.Context, threadID int64) (model.Thread, error)
+	ListThreadsByTopicID(ctx context.Context, topicID int64, page Page) ([]model.Thread, error)
+	ThreadsStamp(ctx context.Context, topicID int64) (string, error)
+	UpdateThread(ctx context.Context, thread model.Thread) error
+}
+
+// PostStore saves and finds posts.
+type PostStore interface {
+	CreatePost(ctx context.Context, post model.Post) (model.Post, error)
+	GetPostByID(ctx context.Context, postID int64) (model.Post, error)
+	ListPostsByThreadID(ctx context.Context, threadID int64, page Page) ([]model.Post, error)
+	QueryRecentPosts(ctx context.Context, limit int) ([]model.Post, error)
+	QueryRecentPostsByTopicID(ctx context.Context, topicID int64, limit int) ([]model.Post, error)
+	QueryRecentPostsByThreadID(ctx context.Context, threadID int64, limit int) ([]model.Post, error)
+	QueryPostsAfterID(ctx context.Context, threadID, afterID int64, limit int) ([]model.Post, error)
+	PostsStamp(ctx context.Context, threadID int64) (string, error)
+	RecentPostsStamp(ctx context.Context, limit int) (string, error)
+	RecentPostsStampByTopicID(ctx context.Context, topicID int64, limit int) (string, error)
+	UpdatePost(ctx context.Context, post model.Post) error
+	DeletePost(ctx context.Context, postID int64) error
+}
+
+// TokenStore saves and finds API tokens.
+type TokenStore interface {
+	CreateAPIToken(ctx context.Context, token model.APIToken) (model.APIToken, error)
+	GetAPITokenByHash(ctx context.Context, hash string) (model.APIToken, error)
+	QueryAPITokensByUserID(ctx context.Context, userID int64) ([]model.APIToken, error)
+	DeleteAPIToken(ctx context.Context, userID, tokenID int64) error
+	TouchAPIToken(ctx context.Context, tokenID int64, usedAt time.Time) error
+}
+
+// DeliveryStore saves and finds webhook deliveries.
+type DeliveryStore interface {
+	CreateDelivery(ctx context.Context, delivery model.Delivery) (model.Delivery, error)
+	UpdateDelivery(ctx context.Context, delivery model.Delivery) error
+	GetDeliveryByID(ctx context.Context, deliveryID int64) (model.Delivery, error)
+	QueryRecentDeliveries(ctx context.Context, limit int) ([]model.Delivery, error)
+	QueryPendingDeliveries(ctx context.Context) ([]model.Delivery, error)
+	SetDeliveryStatus(ctx context.Context, deliveryID int64, status string, from ...string) (bool, error)
+}
+
+// Store is everything the forum keeps. Lookups of a single thing return an
+// error wrapping sql.ErrNoRows if there is no such thing, whatever the
+// implementation.
+type Store interface {
+	UserStore
+	TopicStore
+	ThreadStore
+	PostStore
+	TokenStore
+	DeliveryStore
+
+	// WithTx runs fn with a Store for a transaction: if fn returns nil, all
+	// of its changes are saved, and if not, none of them are. Calling WithTx
+	// on the transaction's Store joins the same transaction.
+	WithTx(ctx context.Context, fn func(tx Store) error) error
+
+	// ReadTx runs fn with a Store for a read only transaction, which sees
+	// everything as it was when the transaction began, whatever is written
+	// meanwhile, eg for an export.
+	ReadTx(ctx context.Context, fn func(tx Store) error) error
+}
+
+// The database drivers SQLStore supports.
+const (
+	SQLite   = "sqlite3"
+	Postgres = "postgres"
+)
+
+// SQLStore is a Store kept in a sqlite or PostgreSQL database. The SQL is
+// written for sqlite, with ? placeholders, and adjusted for PostgreSQL.
+//
+// A sqlite file has two pools of connections: one connection for writing, so
+// that writers queue here rather than failing with SQLITE_BUSY, and a pool of
+// read only connections, which run alongside it in WAL mode.
+type SQLStore struct {
+	db     *sql.DB // writes, and everything for PostgreSQL or in memory
+	rdb    *sql.DB // reads, which may be db
+	q      Querier
+	r      Querier
+	driver string
+	opts   Options
+}
+
+var _ Store = (*SQLStore)(nil)
+
+// Open connects to a database with the DefaultOptions. A database starting
+// postgres:// or postgresql:// is PostgreSQL, and anything else is a sqlite
+// file.
+func Open(database string) (*SQLStore, error) {
+	return OpenWith(database, DefaultOptions())
+}
+
+// OpenWith connects to a database, as Open, with the given options.
+func OpenWith(database string, opts Options) (*SQLStore, error) {
+
+	driver := DriverFor(database)
+
+	if driver != SQLite || inMemory(database) {
+		// one pool. an in memory sqlite database only exists for as long as
+		// its one connection.
+		dsn, conns := database, opts.MaxConns
+		if driver == SQLite {
+			dsn, conns = sqliteDSN(database, opts, true), 1
+		}
+
+		db, err := sql.Open(driver, dsn)
+		if err != nil {
+			return nil, fmt.Errorf("cannot open requested database: %w", err)
+		}
+		db.SetMaxOpenConns(conns)
+		db.SetMaxIdleConns(conns)
+
+		s := NewSQLStore(db, driver)
+		s.opts = opts
+		return s, nil
+	}
+
+	db, err := sql.Open(driver, sqliteDSN(database, opts, true))
+	if err != nil {
+		return nil, fmt.Errorf("cannot open requested database: %w", err)
+	}
+	db.SetMaxOpenConns(1)
+
+	rdb, err := sql.Open(driver, sqliteDSN(database, opts, false))
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("cannot open requested database: %w", err)
+	}
+	rdb.SetMaxOpenConns(opts.MaxConns)
+	rdb.SetMaxIdleConns(opts.MaxConns)
+
+	return &SQLStore{db: db, rdb: rdb, q: db, r: rdb, driver: driver, opts: opts}, nil
+}
+
+// DriverFor returns the driver for a database, as understood by Open.
+func DriverFor(database string) string {
+
+	if strings.HasPrefix(database, "postgres://") || strings.HasPrefix(database, "postgresql://") {
+		return Postgres
+	}
+
+	return SQLite
+}
+
+// NewSQLStore returns a Store using an open database, of the given driver,
+// for both reads and writes.
+func NewSQLStore(db *sql.DB, driver string) *SQLStore {
+	return &SQLStore{db: db, rdb: db, q: db, r: db, driver: driver}
+}
+
+// inTx returns a SQLStore which reads and writes in the transaction.
+func (s *SQLStore) inTx(tx *sql.Tx) *SQLStore {
+	return &SQLStore{db: s.db, rdb: s.rdb, q: tx, r: tx, driver: s.driver, opts: s.opts}
+}
+
+// DB returns the underlying database, for writing.
+func (s *SQLStore) DB() *sql.DB {
+	return s.db
+}
+
+// PoolStats returns the statistics of each pool of connections: "writer" and
+// "reader" for a sqlite file, or "all" for the one pool of anything else.
+func (s *SQLStore) PoolStats() map[string]sql.DBStats {
+
+	if s.rdb == s.db {
+		return map[string]sql.DBStats{"all": s.db.Stats()}
+	}
+
+	return map[string]sql.DBStats{
+		"writer": s.db.Stats(),
+		"reader": s.rdb.Stats(),
+	}
+}
+
+// Driver returns SQLite or Postgres.
+func (s *SQLStore) Driver() string {
+	return s.driver
+}
+
+// Close closes the database.
+func (s *SQLStore) Close() error {
+
+	if s.rdb != s.db {
+		s.rdb.Close()
+	}
+
+	return s.db.Close()
+}
+
+// rebind replaces ? placeholders with $1, $2, ... for PostgreSQL. The queries
+// here never have ? in a string.
+func (s *SQLStore) rebind(query string) string {
+
+	if s.driver != Postgres {
+		return query
+	}
+
+	b := &strings.Builder{}
+	n := 0
+	for _, c := range query {
+		if c == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(c)
+	}
+
+	return b.String()
+}
+
+func (s *SQLStore) exec(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return s.q.ExecContext(ctx, s.rebind(query), args...)
+}
+
+func (s *SQLStore) query(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return s.r.QueryContext(ctx, s.rebind(query), args...)
+}
+
+func (s *SQLStore) queryRow(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return s.r.QueryRowContext(ctx, s.rebind(query), args...)
+}
+
+// insert runs an insert into a table with an id column, and returns the new
+// id: from LastInsertId for sqlite, or with "returning id" for PostgreSQL,
+// whose driver has no LastInsertId.
+func (s *SQLStore) insert(ctx context.Context, query string, args ...interface{}) (int64, error) {
+
+	if s.driver == Postgres {
+		var id int64
+		err := s.q.QueryRowContext(ctx, s.rebind(query+" returning id"), args...).Scan(&id)
+		return id, err
+	}
+
+	result, err := s.exec(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.LastInsertId()
+}
+
+// stamp runs a query for a count, an id (the highest, or a sum) and a count of
+// edits, and returns them as one value (see TopicsStamp).
+func (s *SQLStore) stamp(ctx context.Context, query string, args ...interface{}) (string, error) {
+
+	var count, maxID, edits int64
+	err := s.queryRow(ctx, query, args...).Scan(&count, &maxID, &edits)
+	if err != nil {
+		return "", fmt.Errorf("failed to query stamp: %w", err)
+	}
+
+	return fmt.Sprintf("%d:%d:%d", count, maxID, edits), nil
+}
+
+// pageLimit returns the limit argument for a Page. PostgreSQL takes null,
+// rather than -1, for no limit.
+func (s *SQLStore) pageLimit(page Page) interface{} {
+
+	if page.Limit < 0 && s.driver == Postgres {
+		return nil
+	}
+
+	return page.Limit
+}
+
+// WithTx runs fn in a transaction, committing if fn succeeds.
+func (s *SQLStore) WithTx(ctx context.Context, fn func(tx Store) error) error {
+	return s.withTx(ctx, func(tx *SQLStore) error {
+		return fn(tx)
+	})
+}
+
+// ReadTx runs fn in a read only transaction. For sqlite it uses a reader
+// connection, so writers are not held up. PostgreSQL's default isolation
+// shows each statement the latest data, so the transaction asks for
+// repeatable read, which shows every statement the same snapshot.
+func (s *SQLStore) ReadTx(ctx context.Context, fn func(tx Store) error) error {
+
+	if _, ok := s.q.(*sql.Tx); ok {
+		return fn(s)
+	}
+
+	opts := &sql.TxOptions{ReadOnly: true}
+	if s.driver == Postgres {
+		opts.Isolation = sql.LevelRepeatableRead
+	}
+
+	tx, err := s.rdb.BeginTx(ctx, opts)
+	if err != nil {
+		return fmt.Errorf("cannot begin read transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	err = fn(s.inTx(tx))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// withTx runs fn with a SQLStore bound to a transaction, committing if fn
+// succeeds. If s is already in a transaction, fn joins it.
+func (s *SQLStore) withTx(ctx context.Context, fn func(tx *SQLStore) error) error {
+
+	if _, ok := s.q.(*sql.Tx); ok {
+		return fn(s)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("cannot begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	err = fn(s.inTx(tx))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
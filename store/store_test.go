@@ -0,0 +1,439 @@
+package store_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pdk/forum/model"
+	"github.com/pdk/forum/store"
+)
+
+// openStores returns an empty store of each kind available: in memory, cached
+// in memory, sqlite in memory, and PostgreSQL if FORUM_TEST_POSTGRES is set to
+// the URL of a scratch database (eg
+// postgres://localhost/forum_test?sslmode=disable), which will be emptied.
+// Every test of the Store interface runs against each, so that they all behave
+// the same.
+func openStores(t *testing.T) map[string]store.Store {
+
+	ctx := context.Background()
+
+	stores := map[string]*store.SQLStore{}
+
+	db, _ := store.Open(":memory:")
+	stores[store.SQLite] = db
+
+	if url := os.Getenv("FORUM_TEST_POSTGRES"); url != "" {
+		pg, err := store.Open(url)
+		if err != nil {
+			t.Fatalf("expected to open %s, but failed: %v", url, err)
+		}
+
+		for {
+			_, err := pg.MigrateDown(ctx)
+			if errors.Is(err, sql.ErrNoRows) {
+				break
+			}
+			if err != nil {
+				t.Fatalf("expected to empty %s, but failed: %v", url, err)
+			}
+		}
+
+		stores[store.Postgres] = pg
+	}
+
+	for name, s := range stores {
+		_, err := s.MigrateUp(ctx)
+		if err != nil {
+			t.Fatalf("expected to migrate %s, but failed: %v", name, err)
+		}
+		t.Cleanup(func() { s.Close() })
+	}
+
+	all := map[string]store.Store{
+		"memory": store.NewMemoryStore(),
+		"cached": store.NewCachedStore(store.NewMemoryStore(), 100, time.Minute),
+	}
+	for name, s := range stores {
+		all[name] = s
+	}
+
+	return all
+}
+
+func TestStore(t *testing.T) {
+
+	ctx := context.Background()
+
+	for name, s := range openStores(t) {
+		t.Run(name, func(t *testing.T) {
+
+			pdk, err := s.CreateUser(ctx, model.NewUser("pdk"))
+			if err != nil {
+				t.Fatalf("expected to create user, but failed: %v", err)
+			}
+
+			_, err = s.CreateUser(ctx, model.NewUser("pdk"))
+			if !store.IsDuplicate(err) {
+				t.Errorf("expected a duplicate user error, but got %v", err)
+			}
+
+			for _, name := range []string{"rust", "Go", "ada"} {
+				_, err = s.CreateTopic(ctx, model.NewTopic(pdk.ID, name))
+				if err != nil {
+					t.Fatalf("expected to create topic %s, but failed: %v", name, err)
+				}
+			}
+
+			_, err = s.CreateTopic(ctx, model.NewTopic(pdk.ID, "Go"))
+			if !store.IsDuplicate(err) {
+				t.Errorf("expected a duplicate topic error, but got %v", err)
+			}
+
+			_, err = s.GetTopicByName(ctx, "go")
+			if !errors.Is(err, sql.ErrNoRows) {
+				t.Errorf("expected topic names to match exactly, but got %v", err)
+			}
+
+			topics, err := s.ListTopics(ctx, store.AllRows)
+			if err != nil || len(topics) != 3 || topics[0].Name != "ada" || topics[1].Name != "Go" {
+				t.Errorf("expected topics ada, Go, rust, but got %v, %v", topics, err)
+			}
+
+			topics, _ = s.ListTopics(ctx, store.Page{Limit: 1, Offset: 2})
+			if len(topics) != 1 || topics[0].Name != "rust" {
+				t.Errorf("expected page with rust, but got %v", topics)
+			}
+
+			thread, err := s.CreateThread(ctx, model.NewThread(topics[0].ID, pdk.ID, "hello"))
+			if err != nil {
+				t.Fatalf("expected to create thread, but failed: %v", err)
+			}
+
+			newer, _ := s.CreateThread(ctx, model.NewThread(topics[0].ID, pdk.ID, "again"))
+			threads, err := s.ListThreadsByTopicID(ctx, topics[0].ID, store.AllRows)
+			if err != nil || len(threads) != 2 || threads[0].ID != newer.ID {
+				t.Errorf("expected newest thread first, but got %v, %v", threads, err)
+			}
+
+			thread.Locked = true
+			err = s.UpdateThread(ctx, thread)
+			if err != nil {
+				t.Fatalf("expected to lock thread, but failed: %v", err)
+			}
+
+			found, err := s.GetThreadByID(ctx, thread.ID)
+			if err != nil || !found.Locked {
+				t.Errorf("expected locked thread, but got %v, %v", found, err)
+			}
+
+			for _, body := range []string{"one", "two", "three"} {
+				_, err = s.CreatePost(ctx, model.NewPost(thread.ID, pdk.ID, body))
+				if err != nil {
+					t.Fatalf("expected to create post, but failed: %v", err)
+				}
+			}
+
+			posts, err := s.QueryPostsAfterID(ctx, thread.ID, 0, 2)
+			if err != nil || len(posts) != 2 || posts[0].Body != "one" {
+				t.Errorf("expected posts one and two, but got %v, %v", posts, err)
+			}
+
+			recent, err := s.QueryRecentPostsByTopicID(ctx, topics[0].ID, 10)
+			if err != nil || len(recent) != 3 || recent[0].Body != "three" {
+				t.Errorf("expected newest post first, but got %v, %v", recent, err)
+			}
+
+			err = s.DeleteTopic(ctx, topics[0].ID)
+			if err != nil {
+				t.Errorf("expected to delete topic, but failed: %v", err)
+			}
+
+			_, err = s.GetPostByID(ctx, posts[0].ID)
+			if !errors.Is(err, sql.ErrNoRows) {
+				t.Errorf("expected post to be deleted with its topic, but got %v", err)
+			}
+		})
+	}
+}
+
+func TestTokensAndDeliveries(t *testing.T) {
+
+	ctx := context.Background()
+
+	for name, s := range openStores(t) {
+		t.Run(name, func(t *testing.T) {
+
+			pdk, _ := s.CreateUser(ctx, model.NewUser("pdk"))
+
+			token, err := s.CreateAPIToken(ctx, model.NewAPIToken(pdk.ID, "laptop", "abc", []string{model.ScopeRead}, time.Time{}))
+			if err != nil {
+				t.Fatalf("expected to create token, but failed: %v", err)
+			}
+
+			_, err = s.CreateAPIToken(ctx, model.NewAPIToken(pdk.ID, "phone", "abc", []string{model.ScopeRead}, time.Time{}))
+			if !store.IsDuplicate(err) {
+				t.Errorf("expected a duplicate hash error, but got %v", err)
+			}
+
+			used := time.Now()
+			err = s.TouchAPIToken(ctx, token.ID, used)
+			if err != nil {
+				t.Errorf("expected to touch token, but failed: %v", err)
+			}
+
+			found, err := s.GetAPITokenByHash(ctx, "abc")
+			if err != nil || found.ID != token.ID || !found.ExpiresAt.IsZero() || found.LastUsedAt.Unix() != used.Unix() {
+				t.Errorf("expected token %d used at %s, but got %v, %v", token.ID, used, found, err)
+			}
+
+			err = s.DeleteAPIToken(ctx, pdk.ID+1, token.ID)
+			if !errors.Is(err, sql.ErrNoRows) {
+				t.Errorf("expected to be unable to delete another user's token, but got %v", err)
+			}
+
+			err = s.DeleteAPIToken(ctx, pdk.ID, token.ID)
+			if err != nil {
+				t.Errorf("expected to delete token, but failed: %v", err)
+			}
+
+			_, err = s.GetAPITokenByHash(ctx, "abc")
+			if !errors.Is(err, sql.ErrNoRows) {
+				t.Errorf("expected token to be deleted, but got %v", err)
+			}
+
+			first, _ := s.CreateDelivery(ctx, model.NewDelivery("post.created", 0, "http://example.com/", "{}"))
+			second, _ := s.CreateDelivery(ctx, model.NewDelivery("post.created", 0, "http://example.com/", "{}"))
+
+			first.Status = model.DeliveryDelivered
+			first.Attempts = 1
+			err = s.UpdateDelivery(ctx, first)
+			if err != nil {
+				t.Errorf("expected to update delivery, but failed: %v", err)
+			}
+
+			pending, err := s.QueryPendingDeliveries(ctx)
+			if err != nil || len(pending) != 1 || pending[0].ID != second.ID {
+				t.Errorf("expected delivery %d pending, but got %v, %v", second.ID, pending, err)
+			}
+
+			recent, err := s.QueryRecentDeliveries(ctx, 1)
+			if err != nil || len(recent) != 1 || recent[0].ID != second.ID {
+				t.Errorf("expected delivery %d most recent, but got %v, %v", second.ID, recent, err)
+			}
+
+			_, err = s.GetDeliveryByID(ctx, second.ID+1)
+			if !errors.Is(err, sql.ErrNoRows) {
+				t.Errorf("expected sql.ErrNoRows, but got %v", err)
+			}
+
+			claimed, err := s.SetDeliveryStatus(ctx, second.ID, model.DeliverySending, model.DeliveryPending)
+			if err != nil || !claimed {
+				t.Errorf("expected to claim delivery %d, but got %t, %v", second.ID, claimed, err)
+			}
+
+			claimed, err = s.SetDeliveryStatus(ctx, second.ID, model.DeliverySending, model.DeliveryPending)
+			if err != nil || claimed {
+				t.Errorf("expected delivery %d to be claimed only once, but got %t, %v", second.ID, claimed, err)
+			}
+
+			got, err := s.GetDeliveryByID(ctx, second.ID)
+			if err != nil || got.Status != model.DeliverySending || got.Hook != 0 {
+				t.Errorf("expected delivery %d sending to hook 0, but got %v, %v", second.ID, got, err)
+			}
+		})
+	}
+}
+
+func TestSQLiteOptions(t *testing.T) {
+
+	ctx := context.Background()
+
+	db, err := store.Open(filepath.Join(t.TempDir(), "forum.db"))
+	if err != nil {
+		t.Fatalf("expected to open database, but failed: %v", err)
+	}
+	defer db.Close()
+
+	_, err = db.MigrateUp(ctx)
+	if err != nil {
+		t.Fatalf("expected to migrate, but failed: %v", err)
+	}
+
+	report, err := db.CheckPragmas(ctx)
+	if err != nil || !strings.Contains(report, "journal_mode=wal") || !strings.Contains(report, "foreign_keys=1") {
+		t.Errorf("expected wal and foreign keys, but got %q, %v", report, err)
+	}
+
+	pdk, _ := db.CreateUser(ctx, model.NewUser("pdk"))
+
+	_, err = db.CreateThread(ctx, model.NewThread(99, pdk.ID, "nowhere"))
+	if err == nil {
+		t.Errorf("expected a thread in a missing topic to be refused")
+	}
+}
+
+func TestCheckDatabase(t *testing.T) {
+
+	dir := t.TempDir()
+
+	tests := map[string]string{
+		filepath.Join(dir, "forum.db"):            "",
+		filepath.Join(dir, "missing", "forum.db"): "cannot create",
+		":memory:":                   "",
+		"postgres://localhost/forum": "",
+	}
+
+	for database, expected := range tests {
+		err := store.CheckDatabase(database)
+		if expected == "" && err != nil {
+			t.Errorf("expected %s to be usable, but got %v", database, err)
+		}
+		if expected != "" && (err == nil || !strings.Contains(err.Error(), expected)) {
+			t.Errorf("expected %s to fail with %q, but got %v", database, expected, err)
+		}
+	}
+}
+
+func TestWithTx(t *testing.T) {
+
+	ctx := context.Background()
+
+	for name, s := range openStores(t) {
+		t.Run(name, func(t *testing.T) {
+
+			pdk, _ := s.CreateUser(ctx, model.NewUser("pdk"))
+			topic, _ := s.CreateTopic(ctx, model.NewTopic(pdk.ID, "golang"))
+
+			failed := errors.New("failed")
+			err := s.WithTx(ctx, func(tx store.Store) error {
+
+				thread, err := tx.CreateThread(ctx, model.NewThread(topic.ID, pdk.ID, "hello"))
+				if err != nil {
+					return err
+				}
+
+				return tx.WithTx(ctx, func(tx store.Store) error {
+					_, err := tx.CreatePost(ctx, model.NewPost(thread.ID, pdk.ID, "first"))
+					if err != nil {
+						return err
+					}
+					return failed
+				})
+			})
+			if err != failed {
+				t.Errorf("expected the transaction's error, but got %v", err)
+			}
+
+			threads, _ := s.ListThreadsByTopicID(ctx, topic.ID, store.AllRows)
+			posts, _ := s.QueryRecentPosts(ctx, 10)
+			if len(threads) != 0 || len(posts) != 0 {
+				t.Errorf("expected nothing saved, but got %d threads and %d posts", len(threads), len(posts))
+			}
+
+			err = s.WithTx(ctx, func(tx store.Store) error {
+				thread, err := tx.CreateThread(ctx, model.NewThread(topic.ID, pdk.ID, "hello"))
+				if err != nil {
+					return err
+				}
+				_, err = tx.CreatePost(ctx, model.NewPost(thread.ID, pdk.ID, "first"))
+				return err
+			})
+			if err != nil {
+				t.Errorf("expected to save thread and post, but failed: %v", err)
+			}
+
+			threads, _ = s.ListThreadsByTopicID(ctx, topic.ID, store.AllRows)
+			posts, _ = s.QueryRecentPosts(ctx, 10)
+			if len(threads) != 1 || len(posts) != 1 || posts[0].ThreadID != threads[0].ID {
+				t.Errorf("expected one thread with its post, but got %v and %v", threads, posts)
+			}
+		})
+	}
+}
+
+func TestStamps(t *testing.T) {
+
+	ctx := context.Background()
+
+	for name, s := range openStores(t) {
+		t.Run(name, func(t *testing.T) {
+
+			pdk, _ := s.CreateUser(ctx, model.NewUser("pdk"))
+			topic, _ := s.CreateTopic(ctx, model.NewTopic(pdk.ID, "golang"))
+			thread, _ := s.CreateThread(ctx, model.NewThread(topic.ID, pdk.ID, "hello"))
+			post, _ := s.CreatePost(ctx, model.NewPost(thread.ID, pdk.ID, "first"))
+
+			stamps := func() []string {
+				topics, err1 := s.TopicsStamp(ctx)
+				threads, err2 := s.ThreadsStamp(ctx, topic.ID)
+				posts, err3 := s.PostsStamp(ctx, thread.ID)
+				recent, err4 := s.RecentPostsStamp(ctx, 10)
+				inTopic, err5 := s.RecentPostsStampByTopicID(ctx, topic.ID, 10)
+				for _, err := range []error{err1, err2, err3, err4, err5} {
+					if err != nil {
+						t.Fatalf("expected stamps, but failed: %v", err)
+					}
+				}
+				return []string{topics, threads, posts, recent, inTopic}
+			}
+
+			// the stores in memory count every write, so their stamps change
+			// more often than needed.
+			exact := name == store.SQLite || name == store.Postgres
+
+			for _, change := range []struct {
+				what    string
+				changed []bool // topics, threads, posts, recent, recent in topic
+				change  func() error
+			}{
+				{"rename topic", []bool{true, false, false, false, false}, func() error {
+					topic.Name = "go"
+					return s.UpdateTopic(ctx, topic)
+				}},
+				{"lock thread", []bool{false, true, false, true, true}, func() error {
+					thread.Locked = true
+					return s.UpdateThread(ctx, thread)
+				}},
+				{"edit post", []bool{false, false, true, true, true}, func() error {
+					post.Body = "edited"
+					return s.UpdatePost(ctx, post)
+				}},
+				{"rename poster", []bool{false, false, true, true, true}, func() error {
+					pdk.Name = "paul"
+					return s.UpdateUser(ctx, pdk)
+				}},
+				{"add post", []bool{false, false, true, true, true}, func() error {
+					_, err := s.CreatePost(ctx, model.NewPost(thread.ID, pdk.ID, "second"))
+					return err
+				}},
+				{"delete post", []bool{false, false, true, true, true}, func() error {
+					return s.DeletePost(ctx, post.ID)
+				}},
+			} {
+				before := stamps()
+
+				err := change.change()
+				if err != nil {
+					t.Fatalf("expected to %s, but failed: %v", change.what, err)
+				}
+
+				after := stamps()
+				for i, changed := range change.changed {
+					if changed && before[i] == after[i] {
+						t.Errorf("expected stamp %d to change after %s, but it is still %s", i, change.what, after[i])
+					}
+					if exact && !changed && before[i] != after[i] {
+						t.Errorf("expected stamp %d to stay the same after %s, but got %s then %s", i, change.what, before[i], after[i])
+					}
+				}
+			}
+		})
+	}
+}
@@ -1,42 +1,40 @@
 package store
 
 import (
-	"database/sql"
+	"context"
 	"fmt"
 
 	"github.com/pdk/forum/model"
 )
 
 // CreateThread will insert a Thread into the database and return a modified Thread (ie with a new ID).
-func CreateThread(db *sql.DB, thread model.Thread) (model.Thread, error) {
+func (s *SQLStore) CreateThread(ctx context.Context, thread model.Thread) (model.Thread, error) {
 
-	result, err := db.Exec(`insert into threads (topic_id, created_by_id, subject) values (?,?,?)`,
-		thread.TopicID, thread.CreatedByID, thread.Subject)
+	var err error
+	thread.ID, err = s.insert(ctx, `insert into threads (topic_id, created_by_id, subject, locked) values (?,?,?,?)`,
+		thread.TopicID, thread.CreatedByID, thread.Subject, thread.Locked)
 	if err != nil {
 		return thread, fmt.Errorf("failed to save thread %s: %w", thread.Subject, err)
 	}
 
-	thread.ID, err = result.LastInsertId()
-	if err != nil {
-		return thread, fmt.Errorf("failed to get new ID for thread %s: %w", thread.Subject, err)
-	}
-
 	return thread, nil
 }
 
-// QueryThreadsByTopicID returns the list of threads for a topic.
-func QueryThreadsByTopicID(db *sql.DB, topicID int64) ([]model.Thread, error) {
+// ListThreadsByTopicID returns one page of the threads for a topic, newest
+// first.
+func (s *SQLStore) ListThreadsByTopicID(ctx context.Context, topicID int64, page Page) ([]model.Thread, error) {
 
 	threadList := []model.Thread{}
 
-	rows, err := db.Query(`select id, topic_id, created_by_id, subject from threads where topic_id = ? order by id desc`, topicID)
+	rows, err := s.query(ctx, `select id, topic_id, created_by_id, subject, locked from threads where topic_id = ? order by id desc limit ? offset ?`, topicID, s.pageLimit(page), page.Offset)
 	if err != nil {
 		return threadList, fmt.Errorf("failed to query threads: %w", err)
 	}
+	defer rows.Close()
 
 	for rows.Next() {
 		nextThread := model.Thread{}
-		err := rows.Scan(&nextThread.ID, &nextThread.TopicID, &nextThread.CreatedByID, &nextThread.Subject)
+		err := rows.Scan(&nextThread.ID, &nextThread.TopicID, &nextThread.CreatedByID, &nextThread.Subject, &nextThread.Locked)
 		if err != nil {
 			return threadList, fmt.Errorf("failed to scan a thread: %w", err)
 		}
@@ -48,12 +46,41 @@ func QueryThreadsByTopicID(db *sql.DB, topicID int64) ([]model.Thread, error) {
 
 }
 
+// ThreadsStamp returns a value which changes whenever a thread of the topic is
+// added, edited or moved away, without reading the threads.
+func (s *SQLStore) ThreadsStamp(ctx context.Context, topicID int64) (string, error) {
+
+	return s.stamp(ctx, `select count(*), coalesce(max(id), 0), coalesce(sum(edits), 0) from threads where topic_id = ?`, topicID)
+}
+
 // GetThreadByID gets one thread or returns sql.ErrNoRows
-func GetThreadByID(db *sql.DB, threadID int64) (model.Thread, error) {
+func (s *SQLStore) GetThreadByID(ctx context.Context, threadID int64) (model.Thread, error) {
+
+	thread := model.Thread{}
+	err := s.queryRow(ctx, `select id, topic_id, created_by_id, subject, locked from threads where id = ?`, threadID).
+		Scan(&thread.ID, &thread.TopicID, &thread.CreatedByID, &thread.Subject, &thread.Locked)
+
+	if err != nil {
+		return thread, fmt.Errorf("cannot get thread %d: %w", threadID, err)
+	}
+
+	return thread, nil
+}
+
+// GetThreadForUpdate gets one thread, as GetThreadByID does, and in a
+// transaction keeps it from being changed (eg locked) until the transaction
+// ends. sqlite transactions write one at a time, so only PostgreSQL needs a
+// row lock.
+func (s *SQLStore) GetThreadForUpdate(ctx context.Context, threadID int64) (model.Thread, error) {
+
+	query := `select id, topic_id, created_by_id, subject, locked from threads where id = ?`
+	if s.driver == Postgres {
+		query += ` for update`
+	}
 
 	thread := model.Thread{}
-	err := db.QueryRow(`select id, topic_id, created_by_id, subject from threads where id = ?`, threadID).
-		Scan(&thread.ID, &thread.TopicID, &thread.CreatedByID, &thread.Subject)
+	err := s.queryRow(ctx, query, threadID).
+		Scan(&thread.ID, &thread.TopicID, &thread.CreatedByID, &thread.Subject, &thread.Locked)
 
 	if err != nil {
 		return thread, fmt.Errorf("cannot get thread %d: %w", threadID, err)
@@ -61,3 +88,15 @@ func GetThreadByID(db *sql.DB, threadID int64) (model.Thread, error) {
 
 	return thread, nil
 }
+
+// UpdateThread saves the topic, subject and lock of an existing thread.
+func (s *SQLStore) UpdateThread(ctx context.Context, thread model.Thread) error {
+
+	_, err := s.exec(ctx, `update threads set topic_id = ?, subject = ?, locked = ?, edits = edits + 1 where id = ?`,
+		thread.TopicID, thread.Subject, thread.Locked, thread.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update thread %d: %w", thread.ID, err)
+	}
+
+	return nil
+}
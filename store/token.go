@@ -0,0 +1,115 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/pdk/forum/model"
+)
+
+// CreateAPIToken will insert an APIToken into the database and return a modified APIToken (ie with a new ID).
+func (s *SQLStore) CreateAPIToken(ctx context.Context, token model.APIToken) (model.APIToken, error) {
+
+	var err error
+	token.ID, err = s.insert(ctx, `insert into api_tokens (user_id, name, hash, scopes, created_at, expires_at) values (?,?,?,?,?,?)`,
+		token.UserID, token.Name, token.Hash, token.Scopes, token.CreatedAt, nullTime(token.ExpiresAt))
+	if err != nil {
+		return token, fmt.Errorf("failed to save token %s: %w", token.Name, err)
+	}
+
+	return token, nil
+}
+
+// GetAPITokenByHash gets the token with the given hash, or returns
+// sql.ErrNoRows.
+func (s *SQLStore) GetAPITokenByHash(ctx context.Context, hash string) (model.APIToken, error) {
+
+	rows, err := s.query(ctx, `select id, user_id, name, hash, scopes, created_at, expires_at, last_used_at from api_tokens where hash = ?`, hash)
+	if err != nil {
+		return model.APIToken{}, fmt.Errorf("failed to query token: %w", err)
+	}
+
+	tokenList, err := scanAPITokens(rows)
+	if err != nil {
+		return model.APIToken{}, err
+	}
+
+	if len(tokenList) == 0 {
+		return model.APIToken{}, sql.ErrNoRows
+	}
+
+	return tokenList[0], nil
+}
+
+// QueryAPITokensByUserID returns the tokens belonging to a user, newest first.
+func (s *SQLStore) QueryAPITokensByUserID(ctx context.Context, userID int64) ([]model.APIToken, error) {
+
+	rows, err := s.query(ctx, `select id, user_id, name, hash, scopes, created_at, expires_at, last_used_at from api_tokens where user_id = ? order by id desc`, userID)
+	if err != nil {
+		return []model.APIToken{}, fmt.Errorf("failed to query tokens for user %d: %w", userID, err)
+	}
+
+	return scanAPITokens(rows)
+}
+
+// DeleteAPIToken revokes one of a user's tokens. Returns sql.ErrNoRows if the
+// user has no such token.
+func (s *SQLStore) DeleteAPIToken(ctx context.Context, userID, tokenID int64) error {
+
+	result, err := s.exec(ctx, `delete from api_tokens where id = ? and user_id = ?`, tokenID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete token %d: %w", tokenID, err)
+	}
+
+	count, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to delete token %d: %w", tokenID, err)
+	}
+
+	if count == 0 {
+		return fmt.Errorf("cannot delete token %d: %w", tokenID, sql.ErrNoRows)
+	}
+
+	return nil
+}
+
+// TouchAPIToken records that a token has been used.
+func (s *SQLStore) TouchAPIToken(ctx context.Context, tokenID int64, usedAt time.Time) error {
+
+	_, err := s.exec(ctx, `update api_tokens set last_used_at = ? where id = ?`, usedAt, tokenID)
+	if err != nil {
+		return fmt.Errorf("failed to update token %d: %w", tokenID, err)
+	}
+
+	return nil
+}
+
+func scanAPITokens(rows *sql.Rows) ([]model.APIToken, error) {
+
+	tokenList := []model.APIToken{}
+	defer rows.Close()
+
+	for rows.Next() {
+		token := model.APIToken{}
+		var expiresAt, lastUsedAt sql.NullTime
+
+		err := rows.Scan(&token.ID, &token.UserID, &token.Name, &token.Hash, &token.Scopes, &token.CreatedAt, &expiresAt, &lastUsedAt)
+		if err != nil {
+			return tokenList, fmt.Errorf("failed to scan a token: %w", err)
+		}
+
+		token.ExpiresAt = expiresAt.Time
+		token.LastUsedAt = lastUsedAt.Time
+
+		tokenList = append(tokenList, token)
+	}
+
+	return tokenList, nil
+}
+
+// nullTime stores the zero time as null.
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
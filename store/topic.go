@@ -1,6 +1,7 @@
 package store
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 
@@ -8,30 +9,27 @@ import (
 )
 
 // CreateTopic will insert a Topic into the database and return a modified Topic (ie with a new ID).
-func CreateTopic(db *sql.DB, topic model.Topic) (model.Topic, error) {
+func (s *SQLStore) CreateTopic(ctx context.Context, topic model.Topic) (model.Topic, error) {
 
-	result, err := db.Exec(`insert into topics (created_by_id, name) values (?,?)`, topic.CreatedByID, topic.Name)
+	var err error
+	topic.ID, err = s.insert(ctx, `insert into topics (created_by_id, name) values (?,?)`, topic.CreatedByID, topic.Name)
 	if err != nil {
 		return topic, fmt.Errorf("failed to save topic %s: %w", topic.Name, err)
 	}
 
-	topic.ID, err = result.LastInsertId()
-	if err != nil {
-		return topic, fmt.Errorf("failed to get new ID for topic %s: %w", topic.Name, err)
-	}
-
 	return topic, nil
 }
 
-// QueryTopics query all the topics and return them.
-func QueryTopics(db *sql.DB) ([]model.Topic, error) {
+// ListTopics returns one page of the topics, ordered by name.
+func (s *SQLStore) ListTopics(ctx context.Context, page Page) ([]model.Topic, error) {
 
 	topicList := []model.Topic{}
 
-	rows, err := db.Query(`select id, created_by_id, name from topics order by upper(name)`)
+	rows, err := s.query(ctx, `select id, created_by_id, name from topics order by upper(name), id limit ? offset ?`, s.pageLimit(page), page.Offset)
 	if err != nil {
 		return topicList, fmt.Errorf("failed to query topics: %w", err)
 	}
+	defer rows.Close()
 
 	for rows.Next() {
 		nextTopic := model.Topic{}
@@ -46,11 +44,18 @@ func QueryTopics(db *sql.DB) ([]model.Topic, error) {
 	return topicList, nil
 }
 
+// TopicsStamp returns a value which changes whenever a topic is added, edited
+// or deleted, without reading the topics.
+func (s *SQLStore) TopicsStamp(ctx context.Context) (string, error) {
+
+	return s.stamp(ctx, `select count(*), coalesce(max(id), 0), coalesce(sum(edits), 0) from topics`)
+}
+
 // GetTopicByID gets one topic or returns sql.ErrNoRows
-func GetTopicByID(db *sql.DB, topicID int64) (model.Topic, error) {
+func (s *SQLStore) GetTopicByID(ctx context.Context, topicID int64) (model.Topic, error) {
 
 	topic := model.Topic{}
-	err := db.QueryRow(`select id, created_by_id, name from topics where id = ?`, topicID).
+	err := s.queryRow(ctx, `select id, created_by_id, name from topics where id = ?`, topicID).
 		Scan(&topic.ID, &topic.CreatedByID, &topic.Name)
 
 	if err != nil {
@@ -59,3 +64,62 @@ func GetTopicByID(db *sql.DB, topicID int64) (model.Topic, error) {
 
 	return topic, nil
 }
+
+// GetTopicByName gets one topic or returns sql.ErrNoRows
+func (s *SQLStore) GetTopicByName(ctx context.Context, name string) (model.Topic, error) {
+
+	topic := model.Topic{}
+	err := s.queryRow(ctx, `select id, created_by_id, name from topics where name = ?`, name).
+		Scan(&topic.ID, &topic.CreatedByID, &topic.Name)
+
+	if err != nil {
+		return topic, fmt.Errorf("cannot get topic %s: %w", name, err)
+	}
+
+	return topic, nil
+}
+
+// UpdateTopic saves the name of an existing topic.
+func (s *SQLStore) UpdateTopic(ctx context.Context, topic model.Topic) error {
+
+	_, err := s.exec(ctx, `update topics set name = ?, edits = edits + 1 where id = ?`, topic.Name, topic.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update topic %d: %w", topic.ID, err)
+	}
+
+	return nil
+}
+
+// DeleteTopic deletes a topic, along with all of its threads and their posts.
+// Returns sql.ErrNoRows if there is no such topic.
+func (s *SQLStore) DeleteTopic(ctx context.Context, topicID int64) error {
+
+	return s.withTx(ctx, func(tx *SQLStore) error {
+
+		_, err := tx.exec(ctx, `delete from posts where thread_id in (select id from threads where topic_id = ?)`, topicID)
+		if err != nil {
+			return fmt.Errorf("failed to delete posts of topic %d: %w", topicID, err)
+		}
+
+		_, err = tx.exec(ctx, `delete from threads where topic_id = ?`, topicID)
+		if err != nil {
+			return fmt.Errorf("failed to delete threads of topic %d: %w", topicID, err)
+		}
+
+		result, err := tx.exec(ctx, `delete from topics where id = ?`, topicID)
+		if err != nil {
+			return fmt.Errorf("failed to delete topic %d: %w", topicID, err)
+		}
+
+		count, err := result.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("failed to delete topic %d: %w", topicID, err)
+		}
+
+		if count == 0 {
+			return fmt.Errorf("cannot delete topic %d: %w", topicID, sql.ErrNoRows)
+		}
+
+		return nil
+	})
+}
@@ -1,6 +1,7 @@
 package store
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 
@@ -8,64 +9,96 @@ import (
 )
 
 // CreateUser will insert a User into the database and return a modified User (ie with a new ID).
-func CreateUser(db *sql.DB, user model.User) (model.User, error) {
+func (s *SQLStore) CreateUser(ctx context.Context, user model.User) (model.User, error) {
 
-	result, err := db.Exec(`insert into users (joined_at, name) values (?,?)`, user.JoinedAt, user.Name)
+	var err error
+	user.ID, err = s.insert(ctx, `insert into users (joined_at, name, role, banned) values (?,?,?,?)`,
+		user.JoinedAt, user.Name, user.Role, user.Banned)
 	if err != nil {
 		return user, fmt.Errorf("failed to save user %s: %w", user.Name, err)
 	}
 
-	user.ID, err = result.LastInsertId()
-	if err != nil {
-		return user, fmt.Errorf("failed to get new ID for user %s: %w", user.Name, err)
-	}
-
 	return user, nil
 }
 
 // GetUserByID will query and return a User by ID. If no user matches,
 // sql.ErrNoRows will be returned as the error.
-func GetUserByID(db *sql.DB, userID int64) (model.User, error) {
+func (s *SQLStore) GetUserByID(ctx context.Context, userID int64) (model.User, error) {
 
 	user := model.User{}
 
-	err := db.QueryRow(`select id, joined_at, name from users where id = ?`, userID).
-		Scan(&user.ID, &user.JoinedAt, &user.Name)
+	err := s.queryRow(ctx, `select id, joined_at, name, role, banned from users where id = ?`, userID).
+		Scan(&user.ID, &user.JoinedAt, &user.Name, &user.Role, &user.Banned)
 
 	return user, err
 }
 
 // GetUserByName will query and return a User by ID. If no user matches,
 // sql.ErrNoRows will be returned as the error.
-func GetUserByName(db *sql.DB, name string) (model.User, error) {
+func (s *SQLStore) GetUserByName(ctx context.Context, name string) (model.User, error) {
 
 	user := model.User{}
 
-	err := db.QueryRow(`select id, joined_at, name from users where name = ?`, name).
-		Scan(&user.ID, &user.JoinedAt, &user.Name)
+	err := s.queryRow(ctx, `select id, joined_at, name, role, banned from users where name = ?`, name).
+		Scan(&user.ID, &user.JoinedAt, &user.Name, &user.Role, &user.Banned)
 
 	return user, err
 }
 
+// ListUsers returns one page of the users, in the order they joined.
+func (s *SQLStore) ListUsers(ctx context.Context, page Page) ([]model.User, error) {
+
+	userList := []model.User{}
+
+	rows, err := s.query(ctx, `select id, joined_at, name, role, banned from users order by id limit ? offset ?`, s.pageLimit(page), page.Offset)
+	if err != nil {
+		return userList, fmt.Errorf("failed to query users: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		nextUser := model.User{}
+		err := rows.Scan(&nextUser.ID, &nextUser.JoinedAt, &nextUser.Name, &nextUser.Role, &nextUser.Banned)
+		if err != nil {
+			return userList, fmt.Errorf("failed to scan a user: %w", err)
+		}
+
+		userList = append(userList, nextUser)
+	}
+
+	return userList, nil
+}
+
+// UpdateUser saves the name, role and ban of an existing user.
+func (s *SQLStore) UpdateUser(ctx context.Context, user model.User) error {
+
+	_, err := s.exec(ctx, `update users set name = ?, role = ?, banned = ?, edits = edits + 1 where id = ?`, user.Name, user.Role, user.Banned, user.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update user %d: %w", user.ID, err)
+	}
+
+	return nil
+}
+
 // GetOrCreateUserByName will return either an existing user, or a newly created
-// user, with the given name.
-func GetOrCreateUserByName(db *sql.DB, name string) (model.User, error) {
+// user, with the given name. The bool result is true if the user was created.
+func (s *SQLStore) GetOrCreateUserByName(ctx context.Context, name string) (model.User, bool, error) {
 
-	user, err := GetUserByName(db, name)
+	user, err := s.GetUserByName(ctx, name)
 	if err == nil {
-		return user, nil
+		return user, false, nil
 	}
 
 	if err != sql.ErrNoRows {
-		return model.User{}, fmt.Errorf("failed to get/create user %s: %w", name, err)
+		return model.User{}, false, fmt.Errorf("failed to get/create user %s: %w", name, err)
 	}
 
 	user = model.NewUser(name)
 
-	user, err = CreateUser(db, user)
+	user, err = s.CreateUser(ctx, user)
 	if err != nil {
-		return user, fmt.Errorf("failed to get/create user %s: %w", name, err)
+		return user, false, fmt.Errorf("failed to get/create user %s: %w", name, err)
 	}
 
-	return user, nil
+	return user, true, nil
 }
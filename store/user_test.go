@@ -1,6 +1,7 @@
 package store_test
 
 import (
+	"context"
 	"database/sql"
 	"testing"
 	"time"
@@ -11,62 +12,90 @@ import (
 
 func TestCreateQueryUser(t *testing.T) {
 
-	db, _ := store.NewConnection(":memory:")
-
-	createTableUsersStmt := `
-	create table if not exists users (
-		id integer primary key autoincrement,
-		joined_at timestamp not null,
-		name varchar not null unique
-	);
-	`
-
-	_, err := db.Exec(createTableUsersStmt)
-	if err != nil {
-		t.Errorf("expected to create table users, but failed: %w", err)
-	}
-
-	user := model.NewUser("pdk")
-
-	user, err = store.CreateUser(db, user)
-	if err != nil {
-		t.Errorf("expected to create user record, but failed: %w", err)
-	}
-
-	if user.ID != 1 {
-		t.Errorf("expected user.ID to be 1, but got: %d", user.ID)
-	}
-
-	foundUser, err := store.GetUserByName(db, user.Name)
-	if err != nil {
-		t.Errorf("expected to find user %s, but failed: %w", user.Name, err)
-	}
-
-	if foundUser.ID != user.ID {
-		t.Errorf("expected user IDs to match %d, but got %d", user.ID, foundUser.ID)
-	}
-
-	// sqlite does not preserve microseconds, so truncate for comparison. also,
-	// exact timezone is not preserved, so compare in UTC.
-	t1 := foundUser.JoinedAt.Truncate(time.Millisecond).UTC()
-	t2 := user.JoinedAt.Truncate(time.Millisecond).UTC()
-	if t1 != t2 {
-		t.Errorf("expected user JoinedAt to match %s, but got %s", t2, t1)
-	}
-
-	if foundUser.Name != user.Name {
-		t.Errorf("expected user Names to match %s, but got %s", user.Name, foundUser.Name)
-	}
-
-	noUser, err := store.GetUserByName(db, "nobody")
-	if err != sql.ErrNoRows {
-		t.Errorf("expected to get sql.ErrNoRows, but got %v", err)
+	ctx := context.Background()
+
+	for name, db := range openStores(t) {
+		t.Run(name, func(t *testing.T) {
+
+			user := model.NewUser("pdk")
+
+			user, err := db.CreateUser(ctx, user)
+			if err != nil {
+				t.Errorf("expected to create user record, but failed: %v", err)
+			}
+
+			if user.ID != 1 {
+				t.Errorf("expected user.ID to be 1, but got: %d", user.ID)
+			}
+
+			foundUser, err := db.GetUserByName(ctx, user.Name)
+			if err != nil {
+				t.Errorf("expected to find user %s, but failed: %v", user.Name, err)
+			}
+
+			if foundUser.ID != user.ID {
+				t.Errorf("expected user IDs to match %d, but got %d", user.ID, foundUser.ID)
+			}
+
+			// sqlite does not preserve microseconds, so truncate for comparison. also,
+			// exact timezone is not preserved, so compare in UTC.
+			t1 := foundUser.JoinedAt.Truncate(time.Millisecond).UTC()
+			t2 := user.JoinedAt.Truncate(time.Millisecond).UTC()
+			if t1 != t2 {
+				t.Errorf("expected user JoinedAt to match %s, but got %s", t2, t1)
+			}
+
+			if foundUser.Name != user.Name {
+				t.Errorf("expected user Names to match %s, but got %s", user.Name, foundUser.Name)
+			}
+
+			noUser, err := db.GetUserByName(ctx, "nobody")
+			if err != sql.ErrNoRows {
+				t.Errorf("expected to get sql.ErrNoRows, but got %v", err)
+			}
+
+			emptyUser := model.User{}
+			if noUser != emptyUser {
+				t.Errorf("expected to get empty user, but got %v", noUser)
+			}
+		})
 	}
+}
 
-	emptyUser := model.User{}
-	if noUser != emptyUser {
-		t.Errorf("expected to get empty user, but got %v", noUser)
+func TestUpdateUser(t *testing.T) {
+
+	ctx := context.Background()
+
+	for name, db := range openStores(t) {
+		t.Run(name, func(t *testing.T) {
+
+			_, err := db.CreateUser(ctx, model.NewUser("pdk"))
+			if err != nil {
+				t.Fatalf("expected to create user record, but failed: %v", err)
+			}
+
+			bob, created, err := db.GetOrCreateUserByName(ctx, "bob")
+			if err != nil || !created || bob.ID != 2 {
+				t.Errorf("expected to create bob with ID 2, but got %v, %t, %v", bob, created, err)
+			}
+
+			bob.Name = "pdk"
+			err = db.UpdateUser(ctx, bob)
+			if !store.IsDuplicate(err) {
+				t.Errorf("expected a duplicate error renaming bob to pdk, but got %v", err)
+			}
+
+			bob.Name = "robert"
+			bob.Banned = true
+			err = db.UpdateUser(ctx, bob)
+			if err != nil {
+				t.Errorf("expected to rename bob, but failed: %v", err)
+			}
+
+			users, err := db.ListUsers(ctx, store.AllRows)
+			if err != nil || len(users) != 2 || users[1].Name != "robert" || !users[1].Banned {
+				t.Errorf("expected pdk and a banned robert, but got %v, %v", users, err)
+			}
+		})
 	}
-
-	db.Close()
 }